@@ -1,60 +1,314 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/Fedorova199/red-cat/internal/app/certs"
 	"github.com/Fedorova199/red-cat/internal/app/config"
+	"github.com/Fedorova199/red-cat/internal/app/grpcserver"
 	"github.com/Fedorova199/red-cat/internal/app/handlers"
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/metrics"
 	"github.com/Fedorova199/red-cat/internal/app/middlewares"
 	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/app/tracing"
+	"github.com/Fedorova199/red-cat/internal/app/webhooks"
+	"github.com/Fedorova199/red-cat/internal/app/workers"
 	"github.com/Fedorova199/red-cat/internal/interfaces"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	serviceName     = "shortener"
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == migrateCommand {
+		if err := runMigrateData(os.Args[2:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	cfg, err := config.NewConfig()
 	if err != nil {
 		log.Fatalln(err)
 	}
-	db, err := sql.Open("pgx", cfg.DatabaseDSN)
+	level, err := logger.ParseLevel(cfg.Log.Level)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	defer db.Close()
+	appLogger, err := logger.New(os.Stderr, level, cfg.Log.Format)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	logger.SetDefault(appLogger)
+
+	tracer := tracing.NewTracer(newTraceExporter(cfg))
+	tracing.SetDefault(tracer)
 
-	storage, err := storage.CreateDatabase(db)
+	registry := metrics.NewRegistry()
+	backend, err := newStorage(cfg.Storage, registry)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	defer func() {
+		if err := backend.Close(); err != nil {
+			appLogger.Error("close storage", "error", err)
+		}
+	}()
+	measured := metrics.NewStorage(backend, registry)
 
-	ms := []interfaces.Middleware{
-		middlewares.GzipEncoder{},
-		middlewares.GzipDecoder{},
-		middlewares.NewAuth([]byte("secret key")),
+	// Events need short urls, which depend on the handler settings.
+	var handler *handlers.Handler
+	events := webhooks.NewNotifier(measured, func(id int) string {
+		return handler.Settings().ShortURL(id)
+	})
+	storage := webhooks.NewStorage(measured, events)
+
+	deleter := workers.NewDeleter(storage, cfg.Limits.DeleteQueueSize)
+	registry.NewGaugeFunc("shortener_delete_queue_depth", "Number of delete tasks waiting in the queue.", func() float64 {
+		return float64(deleter.Len())
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	purger := workers.NewPurger(storage, cfg.Deletion.RestoreWindow, cfg.Deletion.PurgeInterval)
+	dispatcher := webhooks.NewDispatcher(measured, events, cfg.Webhooks.Interval, cfg.Webhooks.Timeout, cfg.Webhooks.MaxAttempts, cfg.Webhooks.Retention)
+
+	rl := &reloader{
+		cfg:       cfg,
+		logger:    appLogger,
+		auth:      middlewares.NewAuth([]byte(cfg.Auth.SecretKey)),
+		bodyLimit: middlewares.NewBodyLimit(cfg.Limits.MaxBodyBytes),
+		rateLimit: middlewares.NewRateLimit(cfg.Limits.RateLimit, cfg.Limits.RateBurst),
 	}
 
-	handler := handlers.NewHandler(storage, cfg.BaseURL, ms)
+	handler = handlers.NewHandler(storage, cfg.BaseURL, newMiddlewares(cfg, rl, registry, appLogger, tracer))
+	handler.Deleter = deleter
+	handler.Events = events
+	handler.Redirects = registry.NewCounterVec("shortener_redirects_total", "Redirect lookups by result.", "result")
+	handler.Method(http.MethodGet, "/metrics", registry)
+
+	rl.handler = handler
+	rl.apply()
+
+	workersDone := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			deleter.Run(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			purger.Run(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			dispatcher.Run(ctx)
+		}()
+		wg.Wait()
+		close(workersDone)
+	}()
+
 	server := &http.Server{
 		Addr:    cfg.ServerAddress,
 		Handler: handler,
 	}
 
+	var (
+		certReloader   *certs.Reloader
+		redirectServer *http.Server
+		grpcServer     *grpc.Server
+	)
+	if cfg.TLS.Enabled {
+		certReloader, err = newCertReloader(cfg, appLogger)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		server.TLSConfig = certReloader.TLSConfig()
+		go certReloader.Watch(ctx, cfg.TLS.ReloadInterval)
+
+		if cfg.TLS.RedirectAddress != "" {
+			redirectServer = &http.Server{
+				Addr:    cfg.TLS.RedirectAddress,
+				Handler: handlers.HTTPSRedirect(cfg.ServerAddress),
+			}
+			go func() {
+				if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					appLogger.Error("redirect server stopped", "error", err)
+				}
+			}()
+		}
+	}
+
+	if cfg.GRPCAddress != "" {
+		service := grpcserver.NewServer(storage, handler.Settings)
+		service.Deleter = deleter
+		service.Events = events
+
+		var opts []grpc.ServerOption
+		if certReloader != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(certReloader.TLSConfig())))
+		}
+		grpcServer = grpcserver.New(service, rl.auth, appLogger, opts...)
+
+		listener, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				appLogger.Error("grpc server stopped", "error", err)
+			}
+		}()
+		appLogger.Info("grpc server started", "address", cfg.GRPCAddress)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			rl.reload()
+		}
+	}()
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c,
-		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
 
+	serverDone := make(chan struct{})
 	go func() {
-		<-c
-		server.Close()
+		sig := <-c
+		appLogger.Info("shutting down", "signal", sig.String())
+
+		// Keep serving while /readyz reports draining, so the load balancer
+		// stops sending traffic before the listeners close. A second signal
+		// cuts the wait short.
+		handler.SetDraining()
+		select {
+		case <-time.After(cfg.DrainDelay):
+		case sig := <-c:
+			appLogger.Warn("draining interrupted", "signal", sig.String())
+		}
+
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelShutdown()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			appLogger.Error("shutdown server", "error", err)
+		}
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		if redirectServer != nil {
+			if err := redirectServer.Shutdown(shutdownCtx); err != nil {
+				appLogger.Error("shutdown redirect server", "error", err)
+			}
+		}
+		close(serverDone)
 	}()
 
-	log.Fatal(server.ListenAndServe())
+	appLogger.Info("server started", "address", cfg.ServerAddress, "https", cfg.TLS.Enabled)
+	if cfg.TLS.Enabled {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		appLogger.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+
+	<-serverDone
+	cancel()
+	<-workersDone
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := tracer.Shutdown(shutdownCtx); err != nil {
+		appLogger.Error("shutdown tracer", "error", err)
+	}
+}
+
+type closableStorage interface {
+	interfaces.Storage
+	io.Closer
+}
+
+type databaseStorage struct {
+	*storage.Database
+	db *sql.DB
+}
+
+func (s databaseStorage) Close() error {
+	return s.db.Close()
+}
+
+func newStorage(cfg config.StorageConfig, registry *metrics.Registry) (closableStorage, error) {
+	if cfg.DatabaseDSN == "" {
+		return storage.NewModels(cfg.FileStoragePath, cfg.FileSyncInterval)
+	}
+
+	db, err := sql.Open("pgx", cfg.DatabaseDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := storage.CreateDatabase(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	metrics.RegisterDBStats(registry, db)
+
+	return databaseStorage{Database: database, db: db}, nil
+}
+
+// newMiddlewares lists the middlewares innermost first. The body limit is
+// inside the gzip decoder, so it counts decoded bytes.
+func newMiddlewares(cfg config.Config, rl *reloader, registry *metrics.Registry, log *logger.Logger, tracer *tracing.Tracer) []interfaces.Middleware {
+	ms := []interfaces.Middleware{
+		middlewares.GzipEncoder{},
+		rl.bodyLimit,
+		middlewares.GzipDecoder{},
+		rl.auth,
+		rl.rateLimit,
+		middlewares.NewMetrics(registry),
+		middlewares.NewLogging(log),
+		middlewares.NewTracing(tracer),
+	}
+	if cfg.TLS.Enabled && cfg.TLS.HSTSMaxAge > 0 {
+		ms = append([]interfaces.Middleware{middlewares.NewHSTS(cfg.TLS.HSTSMaxAge)}, ms...)
+	}
+
+	return ms
+}
+
+func newTraceExporter(cfg config.Config) tracing.Exporter {
+	switch cfg.Tracing.Exporter {
+	case "stdout":
+		return tracing.NewStdoutExporter(os.Stdout)
+	case "otlp":
+		return tracing.NewOTLPExporter(cfg.Tracing.OTLPEndpoint, serviceName, nil)
+	}
+
+	return nil
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Fedorova199/red-cat/internal/app/config"
+	"github.com/Fedorova199/red-cat/internal/app/handlers"
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/metrics"
+	"github.com/Fedorova199/red-cat/internal/app/middlewares"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/app/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMiddlewares_BodyLimit(t *testing.T) {
+	log, err := logger.New(io.Discard, logger.InfoLevel, logger.FormatJSON)
+	require.NoError(t, err)
+
+	rl := &reloader{
+		auth:      middlewares.NewAuth([]byte("key")),
+		bodyLimit: middlewares.NewBodyLimit(1024),
+		rateLimit: middlewares.NewRateLimit(100, 100),
+	}
+	models := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+	ms := newMiddlewares(config.Config{}, rl, metrics.NewRegistry(), log, tracing.NewTracer(nil))
+	handler := handlers.NewHandler(models, "http://short.ru", ms)
+
+	tests := []struct {
+		name       string
+		url        string
+		statusCode int
+	}{
+		{name: "small body #1", url: "http://test.ru/", statusCode: http.StatusCreated},
+		{name: "decoded body over the limit #2", url: "http://test.ru/" + strings.Repeat("a", 1<<16), statusCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			gz := gzip.NewWriter(&body)
+			_, err := gz.Write([]byte(`{"url":"` + tt.url + `"}`))
+			require.NoError(t, err)
+			require.NoError(t, gz.Close())
+			require.Less(t, body.Len(), 1024, "compressed body is over the limit")
+
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", &body)
+			req.Header.Set("Content-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+	assert.Len(t, models.Model, 1)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/migrate"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+)
+
+const migrateCommand = "migrate-data"
+
+// runMigrateData copies all records between the file storage and Postgres:
+//
+//	shortener migrate-data -from file -f urls.txt -d postgres://...
+//	shortener migrate-data -from postgres -d postgres://... -f urls.txt
+func runMigrateData(args []string) error {
+	fs := flag.NewFlagSet(migrateCommand, flag.ContinueOnError)
+	from := fs.String("from", "file", "source backend: file or postgres, the other one is the target")
+	filePath := fs.String("f", os.Getenv("FILE_STORAGE_PATH"), "storage file")
+	dsn := fs.String("d", os.Getenv("DATABASE_DSN"), "database connection string")
+	batchSize := fs.Int("batch-size", 1000, "records copied per batch")
+	checkpoint := fs.String("checkpoint", "migrate-data.checkpoint", "file that tracks progress so an interrupted run can resume")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *filePath == "" || *dsn == "" {
+		return errors.New("both -f and -d are required")
+	}
+	if *from != "file" && *from != "postgres" {
+		return fmt.Errorf("unknown source %q, want file or postgres", *from)
+	}
+
+	log, err := logger.New(os.Stderr, logger.InfoLevel, logger.FormatText)
+	if err != nil {
+		return err
+	}
+	logger.SetDefault(log)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	models, err := storage.NewModels(*filePath, time.Hour)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := models.Close(); err != nil {
+			log.Error("close storage file", "error", err)
+		}
+	}()
+
+	db, err := sql.Open("pgx", *dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	database, err := storage.CreateDatabase(db)
+	if err != nil {
+		return err
+	}
+
+	var src migrate.Source = models
+	var dst migrate.Target = database
+	if *from == "postgres" {
+		src, dst = database, models
+	}
+
+	result, err := migrate.Run(ctx, src, dst, migrate.Options{
+		BatchSize:  *batchSize,
+		Checkpoint: *checkpoint,
+		Logger:     log,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("migration finished", "copied", result.Copied, "total", result.Total, "deleted", result.Deleted)
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+
+	"github.com/Fedorova199/red-cat/internal/app/config"
+	"github.com/Fedorova199/red-cat/internal/app/handlers"
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/middlewares"
+)
+
+type reloader struct {
+	cfg       config.Config
+	logger    *logger.Logger
+	auth      *middlewares.Auth
+	bodyLimit *middlewares.BodyLimit
+	rateLimit *middlewares.RateLimit
+	handler   *handlers.Handler
+}
+
+func (rl *reloader) reload() {
+	cfg, err := config.NewConfig()
+	if err != nil {
+		rl.logger.Error("reload config, keeping current settings", "error", err)
+		return
+	}
+
+	changes := config.Diff(rl.cfg, cfg)
+	if len(changes) == 0 {
+		rl.logger.Info("config reloaded, nothing changed")
+		return
+	}
+
+	for _, change := range changes {
+		if change.Reloadable {
+			rl.logger.Info("config setting changed", "key", change.Key, "old", change.Old, "new", change.New)
+		} else {
+			rl.logger.Warn("config setting requires restart, ignored", "key", change.Key, "old", change.Old, "new", change.New)
+		}
+	}
+
+	rl.cfg.BaseURL = cfg.BaseURL
+	rl.cfg.TrustedSubnet = cfg.TrustedSubnet
+	rl.cfg.Redirect = cfg.Redirect
+	rl.cfg.CountryHeader = cfg.CountryHeader
+	rl.cfg.Passthrough = cfg.Passthrough
+	rl.cfg.Auth = cfg.Auth
+	rl.cfg.Admin = cfg.Admin
+	rl.cfg.Limits.MaxBodyBytes = cfg.Limits.MaxBodyBytes
+	rl.cfg.Limits.MaxBatchSize = cfg.Limits.MaxBatchSize
+	rl.cfg.Limits.RateLimit = cfg.Limits.RateLimit
+	rl.cfg.Limits.RateBurst = cfg.Limits.RateBurst
+	rl.cfg.Blocklist = cfg.Blocklist
+	rl.cfg.Log.Level = cfg.Log.Level
+
+	rl.apply()
+}
+
+func (rl *reloader) apply() {
+	cfg := rl.cfg
+
+	if level, err := logger.ParseLevel(cfg.Log.Level); err == nil {
+		rl.logger.SetLevel(level)
+	}
+
+	previousKeys := make([][]byte, 0, len(cfg.Auth.PreviousKeys))
+	for _, key := range cfg.Auth.PreviousKeys {
+		previousKeys = append(previousKeys, []byte(key))
+	}
+	rl.auth.SetKeys([]byte(cfg.Auth.SecretKey), previousKeys...)
+
+	rl.bodyLimit.SetMaxBytes(cfg.Limits.MaxBodyBytes)
+	rl.rateLimit.SetLimit(cfg.Limits.RateLimit, cfg.Limits.RateBurst)
+
+	var trustedSubnet *net.IPNet
+	if cfg.TrustedSubnet != "" {
+		_, trustedSubnet, _ = net.ParseCIDR(cfg.TrustedSubnet)
+	}
+
+	rl.handler.SetSettings(handlers.Settings{
+		BaseURL:          cfg.BaseURL,
+		MaxBatchSize:     cfg.Limits.MaxBatchSize,
+		BlockedHosts:     cfg.Blocklist.Hosts,
+		TrustedSubnet:    trustedSubnet,
+		AdminToken:       cfg.Admin.Token,
+		RestoreWindow:    cfg.Deletion.RestoreWindow,
+		Redirect:         cfg.Redirect,
+		UnlockKey:        []byte(cfg.Auth.SecretKey),
+		CountryHeader:    cfg.CountryHeader,
+		QueryPassthrough: cfg.Passthrough,
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/config"
+	"github.com/Fedorova199/red-cat/internal/app/handlers"
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/middlewares"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sign(key, userID string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(userID))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestReloader_Apply(t *testing.T) {
+	log, err := logger.New(io.Discard, logger.InfoLevel, logger.FormatJSON)
+	require.NoError(t, err)
+
+	models := &storage.Models{Model: map[int]storage.CreateURL{}}
+	rl := &reloader{
+		cfg: config.Config{
+			BaseURL:       "http://localhost:8080",
+			TrustedSubnet: "10.0.0.0/8",
+			Auth:          config.AuthConfig{SecretKey: "new", PreviousKeys: []string{"old"}},
+			Admin:         config.AdminConfig{Token: "token"},
+			Limits:        config.LimitsConfig{MaxBodyBytes: 1024, MaxBatchSize: 10, RateLimit: 1, RateBurst: 1},
+			Blocklist:     config.Blocklist{Hosts: []string{"evil.ru"}},
+			Deletion:      config.DeletionConfig{RestoreWindow: time.Hour},
+			Redirect:      "permanent",
+			CountryHeader: "CF-IPCountry",
+			Passthrough:   "all",
+			Log:           config.LogConfig{Level: "debug"},
+		},
+		logger:    log,
+		auth:      middlewares.NewAuth([]byte("old")),
+		bodyLimit: middlewares.NewBodyLimit(1 << 20),
+		rateLimit: middlewares.NewRateLimit(100, 100),
+		handler:   handlers.NewHandler(models, "http://localhost:8080", nil),
+	}
+
+	rl.cfg.BaseURL = "https://short.ru"
+	rl.apply()
+
+	settings := rl.handler.Settings()
+	assert.Equal(t, "https://short.ru", settings.BaseURL)
+	assert.Equal(t, "https://short.ru/7", settings.ShortURL(7))
+	assert.Equal(t, 10, settings.MaxBatchSize)
+	assert.True(t, settings.Blocked("https://evil.ru/page"))
+	assert.Equal(t, "10.0.0.0/8", settings.TrustedSubnet.String())
+	assert.Equal(t, "token", settings.AdminToken)
+	assert.Equal(t, time.Hour, settings.RestoreWindow)
+	assert.Equal(t, "permanent", settings.Redirect)
+	assert.Equal(t, "CF-IPCountry", settings.CountryHeader)
+	assert.Equal(t, "all", settings.QueryPassthrough)
+	assert.Equal(t, []byte("new"), settings.UnlockKey)
+	assert.Equal(t, logger.DebugLevel, log.Level())
+
+	userID, resigned := rl.auth.Identify("user", sign("old", "user"))
+	assert.Equal(t, "user", userID)
+	assert.Equal(t, sign("new", "user"), resigned)
+
+	allowed, _ := rl.rateLimit.Allow("client")
+	assert.True(t, allowed)
+	allowed, _ = rl.rateLimit.Allow("client")
+	assert.False(t, allowed, "burst of the new limit not applied")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/certs"
+	"github.com/Fedorova199/red-cat/internal/app/config"
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+)
+
+const selfSignedValidity = 365 * 24 * time.Hour
+
+func newCertReloader(cfg config.Config, log *logger.Logger) (*certs.Reloader, error) {
+	if cfg.TLS.SelfSigned {
+		_, err := os.Stat(cfg.TLS.CertFile)
+		if errors.Is(err, os.ErrNotExist) {
+			hosts := selfSignedHosts(cfg.BaseURL)
+			if err := certs.WriteSelfSigned(cfg.TLS.CertFile, cfg.TLS.KeyFile, hosts, selfSignedValidity); err != nil {
+				return nil, err
+			}
+			log.Warn("generated self-signed certificate, do not use in production", "cert_file", cfg.TLS.CertFile, "hosts", hosts)
+		}
+	}
+
+	return certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+}
+
+func selfSignedHosts(baseURL string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Hostname() == "" {
+		return hosts
+	}
+
+	host := u.Hostname()
+	for _, h := range hosts {
+		if h == host {
+			return hosts
+		}
+	}
+
+	return append([]string{host}, hosts...)
+}
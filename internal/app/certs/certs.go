@@ -0,0 +1,220 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader serves a certificate loaded from disk and picks up new versions
+// of the cert and key files without restarting the server.
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	state [2]fileState
+}
+
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Reloader) Reload() error {
+	state, err := r.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.state = state
+
+	return nil
+}
+
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// Watch polls the cert and key files and reloads them when they change.
+// A pair that fails to load is skipped so a half-written update never
+// replaces a working certificate.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.changed()
+			if err != nil {
+				logger.Default().Warn("check tls certificate", "error", err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+
+			if err := r.Reload(); err != nil {
+				logger.Default().Error("reload tls certificate", "cert_file", r.certFile, "error", err)
+				continue
+			}
+			logger.Default().Info("tls certificate reloaded", "cert_file", r.certFile)
+		}
+	}
+}
+
+func (r *Reloader) changed() (bool, error) {
+	state, err := r.stat()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return state != r.state, nil
+}
+
+func (r *Reloader) stat() ([2]fileState, error) {
+	var state [2]fileState
+	for i, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return state, err
+		}
+		state[i] = fileState{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	return state, nil
+}
+
+// GenerateSelfSigned returns a PEM encoded certificate and key valid for
+// the given hosts and IP addresses. It is meant for development only.
+func GenerateSelfSigned(hosts []string, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("at least one host is required")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	notBefore := time.Now().Add(-time.Minute)
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"shortener development"}, CommonName: hosts[0]},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var certBuf, keyBuf bytes.Buffer
+	if err := pem.Encode(&certBuf, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		return nil, nil, err
+	}
+	if err := pem.Encode(&keyBuf, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}); err != nil {
+		return nil, nil, err
+	}
+
+	return certBuf.Bytes(), keyBuf.Bytes(), nil
+}
+
+func WriteSelfSigned(certFile, keyFile string, hosts []string, validFor time.Duration) error {
+	certPEM, keyPEM, err := GenerateSelfSigned(hosts, validFor)
+	if err != nil {
+		return err
+	}
+
+	// The key goes first: the reloader only picks up a pair once the
+	// certificate file changes, and by then the matching key is in place.
+	if err := writeFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+
+	return writeFile(certFile, certPEM, 0644)
+}
+
+func writeFile(name string, data []byte, perm os.FileMode) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,92 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serverCert(url string, certFile string) (*x509.Certificate, error) {
+	pemData, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pemData)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		DisableKeepAlives: true,
+	}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return resp.TLS.PeerCertificates[0], nil
+}
+
+func TestReloader_Watch(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, WriteSelfSigned(certFile, keyFile, []string{"127.0.0.1"}, time.Hour))
+
+	reloader, err := NewReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.TLSConfig())
+	require.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go server.Serve(listener)
+	defer server.Close()
+	url := "https://" + listener.Addr().String()
+
+	first, err := serverCert(url, certFile)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", first.IPAddresses[0].String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	// a broken pair must not replace the working certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0644))
+	time.Sleep(50 * time.Millisecond)
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.Raw, cert.Certificate[0])
+
+	require.NoError(t, WriteSelfSigned(certFile, keyFile, []string{"127.0.0.1"}, time.Hour))
+	assert.Eventually(t, func() bool {
+		cert, err := serverCert(url, certFile)
+		return err == nil && cert.SerialNumber.Cmp(first.SerialNumber) != 0
+	}, time.Second, 20*time.Millisecond)
+}
+
+func TestGenerateSelfSigned(t *testing.T) {
+	certPEM, keyPEM, err := GenerateSelfSigned([]string{"example.com", "::1"}, time.Hour)
+	require.NoError(t, err)
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, cert.DNSNames)
+	assert.Len(t, cert.IPAddresses, 1)
+	assert.NoError(t, cert.VerifyHostname("example.com"))
+
+	_, _, err = GenerateSelfSigned(nil, time.Hour)
+	assert.Error(t, err)
+}
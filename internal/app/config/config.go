@@ -1,76 +1,528 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	ServerAddress   string `env:"SERVER_ADDRESS"`
-	BaseURL         string `env:"BASE_URL"`
-	FileStoragePath string `env:"FILE_STORAGE_PATH"`
-	DatabaseDSN     string `env:"DATABASE_DSN"`
+	ServerAddress string         `env:"SERVER_ADDRESS" yaml:"server_address"`
+	BaseURL       string         `env:"BASE_URL" yaml:"base_url"`
+	GRPCAddress   string         `env:"GRPC_ADDRESS" yaml:"grpc_address"`
+	TrustedSubnet string         `env:"TRUSTED_SUBNET" yaml:"trusted_subnet"`
+	Redirect      string         `env:"DEFAULT_REDIRECT" yaml:"default_redirect"`
+	CountryHeader string         `env:"COUNTRY_HEADER" yaml:"country_header"`
+	Passthrough   string         `env:"QUERY_PASSTHROUGH" yaml:"query_passthrough"`
+	DrainDelay    time.Duration  `env:"DRAIN_DELAY" yaml:"drain_delay"`
+	Storage       StorageConfig  `yaml:"storage"`
+	Auth          AuthConfig     `yaml:"auth"`
+	Admin         AdminConfig    `yaml:"admin"`
+	Limits        LimitsConfig   `yaml:"limits"`
+	Blocklist     Blocklist      `yaml:"blocklist"`
+	Deletion      DeletionConfig `yaml:"deletion"`
+	Webhooks      WebhooksConfig `yaml:"webhooks"`
+	TLS           TLSConfig      `yaml:"tls"`
+	Log           LogConfig      `yaml:"log"`
+	Tracing       TracingConfig  `yaml:"tracing"`
+}
+
+type StorageConfig struct {
+	FileStoragePath  string        `env:"FILE_STORAGE_PATH" yaml:"file_storage_path"`
+	DatabaseDSN      string        `env:"DATABASE_DSN" yaml:"database_dsn"`
+	FileSyncInterval time.Duration `env:"FILE_SYNC_INTERVAL" yaml:"file_sync_interval"`
+}
+
+type AuthConfig struct {
+	SecretKey    string   `env:"AUTH_SECRET_KEY" yaml:"secret_key"`
+	PreviousKeys []string `env:"AUTH_PREVIOUS_KEYS" envSeparator:"," yaml:"previous_keys"`
+}
+
+type AdminConfig struct {
+	Token string `env:"ADMIN_TOKEN" yaml:"token"`
+}
+
+type LimitsConfig struct {
+	MaxBodyBytes    int64   `env:"MAX_BODY_BYTES" yaml:"max_body_bytes"`
+	MaxBatchSize    int     `env:"MAX_BATCH_SIZE" yaml:"max_batch_size"`
+	RateLimit       float64 `env:"RATE_LIMIT" yaml:"rate_limit"`
+	RateBurst       int     `env:"RATE_BURST" yaml:"rate_burst"`
+	DeleteQueueSize int     `env:"DELETE_QUEUE_SIZE" yaml:"delete_queue_size"`
+}
+
+type Blocklist struct {
+	Hosts []string `env:"BLOCKED_HOSTS" envSeparator:"," yaml:"hosts"`
+}
+
+type DeletionConfig struct {
+	RestoreWindow time.Duration `env:"RESTORE_WINDOW" yaml:"restore_window"`
+	PurgeInterval time.Duration `env:"PURGE_INTERVAL" yaml:"purge_interval"`
+}
+
+type WebhooksConfig struct {
+	Interval    time.Duration `env:"WEBHOOK_INTERVAL" yaml:"interval"`
+	Timeout     time.Duration `env:"WEBHOOK_TIMEOUT" yaml:"timeout"`
+	MaxAttempts int           `env:"WEBHOOK_MAX_ATTEMPTS" yaml:"max_attempts"`
+	Retention   time.Duration `env:"WEBHOOK_RETENTION" yaml:"retention"`
+}
+
+type TLSConfig struct {
+	Enabled         bool          `env:"ENABLE_HTTPS" yaml:"enabled"`
+	CertFile        string        `env:"TLS_CERT_FILE" yaml:"cert_file"`
+	KeyFile         string        `env:"TLS_KEY_FILE" yaml:"key_file"`
+	SelfSigned      bool          `env:"TLS_SELF_SIGNED" yaml:"self_signed"`
+	ReloadInterval  time.Duration `env:"TLS_RELOAD_INTERVAL" yaml:"reload_interval"`
+	RedirectAddress string        `env:"TLS_REDIRECT_ADDRESS" yaml:"redirect_address"`
+	HSTSMaxAge      time.Duration `env:"HSTS_MAX_AGE" yaml:"hsts_max_age"`
+}
+
+type LogConfig struct {
+	Level  string `env:"LOG_LEVEL" yaml:"level"`
+	Format string `env:"LOG_FORMAT" yaml:"format"`
+}
+
+type TracingConfig struct {
+	Exporter     string `env:"TRACE_EXPORTER" yaml:"exporter"`
+	OTLPEndpoint string `env:"OTLP_ENDPOINT" yaml:"otlp_endpoint"`
 }
 
 const (
-	defaultServerAddress = ":8080"
-	defaultBaseURL       = "http://localhost:8080"
+	defaultServerAddress    = ":8080"
+	defaultBaseURL          = "http://localhost:8080"
+	defaultRedirect         = storage.RedirectTemporary
+	defaultCountryHeader    = "X-Country-Code"
+	defaultPassthrough      = storage.PassthroughNone
+	defaultFileStoragePath  = "test.txt"
+	defaultFileSyncInterval = time.Minute
+	defaultSecretKey        = "secret key"
+	defaultMaxBodyBytes     = 1 << 20
+	defaultMaxBatchSize     = 1000
+	defaultDeleteQueueSize  = 1024
+	defaultTLSReload        = 30 * time.Second
+	defaultRestoreWindow    = 30 * 24 * time.Hour
+	defaultPurgeInterval    = time.Hour
+	defaultWebhookInterval  = 5 * time.Second
+	defaultWebhookTimeout   = 10 * time.Second
+	defaultWebhookAttempts  = 8
+	defaultWebhookRetention = 7 * 24 * time.Hour
+	defaultDrainDelay       = 5 * time.Second
+	defaultLogLevel         = "info"
+	defaultLogFormat        = "json"
+
+	configFileEnv = "CONFIG"
 )
 
 var defaultConfig = Config{
 	ServerAddress: defaultServerAddress,
 	BaseURL:       defaultBaseURL,
+	Redirect:      defaultRedirect,
+	CountryHeader: defaultCountryHeader,
+	Passthrough:   defaultPassthrough,
+	DrainDelay:    defaultDrainDelay,
+	Storage: StorageConfig{
+		FileStoragePath:  defaultFileStoragePath,
+		FileSyncInterval: defaultFileSyncInterval,
+	},
+	Auth: AuthConfig{
+		SecretKey: defaultSecretKey,
+	},
+	Limits: LimitsConfig{
+		MaxBodyBytes:    defaultMaxBodyBytes,
+		MaxBatchSize:    defaultMaxBatchSize,
+		DeleteQueueSize: defaultDeleteQueueSize,
+	},
+	Deletion: DeletionConfig{
+		RestoreWindow: defaultRestoreWindow,
+		PurgeInterval: defaultPurgeInterval,
+	},
+	Webhooks: WebhooksConfig{
+		Interval:    defaultWebhookInterval,
+		Timeout:     defaultWebhookTimeout,
+		MaxAttempts: defaultWebhookAttempts,
+		Retention:   defaultWebhookRetention,
+	},
+	TLS: TLSConfig{
+		ReloadInterval: defaultTLSReload,
+	},
+	Log: LogConfig{
+		Level:  defaultLogLevel,
+		Format: defaultLogFormat,
+	},
 }
 
+// NewConfig builds the configuration with the precedence
+// defaults < config file < environment < command line flags.
 func NewConfig() (Config, error) {
+	return Load(os.Args[1:], environ())
+}
+
+func Load(args []string, environment map[string]string) (Config, error) {
 	conf := defaultConfig
-	conf.parseFlags()
-	conf.parseEnvVars()
+
+	flags := newFlagSet(defaultConfig)
+	if err := flags.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	path := environment[configFileEnv]
+	if flags.configFile != "" {
+		path = flags.configFile
+	}
+	if path != "" {
+		if err := conf.parseFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := env.Parse(&conf, env.Options{Environment: environment}); err != nil {
+		return Config{}, err
+	}
+
+	flags.apply(&conf)
+
 	err := conf.Validate()
 	return conf, err
 }
 
-//
-func (conf *Config) parseFlags() {
+func (conf *Config) parseFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	// JSON documents are valid YAML, so a single strict decoder handles
+	// both formats and rejects unknown keys.
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(conf); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
 
-	flag.StringVar(&conf.ServerAddress, "a", defaultServerAddress, "network address the server listens on")
-	flag.StringVar(&conf.BaseURL, "b", defaultBaseURL, "resulting base URL")
-	flag.StringVar(&conf.FileStoragePath, "f", "test.txt", "storage file")
-	flag.StringVar(&conf.DatabaseDSN, "d", "", `database dsn (default "")`)
-	flag.Parse()
+	return nil
+}
 
+type flagSet struct {
+	*flag.FlagSet
+	values     Config
+	configFile string
+	copiers    map[string]func(dst, src *Config)
 }
 
-func (conf *Config) parseEnvVars() {
-	sa := os.Getenv("SERVER_ADDRESS")
-	if sa != "" {
-		conf.ServerAddress = sa
+func newFlagSet(defaults Config) *flagSet {
+	fs := &flagSet{
+		FlagSet: flag.NewFlagSet(os.Args[0], flag.ContinueOnError),
+		values:  defaults,
+		copiers: make(map[string]func(dst, src *Config)),
 	}
 
-	bu := os.Getenv("BASE_URL")
-	if bu != "" {
-		conf.BaseURL = bu
+	fs.StringVar(&fs.configFile, "c", "", "path to a JSON or YAML config file")
+	fs.StringVar(&fs.configFile, "config", "", "path to a JSON or YAML config file")
+
+	fs.stringFlag("a", "network address the server listens on", func(c *Config) *string { return &c.ServerAddress })
+	fs.stringFlag("b", "resulting base URL", func(c *Config) *string { return &c.BaseURL })
+	fs.stringFlag("default-redirect", "redirect for links created without one: 301, 302, 307, 308 or interstitial", func(c *Config) *string { return &c.Redirect })
+	fs.stringFlag("query-passthrough", "query parameters copied into destinations of links without their own setting: none, utm or all", func(c *Config) *string { return &c.Passthrough })
+	fs.stringFlag("country-header", "request header carrying the visitor country for routing rules (empty disables country rules)", func(c *Config) *string { return &c.CountryHeader })
+	fs.stringFlag("admin-token", "bearer token for the admin API (default disabled)", func(c *Config) *string { return &c.Admin.Token })
+	fs.stringFlag("t", "CIDR allowed to read internal stats (default nobody)", func(c *Config) *string { return &c.TrustedSubnet })
+	fs.stringFlag("g", "network address of the gRPC server (default disabled)", func(c *Config) *string { return &c.GRPCAddress })
+	fs.durationFlag("drain-delay", "how long /readyz reports draining before the servers stop on shutdown", func(c *Config) *time.Duration { return &c.DrainDelay })
+	fs.stringFlag("f", "storage file", func(c *Config) *string { return &c.Storage.FileStoragePath })
+	fs.stringFlag("d", "database dsn", func(c *Config) *string { return &c.Storage.DatabaseDSN })
+	fs.durationFlag("file-sync-interval", "how often the storage file is rewritten", func(c *Config) *time.Duration { return &c.Storage.FileSyncInterval })
+	fs.stringFlag("auth-secret", "key used to sign user cookies", func(c *Config) *string { return &c.Auth.SecretKey })
+	fs.listFlag("auth-previous-keys", "comma separated keys still accepted for cookie verification", func(c *Config) *[]string { return &c.Auth.PreviousKeys })
+	fs.int64Flag("max-body-bytes", "maximum request body size in bytes, 0 disables the limit", func(c *Config) *int64 { return &c.Limits.MaxBodyBytes })
+	fs.intFlag("max-batch-size", "maximum number of URLs in a batch request, 0 disables the limit", func(c *Config) *int { return &c.Limits.MaxBatchSize })
+	fs.floatFlag("rate-limit", "requests per second allowed per client, 0 disables rate limiting", func(c *Config) *float64 { return &c.Limits.RateLimit })
+	fs.intFlag("rate-burst", "request burst allowed per client", func(c *Config) *int { return &c.Limits.RateBurst })
+	fs.intFlag("delete-queue-size", "capacity of the background delete queue", func(c *Config) *int { return &c.Limits.DeleteQueueSize })
+	fs.listFlag("blocked-hosts", "comma separated destination hosts that cannot be shortened", func(c *Config) *[]string { return &c.Blocklist.Hosts })
+	fs.durationFlag("restore-window", "how long deleted urls can be restored before they are purged", func(c *Config) *time.Duration { return &c.Deletion.RestoreWindow })
+	fs.durationFlag("purge-interval", "how often expired deleted urls are purged", func(c *Config) *time.Duration { return &c.Deletion.PurgeInterval })
+	fs.durationFlag("webhook-interval", "how often queued webhook deliveries are sent", func(c *Config) *time.Duration { return &c.Webhooks.Interval })
+	fs.durationFlag("webhook-timeout", "timeout of one webhook delivery attempt", func(c *Config) *time.Duration { return &c.Webhooks.Timeout })
+	fs.intFlag("webhook-max-attempts", "delivery attempts before a webhook delivery is dead", func(c *Config) *int { return &c.Webhooks.MaxAttempts })
+	fs.durationFlag("webhook-retention", "how long delivered and dead webhook deliveries are kept", func(c *Config) *time.Duration { return &c.Webhooks.Retention })
+	fs.boolFlag("s", "serve HTTPS", func(c *Config) *bool { return &c.TLS.Enabled })
+	fs.stringFlag("tls-cert", "TLS certificate file", func(c *Config) *string { return &c.TLS.CertFile })
+	fs.stringFlag("tls-key", "TLS private key file", func(c *Config) *string { return &c.TLS.KeyFile })
+	fs.boolFlag("tls-self-signed", "generate a self-signed certificate if the cert files are missing (development only)", func(c *Config) *bool { return &c.TLS.SelfSigned })
+	fs.durationFlag("tls-reload-interval", "how often the cert files are checked for changes", func(c *Config) *time.Duration { return &c.TLS.ReloadInterval })
+	fs.stringFlag("tls-redirect-address", "address of a plain HTTP listener redirecting to HTTPS", func(c *Config) *string { return &c.TLS.RedirectAddress })
+	fs.durationFlag("hsts-max-age", "Strict-Transport-Security max-age, 0 disables the header", func(c *Config) *time.Duration { return &c.TLS.HSTSMaxAge })
+	fs.stringFlag("log-level", "log level: debug, info, warn or error", func(c *Config) *string { return &c.Log.Level })
+	fs.stringFlag("log-format", "log format: json or text", func(c *Config) *string { return &c.Log.Format })
+	fs.stringFlag("trace-exporter", "trace exporter: stdout or otlp (default disabled)", func(c *Config) *string { return &c.Tracing.Exporter })
+	fs.stringFlag("otlp-endpoint", "OTLP/HTTP collector endpoint, e.g. http://localhost:4318", func(c *Config) *string { return &c.Tracing.OTLPEndpoint })
+
+	return fs
+}
+
+func (fs *flagSet) stringFlag(name, usage string, field func(c *Config) *string) {
+	fs.StringVar(field(&fs.values), name, *field(&fs.values), usage)
+	fs.copiers[name] = func(dst, src *Config) { *field(dst) = *field(src) }
+}
+
+func (fs *flagSet) intFlag(name, usage string, field func(c *Config) *int) {
+	fs.IntVar(field(&fs.values), name, *field(&fs.values), usage)
+	fs.copiers[name] = func(dst, src *Config) { *field(dst) = *field(src) }
+}
+
+func (fs *flagSet) int64Flag(name, usage string, field func(c *Config) *int64) {
+	fs.Int64Var(field(&fs.values), name, *field(&fs.values), usage)
+	fs.copiers[name] = func(dst, src *Config) { *field(dst) = *field(src) }
+}
+
+func (fs *flagSet) floatFlag(name, usage string, field func(c *Config) *float64) {
+	fs.Float64Var(field(&fs.values), name, *field(&fs.values), usage)
+	fs.copiers[name] = func(dst, src *Config) { *field(dst) = *field(src) }
+}
+
+func (fs *flagSet) boolFlag(name, usage string, field func(c *Config) *bool) {
+	fs.BoolVar(field(&fs.values), name, *field(&fs.values), usage)
+	fs.copiers[name] = func(dst, src *Config) { *field(dst) = *field(src) }
+}
+
+func (fs *flagSet) durationFlag(name, usage string, field func(c *Config) *time.Duration) {
+	fs.DurationVar(field(&fs.values), name, *field(&fs.values), usage)
+	fs.copiers[name] = func(dst, src *Config) { *field(dst) = *field(src) }
+}
+
+func (fs *flagSet) listFlag(name, usage string, field func(c *Config) *[]string) {
+	fs.Func(name, usage, func(value string) error {
+		*field(&fs.values) = splitList(value)
+		return nil
+	})
+	fs.copiers[name] = func(dst, src *Config) { *field(dst) = *field(src) }
+}
+
+// apply copies only the flags given on the command line, so unset flags
+// do not override values from the file or environment with defaults.
+func (fs *flagSet) apply(conf *Config) {
+	fs.Visit(func(f *flag.Flag) {
+		if copyValue, ok := fs.copiers[f.Name]; ok {
+			copyValue(conf, &fs.values)
+		}
+	})
+}
+
+func (conf *Config) Validate() error {
+	conf.ServerAddress = strings.TrimSpace(conf.ServerAddress)
+	conf.BaseURL = strings.TrimRight(strings.TrimSpace(conf.BaseURL), "/")
+	conf.GRPCAddress = strings.TrimSpace(conf.GRPCAddress)
+	conf.TrustedSubnet = strings.TrimSpace(conf.TrustedSubnet)
+	conf.Redirect = strings.ToLower(strings.TrimSpace(conf.Redirect))
+	conf.CountryHeader = http.CanonicalHeaderKey(strings.TrimSpace(conf.CountryHeader))
+	conf.Passthrough = strings.ToLower(strings.TrimSpace(conf.Passthrough))
+	conf.Storage.FileStoragePath = strings.TrimSpace(conf.Storage.FileStoragePath)
+	conf.Storage.DatabaseDSN = strings.TrimSpace(conf.Storage.DatabaseDSN)
+
+	if err := validateAddress(conf.ServerAddress); err != nil {
+		return fmt.Errorf("server address %q: %w", conf.ServerAddress, err)
 	}
 
-	fsp := os.Getenv("FILE_STORAGE_PATH")
-	if fsp != "" {
+	if err := validateBaseURL(conf.BaseURL); err != nil {
+		return fmt.Errorf("base URL %q: %w", conf.BaseURL, err)
+	}
 
-		conf.FileStoragePath = fsp
+	if conf.GRPCAddress != "" {
+		if err := validateAddress(conf.GRPCAddress); err != nil {
+			return fmt.Errorf("grpc address %q: %w", conf.GRPCAddress, err)
+		}
 	}
 
-	dd, ok := os.LookupEnv("DATABASE_DSN")
-	if ok {
+	if conf.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(conf.TrustedSubnet); err != nil {
+			return fmt.Errorf("trusted subnet %q: %w", conf.TrustedSubnet, err)
+		}
+	}
 
-		conf.DatabaseDSN = dd
+	if !storage.ValidRedirect(conf.Redirect) {
+		return fmt.Errorf("default redirect: unknown kind %q", conf.Redirect)
 	}
+
+	if strings.ContainsAny(conf.CountryHeader, " \t:()<>@,;\\\"/[]?={}") {
+		return fmt.Errorf("country header %q: not a valid header name", conf.CountryHeader)
+	}
+
+	if !storage.ValidPassthrough(conf.Passthrough) {
+		return fmt.Errorf("query passthrough: unknown mode %q", conf.Passthrough)
+	}
+
+	if conf.DrainDelay < 0 {
+		return errors.New("drain delay must not be negative")
+	}
+
+	if conf.Storage.FileStoragePath == "" && conf.Storage.DatabaseDSN == "" {
+		return errors.New("storage: either a file storage path or a database dsn is required")
+	}
+	if conf.Storage.FileSyncInterval <= 0 {
+		return errors.New("storage: file sync interval must be positive")
+	}
+
+	if conf.Auth.SecretKey == "" {
+		return errors.New("auth: secret key must not be empty")
+	}
+	for _, key := range conf.Auth.PreviousKeys {
+		if key == "" {
+			return errors.New("auth: previous keys must not be empty")
+		}
+	}
+
+	for i, host := range conf.Blocklist.Hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" || strings.ContainsAny(host, "/:@ ") {
+			return fmt.Errorf("blocklist: invalid host %q", conf.Blocklist.Hosts[i])
+		}
+		conf.Blocklist.Hosts[i] = host
+	}
+
+	if err := conf.Limits.validate(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+
+	if conf.Deletion.RestoreWindow <= 0 || conf.Deletion.PurgeInterval <= 0 {
+		return errors.New("deletion: restore window and purge interval must be positive")
+	}
+
+	if conf.Webhooks.Interval <= 0 || conf.Webhooks.Timeout <= 0 || conf.Webhooks.MaxAttempts < 1 || conf.Webhooks.Retention <= 0 {
+		return errors.New("webhooks: interval, timeout, max attempts and retention must be positive")
+	}
+
+	if err := conf.TLS.validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	if _, err := logger.ParseLevel(conf.Log.Level); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	switch conf.Log.Format {
+	case logger.FormatJSON, logger.FormatText:
+	default:
+		return fmt.Errorf("log: unknown format %q", conf.Log.Format)
+	}
+
+	switch conf.Tracing.Exporter {
+	case "", "none", "stdout":
+	case "otlp":
+		if conf.Tracing.OTLPEndpoint == "" {
+			return errors.New("tracing: otlp exporter requires an endpoint")
+		}
+		if err := validateBaseURL(conf.Tracing.OTLPEndpoint); err != nil {
+			return fmt.Errorf("tracing: otlp endpoint %q: %w", conf.Tracing.OTLPEndpoint, err)
+		}
+	default:
+		return fmt.Errorf("tracing: unknown exporter %q", conf.Tracing.Exporter)
+	}
+
+	return nil
 }
 
-func (conf *Config) Validate() error {
+func (t TLSConfig) validate() error {
+	if !t.Enabled {
+		return nil
+	}
 
-	conf.ServerAddress = strings.TrimSpace(conf.ServerAddress)
-	conf.BaseURL = strings.TrimSpace(conf.BaseURL)
-	conf.FileStoragePath = strings.TrimSpace(conf.FileStoragePath)
+	switch {
+	case t.CertFile == "" || t.KeyFile == "":
+		return errors.New("cert and key files are required when HTTPS is enabled")
+	case t.ReloadInterval <= 0:
+		return errors.New("reload interval must be positive")
+	case t.HSTSMaxAge < 0:
+		return errors.New("hsts max age must not be negative")
+	}
+
+	if t.RedirectAddress != "" {
+		if err := validateAddress(t.RedirectAddress); err != nil {
+			return fmt.Errorf("redirect address %q: %w", t.RedirectAddress, err)
+		}
+	}
+
+	return nil
+}
+
+func (l LimitsConfig) validate() error {
+	switch {
+	case l.MaxBodyBytes < 0:
+		return errors.New("max body bytes must not be negative")
+	case l.MaxBatchSize < 0:
+		return errors.New("max batch size must not be negative")
+	case l.RateLimit < 0:
+		return errors.New("rate limit must not be negative")
+	case l.RateLimit > 0 && l.RateBurst < 1:
+		return errors.New("rate burst must be at least 1 when rate limiting is enabled")
+	case l.DeleteQueueSize < 1:
+		return errors.New("delete queue size must be positive")
+	}
+
+	return nil
+}
+
+func validateAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
 
 	return nil
 }
+
+func validateBaseURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("host is missing")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("query and fragment are not allowed")
+	}
+
+	return nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func environ() map[string]string {
+	environment := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			environment[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	return environment
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	return path
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", `
+server_address: ":9000"
+base_url: "http://file.example"
+storage:
+  file_storage_path: "file.db"
+  file_sync_interval: 30s
+limits:
+  rate_limit: 5
+  rate_burst: 10
+log:
+  level: warn
+`)
+	jsonFile := writeFile(t, "config.json", `{
+	"server_address": ":9100",
+	"auth": {"secret_key": "from json"}
+}`)
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want func(t *testing.T, conf Config)
+	}{
+		{
+			name: "defaults #1",
+			want: func(t *testing.T, conf Config) {
+				assert.Equal(t, defaultServerAddress, conf.ServerAddress)
+				assert.Equal(t, defaultBaseURL, conf.BaseURL)
+				assert.Equal(t, defaultFileStoragePath, conf.Storage.FileStoragePath)
+				assert.Equal(t, defaultLogLevel, conf.Log.Level)
+			},
+		},
+		{
+			name: "yaml file over defaults #2",
+			args: []string{"-c", yamlFile},
+			want: func(t *testing.T, conf Config) {
+				assert.Equal(t, ":9000", conf.ServerAddress)
+				assert.Equal(t, "http://file.example", conf.BaseURL)
+				assert.Equal(t, "file.db", conf.Storage.FileStoragePath)
+				assert.Equal(t, 30*time.Second, conf.Storage.FileSyncInterval)
+				assert.Equal(t, 5.0, conf.Limits.RateLimit)
+				assert.Equal(t, "warn", conf.Log.Level)
+				assert.Equal(t, defaultSecretKey, conf.Auth.SecretKey)
+			},
+		},
+		{
+			name: "json file from env #3",
+			env:  map[string]string{"CONFIG": jsonFile},
+			want: func(t *testing.T, conf Config) {
+				assert.Equal(t, ":9100", conf.ServerAddress)
+				assert.Equal(t, "from json", conf.Auth.SecretKey)
+			},
+		},
+		{
+			name: "env over file #4",
+			args: []string{"-config", yamlFile},
+			env: map[string]string{
+				"BASE_URL":     "http://env.example",
+				"DATABASE_DSN": "postgres://localhost/db",
+				"LOG_LEVEL":    "debug",
+			},
+			want: func(t *testing.T, conf Config) {
+				assert.Equal(t, ":9000", conf.ServerAddress)
+				assert.Equal(t, "http://env.example", conf.BaseURL)
+				assert.Equal(t, "postgres://localhost/db", conf.Storage.DatabaseDSN)
+				assert.Equal(t, "debug", conf.Log.Level)
+			},
+		},
+		{
+			name: "flags over env and file #5",
+			args: []string{"-c", yamlFile, "-a", ":9200", "-log-level", "error"},
+			env: map[string]string{
+				"SERVER_ADDRESS": ":9300",
+				"LOG_LEVEL":      "debug",
+			},
+			want: func(t *testing.T, conf Config) {
+				assert.Equal(t, ":9200", conf.ServerAddress)
+				assert.Equal(t, "error", conf.Log.Level)
+				assert.Equal(t, "http://file.example", conf.BaseURL)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := Load(tt.args, tt.env)
+			require.NoError(t, err)
+			tt.want(t, conf)
+		})
+	}
+}
+
+func TestLoad_Validation(t *testing.T) {
+	unknownKey := writeFile(t, "unknown.yaml", "server_adress: \":9000\"\n")
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{name: "unparseable base url #1", env: map[string]string{"BASE_URL": "http://[::1"}},
+		{name: "base url without scheme #2", args: []string{"-b", "localhost:8080"}},
+		{name: "bad listen address #3", args: []string{"-a", "localhost"}},
+		{name: "bad port #4", env: map[string]string{"SERVER_ADDRESS": ":99999"}},
+		{name: "unknown log level #5", args: []string{"-log-level", "verbose"}},
+		{name: "rate limit without burst #6", args: []string{"-rate-limit", "10"}},
+		{name: "https without cert #7", args: []string{"-s"}},
+		{name: "unknown file key #8", args: []string{"-c", unknownKey}},
+		{name: "missing file #9", args: []string{"-c", "/does/not/exist.yaml"}},
+		{name: "malformed env value #10", env: map[string]string{"MAX_BATCH_SIZE": "many"}},
+		{name: "bad redirect address #11", args: []string{"-s", "-tls-cert", "c.pem", "-tls-key", "k.pem", "-tls-redirect-address", "80"}},
+		{name: "negative hsts max age #12", env: map[string]string{"ENABLE_HTTPS": "true", "TLS_CERT_FILE": "c.pem", "TLS_KEY_FILE": "k.pem", "HSTS_MAX_AGE": "-1s"}},
+		{name: "bad grpc address #13", args: []string{"-g", "localhost"}},
+		{name: "bad trusted subnet #14", env: map[string]string{"TRUSTED_SUBNET": "10.0.0.1"}},
+		{name: "zero restore window #15", args: []string{"-restore-window", "0s"}},
+		{name: "unknown default redirect #16", env: map[string]string{"DEFAULT_REDIRECT": "303"}},
+		{name: "bad country header #17", args: []string{"-country-header", "X Country"}},
+		{name: "unknown query passthrough #18", env: map[string]string{"QUERY_PASSTHROUGH": "some"}},
+		{name: "zero webhook attempts #19", args: []string{"-webhook-max-attempts", "0"}},
+		{name: "zero webhook retention #20", args: []string{"-webhook-retention", "0s"}},
+		{name: "negative drain delay #21", env: map[string]string{"DRAIN_DELAY": "-1s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.args, tt.env)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+var reloadableKeys = map[string]bool{
+	"base_url":              true,
+	"trusted_subnet":        true,
+	"default_redirect":      true,
+	"country_header":        true,
+	"query_passthrough":     true,
+	"auth.secret_key":       true,
+	"auth.previous_keys":    true,
+	"admin.token":           true,
+	"limits.max_body_bytes": true,
+	"limits.max_batch_size": true,
+	"limits.rate_limit":     true,
+	"limits.rate_burst":     true,
+	"blocklist.hosts":       true,
+	"log.level":             true,
+}
+
+var secretKeys = map[string]bool{
+	"auth.secret_key":      true,
+	"auth.previous_keys":   true,
+	"admin.token":          true,
+	"storage.database_dsn": true,
+}
+
+type Change struct {
+	Key        string
+	Old        interface{}
+	New        interface{}
+	Reloadable bool
+}
+
+// Diff lists the settings that differ between two configurations, keyed by
+// their config file path. Secret values are masked.
+func Diff(old, new Config) []Change {
+	var changes []Change
+	diffStruct("", reflect.ValueOf(old), reflect.ValueOf(new), &changes)
+
+	return changes
+}
+
+func diffStruct(prefix string, old, new reflect.Value, changes *[]Change) {
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		oldValue, newValue := old.Field(i), new.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			diffStruct(key, oldValue, newValue, changes)
+			continue
+		}
+
+		if reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			continue
+		}
+
+		change := Change{
+			Key:        key,
+			Old:        oldValue.Interface(),
+			New:        newValue.Interface(),
+			Reloadable: reloadableKeys[key],
+		}
+		if secretKeys[key] {
+			change.Old, change.New = "***", "***"
+		}
+
+		*changes = append(*changes, change)
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	base := Config{
+		ServerAddress: ":8080",
+		BaseURL:       "http://localhost:8080",
+		Auth:          AuthConfig{SecretKey: "old key"},
+		Admin:         AdminConfig{Token: "old token"},
+		Limits:        LimitsConfig{MaxBodyBytes: 1 << 20, RateLimit: 10, RateBurst: 20},
+		Blocklist:     Blocklist{Hosts: []string{"evil.ru"}},
+		Storage:       StorageConfig{DatabaseDSN: "postgres://old", FileSyncInterval: time.Minute},
+		Log:           LogConfig{Level: "info"},
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []Change
+	}{
+		{
+			name:   "nothing changed #1",
+			change: func(c *Config) {},
+		},
+		{
+			name:   "reloadable settings #2",
+			change: func(c *Config) { c.BaseURL = "https://short.ru"; c.Limits.RateBurst = 5; c.Log.Level = "debug" },
+			want: []Change{
+				{Key: "base_url", Old: "http://localhost:8080", New: "https://short.ru", Reloadable: true},
+				{Key: "limits.rate_burst", Old: 20, New: 5, Reloadable: true},
+				{Key: "log.level", Old: "info", New: "debug", Reloadable: true},
+			},
+		},
+		{
+			name:   "blocklist slice #3",
+			change: func(c *Config) { c.Blocklist.Hosts = []string{"evil.ru", "bad.ru"} },
+			want:   []Change{{Key: "blocklist.hosts", Old: []string{"evil.ru"}, New: []string{"evil.ru", "bad.ru"}, Reloadable: true}},
+		},
+		{
+			name: "secrets are masked #4",
+			change: func(c *Config) {
+				c.Auth.SecretKey = "new key"
+				c.Auth.PreviousKeys = []string{"old key"}
+				c.Admin.Token = "new token"
+			},
+			want: []Change{
+				{Key: "auth.secret_key", Old: "***", New: "***", Reloadable: true},
+				{Key: "auth.previous_keys", Old: "***", New: "***", Reloadable: true},
+				{Key: "admin.token", Old: "***", New: "***", Reloadable: true},
+			},
+		},
+		{
+			name: "restart required #5",
+			change: func(c *Config) {
+				c.ServerAddress = ":9090"
+				c.Storage.DatabaseDSN = "postgres://new"
+				c.Storage.FileSyncInterval = time.Second
+				c.Webhooks.Timeout = time.Second
+			},
+			want: []Change{
+				{Key: "server_address", Old: ":8080", New: ":9090"},
+				{Key: "storage.database_dsn", Old: "***", New: "***"},
+				{Key: "storage.file_sync_interval", Old: time.Minute, New: time.Second},
+				{Key: "webhooks.timeout", Old: time.Duration(0), New: time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := base
+			changed.Blocklist.Hosts = append([]string(nil), base.Blocklist.Hosts...)
+			tt.change(&changed)
+
+			assert.Equal(t, tt.want, Diff(base, changed))
+		})
+	}
+}
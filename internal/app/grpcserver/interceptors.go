@@ -0,0 +1,98 @@
+package grpcserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/middlewares"
+	"github.com/Fedorova199/red-cat/internal/app/pb"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	userIDKey    = "user_id"
+	signKey      = "sign"
+	requestIDKey = "x-request-id"
+
+	maxRequestIDLength = 128
+)
+
+type userIDContextKey struct{}
+
+func UserID(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDContextKey{}).(string)
+	return userID
+}
+
+// New wires the service into a gRPC server with the auth and logging
+// interceptors. Extra options, such as TLS credentials, are passed through.
+func New(s *Server, auth *middlewares.Auth, log *logger.Logger, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(
+		AuthInterceptor(auth),
+		LoggingInterceptor(log),
+	))
+
+	server := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(server, s)
+
+	return server
+}
+
+// AuthInterceptor reads user_id and sign from the request metadata and checks
+// them with the same HMAC keys as the HTTP cookies. A re-signed or newly
+// issued identity is returned to the client in the response header.
+func AuthInterceptor(auth *middlewares.Auth) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		userID, sign := first(md, userIDKey), first(md, signKey)
+
+		newUserID, newSign := auth.Identify(userID, sign)
+		if newUserID != userID || newSign != sign {
+			if err := grpc.SetHeader(ctx, metadata.Pairs(userIDKey, newUserID, signKey, newSign)); err != nil {
+				return nil, err
+			}
+		}
+
+		return handler(context.WithValue(ctx, userIDContextKey{}, newUserID), req)
+	}
+}
+
+func LoggingInterceptor(l *logger.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		requestID := first(md, requestIDKey)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.New().String()
+		}
+		grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, requestID))
+
+		log := l.With("request_id", requestID)
+		ctx = logger.WithRequestID(ctx, requestID)
+		ctx = logger.WithContext(ctx, log)
+
+		resp, err := handler(ctx, req)
+
+		log.Info("rpc",
+			"method", info.FullMethod,
+			"code", status.Code(err).String(),
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"user_id", UserID(ctx),
+		)
+
+		return resp, err
+	}
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
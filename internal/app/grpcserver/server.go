@@ -0,0 +1,300 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/Fedorova199/red-cat/internal/app/handlers"
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/pb"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/app/webhooks"
+	"github.com/Fedorova199/red-cat/internal/app/workers"
+	"github.com/Fedorova199/red-cat/internal/interfaces"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the gRPC API on top of the same storage and settings
+// as the HTTP handlers.
+type Server struct {
+	pb.UnimplementedShortenerServer
+
+	Storage  interfaces.Storage
+	Deleter  *workers.Deleter
+	Events   *webhooks.Notifier
+	settings func() handlers.Settings
+}
+
+func NewServer(storage interfaces.Storage, settings func() handlers.Settings) *Server {
+	return &Server{
+		Storage:  storage,
+		settings: settings,
+	}
+}
+
+func (s *Server) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	settings := s.settings()
+	if settings.Blocked(req.Url) {
+		return nil, status.Error(codes.InvalidArgument, handlers.ErrBlockedHost.Error())
+	}
+	if req.Redirect != "" && !storage.ValidRedirect(req.Redirect) {
+		return nil, status.Error(codes.InvalidArgument, handlers.ErrUnknownRedirect.Error())
+	}
+	passwordHash, err := handlers.HashPassword(req.Password)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.MaxClicks < 0 {
+		return nil, status.Error(codes.InvalidArgument, handlers.ErrMaxClicks.Error())
+	}
+	rules, err := settings.CheckRules(pbRules(req.Rules))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	variants, err := settings.CheckVariants(pbVariants(req.Variants))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	query, err := settings.CheckQuery(pbQuery(req.Query))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	id, err := s.Storage.Set(ctx, storage.CreateURL{
+		User:         UserID(ctx),
+		URL:          req.Url,
+		Redirect:     req.Redirect,
+		PasswordHash: passwordHash,
+		MaxClicks:    int(req.MaxClicks),
+		Rules:        rules,
+		Variants:     variants,
+		Query:        query,
+	})
+	if err != nil {
+		var pge *pgconn.PgError
+		if errors.As(err, &pge) && pge.Code == pgerrcode.UniqueViolation {
+			createURL, err := s.Storage.GetOriginURL(ctx, req.Url)
+			if err != nil {
+				return nil, internalError(ctx, err)
+			}
+
+			return &pb.ShortenResponse{Result: settings.ShortURL(createURL.ID), Existed: true}, nil
+		}
+
+		return nil, internalError(ctx, err)
+	}
+
+	return &pb.ShortenResponse{Result: settings.ShortURL(id)}, nil
+}
+
+func (s *Server) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	settings := s.settings()
+	if settings.MaxBatchSize > 0 && len(req.Items) > settings.MaxBatchSize {
+		return nil, status.Errorf(codes.ResourceExhausted, "batch is limited to %d urls", settings.MaxBatchSize)
+	}
+
+	userID := UserID(ctx)
+	shortBatch := make([]storage.ShortenBatch, 0, len(req.Items))
+	for _, item := range req.Items {
+		if settings.Blocked(item.OriginalUrl) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s: %s", handlers.ErrBlockedHost, item.CorrelationId)
+		}
+		if item.Redirect != "" && !storage.ValidRedirect(item.Redirect) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s: %s", handlers.ErrUnknownRedirect, item.CorrelationId)
+		}
+		passwordHash, err := handlers.HashPassword(item.Password)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s: %s", err, item.CorrelationId)
+		}
+		if item.MaxClicks < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "%s: %s", handlers.ErrMaxClicks, item.CorrelationId)
+		}
+		rules, err := settings.CheckRules(pbRules(item.Rules))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s: %s", err, item.CorrelationId)
+		}
+		variants, err := settings.CheckVariants(pbVariants(item.Variants))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s: %s", err, item.CorrelationId)
+		}
+		query, err := settings.CheckQuery(pbQuery(item.Query))
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s: %s", err, item.CorrelationId)
+		}
+
+		shortBatch = append(shortBatch, storage.ShortenBatch{
+			User:          userID,
+			URL:           item.OriginalUrl,
+			CorrelationID: item.CorrelationId,
+			Redirect:      item.Redirect,
+			PasswordHash:  passwordHash,
+			MaxClicks:     int(item.MaxClicks),
+			Rules:         rules,
+			Variants:      variants,
+			Query:         query,
+		})
+	}
+
+	shortBatch, err := s.Storage.PutBatch(ctx, shortBatch)
+	if err != nil {
+		return nil, internalError(ctx, err)
+	}
+
+	resp := &pb.ShortenBatchResponse{Items: make([]*pb.BatchResult, 0, len(shortBatch))}
+	for _, item := range shortBatch {
+		resp.Items = append(resp.Items, &pb.BatchResult{
+			CorrelationId: item.CorrelationID,
+			ShortUrl:      settings.ShortURL(item.ID),
+		})
+	}
+
+	return resp, nil
+}
+
+func pbRules(rules []*pb.Rule) storage.Rules {
+	converted := make(storage.Rules, 0, len(rules))
+	for _, rule := range rules {
+		converted = append(converted, storage.Rule{
+			Platform: rule.Platform,
+			Language: rule.Language,
+			Country:  rule.Country,
+			URL:      rule.Url,
+		})
+	}
+
+	return converted
+}
+
+func pbVariants(variants []*pb.Variant) storage.Variants {
+	converted := make(storage.Variants, 0, len(variants))
+	for _, variant := range variants {
+		converted = append(converted, storage.Variant{
+			Name:   variant.Name,
+			URL:    variant.Url,
+			Weight: int(variant.Weight),
+		})
+	}
+
+	return converted
+}
+
+func pbQuery(query *pb.QueryOptions) *storage.QueryOptions {
+	if query == nil {
+		return nil
+	}
+
+	return &storage.QueryOptions{
+		Passthrough: query.Passthrough,
+		Template:    query.Template,
+		Fixed:       query.Fixed,
+	}
+}
+
+func (s *Server) Resolve(ctx context.Context, req *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	id, err := strconv.Atoi(req.Id)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	origin, err := s.Storage.Get(ctx, id)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	if origin.Expired() {
+		return nil, status.Error(codes.NotFound, storage.ErrExpired.Error())
+	}
+	if s.settings().Blocked(origin.URL) {
+		return nil, status.Error(codes.PermissionDenied, handlers.ErrBlockedHost.Error())
+	}
+	// Resolve has no password and no attempt limit, so protected links
+	// are only opened over HTTP.
+	if origin.PasswordHash != "" {
+		return nil, status.Error(codes.PermissionDenied, handlers.ErrPasswordNeeded.Error())
+	}
+	// Resolving a limited link spends one of its clicks, as a redirect would.
+	if origin.MaxClicks > 0 {
+		clicks, err := s.Storage.AddClick(ctx, origin.ID, "")
+		if errors.Is(err, storage.ErrExhausted) || errors.Is(err, storage.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		} else if err != nil {
+			return nil, internalError(ctx, err)
+		}
+		s.Events.Clicked(ctx, origin, clicks)
+	}
+
+	return &pb.ResolveResponse{OriginalUrl: origin.URL}, nil
+}
+
+func (s *Server) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	// Like /api/user/urls, a user without links gets an empty list.
+	createdURLs, err := s.Storage.ListUserURLs(ctx, storage.ListQuery{User: UserID(ctx), Status: storage.StatusActive})
+	if err != nil {
+		return nil, internalError(ctx, err)
+	}
+
+	settings := s.settings()
+	resp := &pb.ListUserURLsResponse{Urls: make([]*pb.UserURL, 0, len(createdURLs))}
+	for _, createdURL := range createdURLs {
+		resp.Urls = append(resp.Urls, &pb.UserURL{
+			ShortUrl:    settings.ShortURL(createdURL.ID),
+			OriginalUrl: createdURL.URL,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *Server) DeleteURLs(ctx context.Context, req *pb.DeleteURLsRequest) (*pb.DeleteURLsResponse, error) {
+	userID := UserID(ctx)
+
+	ids := make([]int, 0, len(req.Ids))
+	for _, rawID := range req.Ids {
+		id, err := strconv.Atoi(rawID)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		record, err := s.Storage.Get(ctx, id)
+		if err != nil || record.User != userID {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	task := workers.DeleteTask{
+		User:      userID,
+		IDs:       ids,
+		RequestID: logger.RequestID(ctx),
+	}
+
+	var err error
+	if s.Deleter == nil {
+		err = s.Storage.DeleteURLs(ctx, task.IDs)
+	} else {
+		err = s.Deleter.Push(task)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &pb.DeleteURLsResponse{}, nil
+}
+
+func (s *Server) Ping(ctx context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := s.Storage.Ping(ctx); err != nil {
+		return nil, internalError(ctx, err)
+	}
+
+	return &pb.PingResponse{}, nil
+}
+
+func internalError(ctx context.Context, err error) error {
+	logger.FromContext(ctx).Error("rpc failed", "error", err)
+
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,132 @@
+package grpcserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Fedorova199/red-cat/internal/app/handlers"
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/middlewares"
+	"github.com/Fedorova199/red-cat/internal/app/pb"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T, settings handlers.Settings) pb.ShortenerClient {
+	log, err := logger.New(io.Discard, logger.DebugLevel, logger.FormatJSON)
+	require.NoError(t, err)
+
+	models := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+	service := NewServer(models, func() handlers.Settings { return settings })
+	server := New(service, middlewares.NewAuth([]byte("secret")), log)
+
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewShortenerClient(conn)
+}
+
+func TestServer_UserFlow(t *testing.T) {
+	client := newTestClient(t, handlers.Settings{BaseURL: "http://localhost:8080", BlockedHosts: []string{"evil.com"}})
+	ctx := context.Background()
+
+	var header metadata.MD
+	shortened, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/1", shortened.Result)
+	require.Len(t, header.Get(userIDKey), 1)
+	require.Len(t, header.Get(signKey), 1)
+	assert.NotEmpty(t, header.Get(requestIDKey))
+
+	userCtx := metadata.AppendToOutgoingContext(ctx, userIDKey, header.Get(userIDKey)[0], signKey, header.Get(signKey)[0])
+
+	batch, err := client.ShortenBatch(userCtx, &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "a", OriginalUrl: "https://example.org"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, batch.Items, 1)
+	assert.Equal(t, "a", batch.Items[0].CorrelationId)
+	assert.Equal(t, "http://localhost:8080/2", batch.Items[0].ShortUrl)
+
+	resolved, err := client.Resolve(ctx, &pb.ResolveRequest{Id: "1"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", resolved.OriginalUrl)
+
+	list, err := client.ListUserURLs(userCtx, &pb.ListUserURLsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.Urls, 2)
+
+	// a forged signature gets a fresh identity instead of the claimed one
+	var forgedHeader metadata.MD
+	forged := metadata.AppendToOutgoingContext(ctx, userIDKey, header.Get(userIDKey)[0], signKey, "00")
+	client.ListUserURLs(forged, &pb.ListUserURLsRequest{}, grpc.Header(&forgedHeader))
+	require.Len(t, forgedHeader.Get(userIDKey), 1)
+	assert.NotEqual(t, header.Get(userIDKey)[0], forgedHeader.Get(userIDKey)[0])
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://api.evil.com/x"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.org", Redirect: "303"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.org", Rules: []*pb.Rule{{Platform: "symbian", Url: "https://example.org/s"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{Id: "abc"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	protected, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.net", Password: "secret"})
+	require.NoError(t, err)
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{Id: strings.TrimPrefix(protected.Result, "http://localhost:8080/")})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	once, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.net/once", MaxClicks: 1})
+	require.NoError(t, err)
+	onceID := strings.TrimPrefix(once.Result, "http://localhost:8080/")
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{Id: onceID})
+	require.NoError(t, err)
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{Id: onceID})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.Ping(ctx, &pb.PingRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestServer_ShortenBatchLimit(t *testing.T) {
+	client := newTestClient(t, handlers.Settings{BaseURL: "http://localhost:8080", MaxBatchSize: 1})
+
+	_, err := client.ShortenBatch(context.Background(), &pb.ShortenBatchRequest{Items: []*pb.BatchItem{
+		{CorrelationId: "a", OriginalUrl: "https://a.example"},
+		{CorrelationId: "b", OriginalUrl: "https://b.example"},
+	}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestServer_ListUserURLs_Empty(t *testing.T) {
+	client := newTestClient(t, handlers.Settings{BaseURL: "http://localhost:8080"})
+
+	var header metadata.MD
+	list, err := client.ListUserURLs(context.Background(), &pb.ListUserURLsRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Empty(t, list.Urls)
+	assert.Len(t, header.Get(userIDKey), 1, "fresh client got no identity")
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	AuditLookup      = "lookup"
+	AuditListUser    = "list_user"
+	AuditForceDelete = "force_delete"
+	AuditRestore     = "restore"
+)
+
+// adminOnly lets a request through when it carries the configured admin
+// token as a bearer token. The admin API is closed while no token is set.
+func (h *Handler) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := h.Settings().AdminToken
+		if token == "" {
+			http.Error(w, "admin API is disabled", http.StatusForbidden)
+			return
+		}
+
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func (h *Handler) AdminGetURLHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if !h.audit(w, r, AuditLookup, strconv.Itoa(id)) {
+		return
+	}
+
+	createURL, err := h.Storage.Lookup(r.Context(), id)
+	if err != nil {
+		lookupError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, h.urlInfo(createURL))
+}
+
+func (h *Handler) AdminFindURLHandler(w http.ResponseWriter, r *http.Request) {
+	originURL := r.URL.Query().Get("origin_url")
+	if originURL == "" {
+		http.Error(w, "origin_url is required", http.StatusBadRequest)
+		return
+	}
+
+	if !h.audit(w, r, AuditLookup, originURL) {
+		return
+	}
+
+	createURL, err := h.Storage.LookupOriginURL(r.Context(), originURL)
+	if err != nil {
+		lookupError(w, r, err)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, h.urlInfo(createURL))
+}
+
+func (h *Handler) AdminUserURLsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	if !h.audit(w, r, AuditListUser, userID) {
+		return
+	}
+
+	createdURLs, err := h.Storage.LookupUser(r.Context(), userID)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]storage.URLInfo, 0, len(createdURLs))
+	for _, createURL := range createdURLs {
+		infos = append(infos, h.urlInfo(createURL))
+	}
+
+	writeJSON(w, r, http.StatusOK, infos)
+}
+
+func (h *Handler) AdminDeleteURLHandler(w http.ResponseWriter, r *http.Request) {
+	h.adminSetDeleted(w, r, AuditForceDelete, h.Storage.DeleteURLs)
+}
+
+func (h *Handler) AdminRestoreURLHandler(w http.ResponseWriter, r *http.Request) {
+	h.adminSetDeleted(w, r, AuditRestore, h.Storage.RestoreURLs)
+}
+
+func (h *Handler) adminSetDeleted(w http.ResponseWriter, r *http.Request, action string, apply func(ctx context.Context, ids []int) error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.Storage.Lookup(r.Context(), id); err != nil {
+		lookupError(w, r, err)
+		return
+	}
+
+	if !h.audit(w, r, action, strconv.Itoa(id)) {
+		return
+	}
+
+	if err := apply(r.Context(), []int{id}); err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// audit records the action before it runs, so a failing audit write blocks
+// the action instead of leaving it untracked.
+func (h *Handler) audit(w http.ResponseWriter, r *http.Request, action, target string) bool {
+	err := h.Storage.AddAudit(r.Context(), storage.AuditRecord{
+		CreatedAt:  time.Now().UTC(),
+		Action:     action,
+		Target:     target,
+		RemoteAddr: r.RemoteAddr,
+		RequestID:  logger.RequestID(r.Context()),
+	})
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+func (h *Handler) urlInfo(createURL storage.CreateURL) storage.URLInfo {
+	return storage.URLInfo{
+		ID:          createURL.ID,
+		ShortURL:    h.shortURL(createURL.ID),
+		OriginalURL: createURL.URL,
+		UserID:      createURL.User,
+		Deleted:     createURL.Deleted,
+		DeletedAt:   createURL.DeletedAt,
+		Redirect:    createURL.Redirect,
+		Protected:   createURL.PasswordHash != "",
+		Clicks:      createURL.Clicks,
+		MaxClicks:   createURL.MaxClicks,
+		ExpiresAt:   createURL.ExpiresAt,
+		Rules:       createURL.Rules,
+		Variants:    createURL.VariantStats(),
+		Query:       createURL.Query,
+	}
+}
+
+func lookupError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	httpError(w, r, err, http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, v interface{}) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(res)
+}
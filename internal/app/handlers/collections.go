@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxCollections         = 50
+	maxCollectionLinks     = 100
+	maxCollectionTitle     = 200
+	maxCollectionDesc      = 1000
+	maxCollectionLinkTitle = 200
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var (
+	ErrCollectionSlug       = errors.New("slug must be 1 to 63 lowercase letters, digits or dashes and not start with a dash")
+	ErrCollectionTitle      = fmt.Errorf("title must be 1 to %d characters", maxCollectionTitle)
+	ErrCollectionDesc       = fmt.Errorf("description must be at most %d characters", maxCollectionDesc)
+	ErrCollectionLinks      = fmt.Errorf("a collection can have at most %d links", maxCollectionLinks)
+	ErrCollectionLinkTitle  = fmt.Errorf("link titles must be at most %d characters", maxCollectionLinkTitle)
+	ErrDuplicateLink        = errors.New("a link can only be in a collection once")
+	ErrTooManyCollections   = fmt.Errorf("a user can have at most %d collections", maxCollections)
+	ErrCollectionNotFound   = errors.New("collection not found")
+	ErrCollectionLinkAccess = errors.New("collections can only hold the caller's own links")
+)
+
+var collectionTemplate = template.Must(template.New("collection").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Description}}<p>{{.Description}}</p>
+{{end}}<ul>
+{{range .Links}}<li><a href="{{.URL}}" rel="noopener noreferrer">{{.Title}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+func (s Settings) CollectionURL(slug string) string {
+	return s.BaseURL + "/c/" + slug
+}
+
+// applyCollection copies the fields present in the request onto the
+// collection and validates the result. Slugs are lowercased and link
+// titles trimmed.
+func applyCollection(collection *storage.Collection, request storage.CollectionRequest) error {
+	if request.Slug != nil {
+		collection.Slug = strings.ToLower(strings.TrimSpace(*request.Slug))
+	}
+	if request.Title != nil {
+		collection.Title = strings.TrimSpace(*request.Title)
+	}
+	if request.Description != nil {
+		collection.Description = strings.TrimSpace(*request.Description)
+	}
+	if request.Links != nil {
+		collection.Links = make([]storage.CollectionItem, 0, len(*request.Links))
+		for _, link := range *request.Links {
+			link.Title = strings.TrimSpace(link.Title)
+			collection.Links = append(collection.Links, link)
+		}
+	}
+
+	if !slugPattern.MatchString(collection.Slug) {
+		return ErrCollectionSlug
+	}
+	if n := utf8.RuneCountInString(collection.Title); n == 0 || n > maxCollectionTitle {
+		return ErrCollectionTitle
+	}
+	if utf8.RuneCountInString(collection.Description) > maxCollectionDesc {
+		return ErrCollectionDesc
+	}
+	if len(collection.Links) > maxCollectionLinks {
+		return ErrCollectionLinks
+	}
+
+	seen := make(map[int]bool, len(collection.Links))
+	for _, link := range collection.Links {
+		if seen[link.ID] {
+			return fmt.Errorf("%w: %d", ErrDuplicateLink, link.ID)
+		}
+		seen[link.ID] = true
+
+		if utf8.RuneCountInString(link.Title) > maxCollectionLinkTitle {
+			return ErrCollectionLinkTitle
+		}
+	}
+
+	return nil
+}
+
+// checkCollectionLinks makes sure every link exists and belongs to the
+// user. Deleted links can still be added; they stay off the page until
+// restored.
+func (h *Handler) checkCollectionLinks(ctx context.Context, userID string, links []storage.CollectionItem) error {
+	for _, link := range links {
+		createURL, err := h.Storage.Lookup(ctx, link.ID)
+		if err != nil || createURL.User != userID {
+			return fmt.Errorf("%w: %d", ErrCollectionLinkAccess, link.ID)
+		}
+	}
+
+	return nil
+}
+
+func (h *Handler) collectionInfo(ctx context.Context, collection storage.Collection) storage.CollectionInfo {
+	settings := h.Settings()
+	info := storage.CollectionInfo{
+		ID:          collection.ID,
+		Slug:        collection.Slug,
+		PageURL:     settings.CollectionURL(collection.Slug),
+		Title:       collection.Title,
+		Description: collection.Description,
+		Clicks:      collection.Clicks,
+		Links:       make([]storage.CollectionLink, 0, len(collection.Links)),
+		CreatedAt:   collection.CreatedAt,
+		UpdatedAt:   collection.UpdatedAt,
+	}
+
+	for _, link := range collection.Links {
+		createURL, err := h.Storage.Lookup(ctx, link.ID)
+		if err != nil {
+			// Purged since it was added.
+			continue
+		}
+
+		info.Links = append(info.Links, storage.CollectionLink{
+			ID:          link.ID,
+			Title:       link.Title,
+			ShortURL:    settings.ShortURL(link.ID),
+			OriginalURL: createURL.URL,
+			Status:      createURL.Status(),
+			Clicks:      collection.LinkClicks[strconv.Itoa(link.ID)],
+		})
+	}
+
+	return info
+}
+
+// ownCollection loads the collection named by the id parameter. Another
+// user's collection is reported as missing.
+func (h *Handler) ownCollection(r *http.Request) (storage.Collection, int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return storage.Collection{}, http.StatusBadRequest, err
+	}
+
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		return storage.Collection{}, http.StatusInternalServerError, err
+	}
+
+	collection, err := h.Storage.LookupCollection(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && collection.User != idCookie.Value) {
+		return storage.Collection{}, http.StatusNotFound, ErrCollectionNotFound
+	}
+	if err != nil {
+		return storage.Collection{}, http.StatusInternalServerError, err
+	}
+
+	return collection, http.StatusOK, nil
+}
+
+func readCollectionRequest(r *http.Request) (storage.CollectionRequest, int, error) {
+	var request storage.CollectionRequest
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return request, http.StatusInternalServerError, err
+	}
+	if err := json.Unmarshal(b, &request); err != nil {
+		return request, http.StatusBadRequest, err
+	}
+
+	return request, http.StatusOK, nil
+}
+
+// CreateCollectionHandler groups some of the caller's links into a page
+// served at /c/{slug}. Links are shown in the order given.
+func (h *Handler) CreateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	request, code, err := readCollectionRequest(r)
+	if err != nil {
+		httpError(w, r, err, code)
+		return
+	}
+
+	collection := storage.Collection{User: idCookie.Value, Links: []storage.CollectionItem{}}
+	if err := applyCollection(&collection, request); err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if err := h.checkCollectionLinks(r.Context(), idCookie.Value, collection.Links); err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	existing, err := h.Storage.UserCollections(r.Context(), idCookie.Value)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if len(existing) >= maxCollections {
+		httpError(w, r, ErrTooManyCollections, http.StatusConflict)
+		return
+	}
+
+	id, err := h.Storage.AddCollection(r.Context(), collection)
+	if errors.Is(err, storage.ErrDuplicateSlug) {
+		httpError(w, r, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if collection, err = h.Storage.LookupCollection(r.Context(), id); err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, http.StatusCreated, h.collectionInfo(r.Context(), collection))
+}
+
+func (h *Handler) GetCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	collections, err := h.Storage.UserCollections(r.Context(), idCookie.Value)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]storage.CollectionInfo, 0, len(collections))
+	for _, collection := range collections {
+		infos = append(infos, h.collectionInfo(r.Context(), collection))
+	}
+
+	writeJSON(w, r, http.StatusOK, infos)
+}
+
+// GetCollectionHandler shows one collection with its links and the clicks
+// each of them got from the collection page.
+func (h *Handler) GetCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, code, err := h.ownCollection(r)
+	if err != nil {
+		httpError(w, r, err, code)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, h.collectionInfo(r.Context(), collection))
+}
+
+// PatchCollectionHandler changes the fields present in the body. A links
+// list replaces the links and their order; click counts are kept.
+func (h *Handler) PatchCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection, code, err := h.ownCollection(r)
+	if err != nil {
+		httpError(w, r, err, code)
+		return
+	}
+
+	request, code, err := readCollectionRequest(r)
+	if err != nil {
+		httpError(w, r, err, code)
+		return
+	}
+	if request.Slug == nil && request.Title == nil && request.Description == nil && request.Links == nil {
+		httpError(w, r, ErrEmptyUpdate, http.StatusBadRequest)
+		return
+	}
+
+	if err := applyCollection(&collection, request); err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if request.Links != nil {
+		if err := h.checkCollectionLinks(r.Context(), collection.User, collection.Links); err != nil {
+			httpError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.Storage.UpdateCollection(r.Context(), collection)
+	if errors.Is(err, storage.ErrDuplicateSlug) {
+		httpError(w, r, err, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, ErrCollectionNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if collection, err = h.Storage.LookupCollection(r.Context(), collection.ID); err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, h.collectionInfo(r.Context(), collection))
+}
+
+// DeleteCollectionHandler removes the collection page. The links stay.
+func (h *Handler) DeleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = h.Storage.DeleteCollection(r.Context(), idCookie.Value, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, ErrCollectionNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// collectionLabel is the text a link is shown with when the owner gave it
+// no title: the destination host, or the short link itself for password
+// protected links so the destination is not given away.
+func collectionLabel(link storage.CollectionItem, createURL storage.CreateURL, shortURL string) string {
+	if link.Title != "" {
+		return link.Title
+	}
+	if createURL.PasswordHash == "" {
+		if u, err := url.Parse(createURL.URL); err == nil && u.Host != "" {
+			return u.Hostname()
+		}
+	}
+
+	return shortURL
+}
+
+// CollectionPageHandler is the public collection page, as HTML or, for
+// clients that accept JSON, as a list. Only links that can be followed
+// right now are shown.
+func (h *Handler) CollectionPageHandler(w http.ResponseWriter, r *http.Request) {
+	collection, err := h.Storage.GetCollection(r.Context(), strings.ToLower(chi.URLParam(r, "slug")))
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, ErrCollectionNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	settings := h.Settings()
+	page := storage.CollectionPage{
+		Title:       collection.Title,
+		Description: collection.Description,
+		Links:       make([]storage.PageLink, 0, len(collection.Links)),
+	}
+	pageURL := settings.CollectionURL(collection.Slug)
+	for _, link := range collection.Links {
+		createURL, err := h.Storage.Lookup(r.Context(), link.ID)
+		if err != nil || createURL.Status() != storage.StatusActive || settings.Blocked(createURL.URL) {
+			continue
+		}
+
+		shortURL := settings.ShortURL(link.ID)
+		page.Links = append(page.Links, storage.PageLink{
+			Title:    collectionLabel(link, createURL, shortURL),
+			URL:      pageURL + "/" + strconv.Itoa(link.ID),
+			ShortURL: shortURL,
+		})
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, r, http.StatusOK, page)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	collectionTemplate.Execute(w, page)
+}
+
+// CollectionClickHandler counts a link followed from a collection page and
+// sends the visitor on to the short link, which counts the visit as usual.
+func (h *Handler) CollectionClickHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	collection, err := h.Storage.GetCollection(r.Context(), strings.ToLower(chi.URLParam(r, "slug")))
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, ErrCollectionNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	found := false
+	for _, link := range collection.Links {
+		found = found || link.ID == id
+	}
+	if !found {
+		httpError(w, r, storage.ErrNotFound, http.StatusNotFound)
+		return
+	}
+
+	if err := h.Storage.AddCollectionClick(r.Context(), collection.ID, id); err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	http.Redirect(w, r, h.shortURL(id), http.StatusFound)
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	ErrNotOwner    = errors.New("link belongs to another user")
+	ErrEmptyURL    = errors.New("original_url must not be empty")
+	ErrPastExpiry  = errors.New("expires_at must be in the future")
+	ErrEmptyUpdate = errors.New("nothing to update")
+)
+
+// PatchURLHandler changes the destination, redirect kind, expiry, routing
+// rules, split variants or query options of one of the caller's links.
+func (h *Handler) PatchURLHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	var patch storage.URLPatch
+	if err := json.Unmarshal(b, &patch); err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if patch.OriginalURL == nil && patch.Redirect == nil && patch.ExpiresAt == nil && patch.Rules == nil && patch.Variants == nil && patch.Weights == nil && patch.Query == nil {
+		httpError(w, r, ErrEmptyUpdate, http.StatusBadRequest)
+		return
+	}
+
+	createURL, code, err := h.ownLink(r)
+	if err != nil {
+		httpError(w, r, err, code)
+		return
+	}
+	if createURL.Deleted {
+		httpError(w, r, storage.ErrDeleted, http.StatusGone)
+		return
+	}
+
+	if patch.OriginalURL != nil {
+		if *patch.OriginalURL == "" {
+			httpError(w, r, ErrEmptyURL, http.StatusBadRequest)
+			return
+		}
+		if h.blocked(*patch.OriginalURL) {
+			httpError(w, r, ErrBlockedHost, http.StatusBadRequest)
+			return
+		}
+		createURL.URL = *patch.OriginalURL
+	}
+
+	if patch.Redirect != nil {
+		if createURL.Redirect, err = redirectKind(*patch.Redirect); err != nil {
+			httpError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if patch.ExpiresAt != nil {
+		var expiresAt *time.Time
+		if err := json.Unmarshal(patch.ExpiresAt, &expiresAt); err != nil {
+			httpError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if expiresAt != nil && !expiresAt.After(time.Now()) {
+			httpError(w, r, ErrPastExpiry, http.StatusBadRequest)
+			return
+		}
+		createURL.ExpiresAt = expiresAt
+	}
+
+	if patch.Rules != nil {
+		if createURL.Rules, err = h.Settings().CheckRules(*patch.Rules); err != nil {
+			httpError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if patch.Variants != nil {
+		if createURL.Variants, err = h.Settings().CheckVariants(*patch.Variants); err != nil {
+			httpError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if patch.Weights != nil {
+		if createURL.Variants, err = reweight(createURL.Variants, patch.Weights); err != nil {
+			httpError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if patch.Query != nil {
+		if createURL.Query, err = h.Settings().CheckQuery(patch.Query); err != nil {
+			httpError(w, r, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.Storage.UpdateURL(r.Context(), createURL)
+	if errors.Is(err, storage.ErrDuplicateURL) {
+		existing, err := h.Storage.LookupOriginURL(r.Context(), createURL.URL)
+		if err != nil {
+			httpError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, r, http.StatusConflict, storage.Response{Result: h.shortURL(existing.ID)})
+		return
+	}
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, h.userURL(createURL))
+}
+
+// URLHistoryHandler lists the destinations one of the caller's links had
+// before, oldest first.
+func (h *Handler) URLHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	createURL, code, err := h.ownLink(r)
+	if err != nil {
+		httpError(w, r, err, code)
+		return
+	}
+
+	versions, err := h.Storage.URLHistory(r.Context(), createURL.ID)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, versions)
+}
+
+// ownLink loads the link named by the id parameter if it belongs to the
+// caller. Deleted links are returned too.
+func (h *Handler) ownLink(r *http.Request) (storage.CreateURL, int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return storage.CreateURL{}, http.StatusBadRequest, err
+	}
+
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		return storage.CreateURL{}, http.StatusInternalServerError, err
+	}
+
+	createURL, err := h.Storage.Lookup(r.Context(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return storage.CreateURL{}, http.StatusNotFound, err
+	}
+	if err != nil {
+		return storage.CreateURL{}, http.StatusInternalServerError, err
+	}
+	if createURL.User != idCookie.Value {
+		return storage.CreateURL{}, http.StatusForbidden, ErrNotOwner
+	}
+
+	return createURL, http.StatusOK, nil
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -8,8 +9,13 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+	"time"
 
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/metrics"
+	"github.com/Fedorova199/red-cat/internal/app/middlewares"
 	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/app/workers"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
@@ -18,20 +24,48 @@ import (
 func (h *Handler) PostHandler(w http.ResponseWriter, r *http.Request) {
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
 	idCookie, err := r.Cookie("user_id")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
 	url := string(b)
+	if h.blocked(url) {
+		http.Error(w, ErrBlockedHost.Error(), http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := redirectKind(r.URL.Query().Get("redirect"))
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := HashPassword(r.Header.Get(passwordHeader))
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	maxClicks := 0
+	if raw := r.URL.Query().Get("max_clicks"); raw != "" {
+		if maxClicks, err = strconv.Atoi(raw); err != nil || maxClicks < 0 {
+			httpError(w, r, ErrMaxClicks, http.StatusBadRequest)
+			return
+		}
+	}
+
 	id, err := h.Storage.Set(r.Context(), storage.CreateURL{
-		User: idCookie.Value,
-		URL:  url,
+		User:         idCookie.Value,
+		URL:          url,
+		Redirect:     redirect,
+		PasswordHash: passwordHash,
+		MaxClicks:    maxClicks,
 	})
 
 	if err != nil {
@@ -39,22 +73,22 @@ func (h *Handler) PostHandler(w http.ResponseWriter, r *http.Request) {
 		if errors.As(err, &pge) && pge.Code == pgerrcode.UniqueViolation {
 			createURL, err := h.Storage.GetOriginURL(r.Context(), url)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				httpError(w, r, err, http.StatusInternalServerError)
 				return
 			}
 
-			resultURL := h.BaseURL + "/" + fmt.Sprintf("%d", createURL.ID)
+			resultURL := h.shortURL(createURL.ID)
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 			w.WriteHeader(http.StatusConflict)
 			w.Write([]byte(resultURL))
 			return
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	resultURL := h.BaseURL + "/" + fmt.Sprintf("%d", id)
+	resultURL := h.shortURL(id)
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusCreated)
@@ -62,52 +96,158 @@ func (h *Handler) PostHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetHandler(w http.ResponseWriter, r *http.Request) {
-	rawID := chi.URLParam(r, "id")
-	id, err := strconv.Atoi(rawID)
-
+	origin, code, err := h.link(r.Context(), chi.URLParam(r, "id"))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		switch code {
+		case http.StatusGone:
+			h.Redirects.Inc(metrics.RedirectGone)
+		case http.StatusForbidden:
+			h.Redirects.Inc(metrics.RedirectBlocked)
+		default:
+			h.Redirects.Inc(metrics.RedirectMiss)
+		}
+		httpError(w, r, err, code)
 		return
 	}
 
-	origin, err := h.Storage.Get(r.Context(), id)
+	if !h.unlocked(w, r, origin) {
+		return
+	}
+
+	// Rules come first; visitors no rule picked out are split over the
+	// variants, if the link has any.
+	target, routed := h.route(w, r, origin)
+	variant := ""
+	if !routed && len(origin.Variants) > 0 {
+		if chosen, ok := h.pickVariant(w, r, origin); ok {
+			target.URL, variant = chosen.URL, chosen.Name
+		}
+	}
+
+	clicks, err := h.Storage.AddClick(r.Context(), origin.ID, variant)
 	if err != nil {
-		if errors.Is(storage.ErrDeleted, err) {
-			http.Error(w, err.Error(), http.StatusGone)
+		switch {
+		case errors.Is(err, storage.ErrExhausted):
+			h.Redirects.Inc(metrics.RedirectGone)
+			httpError(w, r, err, http.StatusGone)
+			return
+		case errors.Is(err, storage.ErrNotFound):
+			// Deleted or purged since it was looked up.
+			h.Redirects.Inc(metrics.RedirectMiss)
+			httpError(w, r, err, http.StatusNotFound)
+			return
+		case origin.MaxClicks > 0:
+			// A limited link must not redirect without being counted.
+			h.Redirects.Inc(metrics.RedirectMiss)
+			httpError(w, r, err, http.StatusInternalServerError)
 			return
 		}
+		logger.FromContext(r.Context()).Error("count click", "id", origin.ID, "error", err)
+	}
+	h.Events.Clicked(r.Context(), origin, clicks)
+
+	h.Redirects.Inc(metrics.RedirectHit)
+	target.URL = h.withQuery(r, origin, target.URL, variant)
+	h.redirect(w, r, target)
+}
+
+// link loads the link behind a short id. When it cannot be followed the
+// returned code is the status to answer with.
+func (h *Handler) link(ctx context.Context, rawID string) (storage.CreateURL, int, error) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		return storage.CreateURL{}, http.StatusInternalServerError, err
 	}
 
+	origin, err := h.Storage.Get(ctx, id)
+	if errors.Is(err, storage.ErrDeleted) {
+		return storage.CreateURL{}, http.StatusGone, err
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
+		return storage.CreateURL{}, http.StatusNotFound, err
 	}
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	http.Redirect(w, r, origin.URL, http.StatusTemporaryRedirect)
+
+	if origin.Expired() {
+		return storage.CreateURL{}, http.StatusGone, storage.ErrExpired
+	}
+	if origin.Exhausted() {
+		return storage.CreateURL{}, http.StatusGone, storage.ErrExhausted
+	}
+	if h.blocked(origin.URL) {
+		return storage.CreateURL{}, http.StatusForbidden, ErrBlockedHost
+	}
+
+	return origin, http.StatusOK, nil
 }
 
 func (h *Handler) JSONHandler(w http.ResponseWriter, r *http.Request) {
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
 	request := storage.Request{}
 	if err := json.Unmarshal(b, &request); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	idCookie, err := r.Cookie("user_id")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if h.blocked(request.URL) {
+		http.Error(w, ErrBlockedHost.Error(), http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := redirectKind(request.Redirect)
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := HashPassword(request.Password)
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if request.MaxClicks < 0 {
+		httpError(w, r, ErrMaxClicks, http.StatusBadRequest)
+		return
+	}
+
+	rules, err := h.Settings().CheckRules(request.Rules)
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	variants, err := h.Settings().CheckVariants(request.Variants)
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	query, err := h.Settings().CheckQuery(request.Query)
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	id, err := h.Storage.Set(r.Context(), storage.CreateURL{
-		User: idCookie.Value,
-		URL:  request.URL,
+		User:         idCookie.Value,
+		URL:          request.URL,
+		Redirect:     redirect,
+		PasswordHash: passwordHash,
+		MaxClicks:    request.MaxClicks,
+		Rules:        rules,
+		Variants:     variants,
+		Query:        query,
 	})
 
 	if err != nil {
@@ -115,13 +255,13 @@ func (h *Handler) JSONHandler(w http.ResponseWriter, r *http.Request) {
 		if errors.As(err, &pge) && pge.Code == pgerrcode.UniqueViolation {
 			createURL, err := h.Storage.GetOriginURL(r.Context(), request.URL)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				httpError(w, r, err, http.StatusInternalServerError)
 				return
 			}
 
 			res, err := h.formatResult(createURL.ID)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				httpError(w, r, err, http.StatusInternalServerError)
 				return
 			}
 
@@ -131,13 +271,13 @@ func (h *Handler) JSONHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
 	res, err := h.formatResult(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -147,7 +287,7 @@ func (h *Handler) JSONHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) formatResult(id int) ([]byte, error) {
-	resultURL := h.BaseURL + "/" + fmt.Sprintf("%d", id)
+	resultURL := h.shortURL(id)
 	response := storage.Response{Result: resultURL}
 	return json.Marshal(response)
 }
@@ -155,13 +295,22 @@ func (h *Handler) formatResult(id int) ([]byte, error) {
 func (h *Handler) GetUrlsHandler(w http.ResponseWriter, r *http.Request) {
 	idCookie, err := r.Cookie("user_id")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	createdURLs, err := h.Storage.GetUser(r.Context(), idCookie.Value)
+	query, err := parseListQuery(r.URL.Query(), idCookie.Value)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	// One extra row tells whether another page follows.
+	pageSize := query.Limit
+	query.Limit++
+	createdURLs, err := h.Storage.ListUserURLs(r.Context(), query)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -170,18 +319,20 @@ func (h *Handler) GetUrlsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(createdURLs) > pageSize {
+		createdURLs = createdURLs[:pageSize]
+		setNextLink(w, r, createdURLs[pageSize-1].ID)
+	}
+
 	shortenUrls := make([]storage.ShortURLs, 0, len(createdURLs))
 
 	for _, shortURL := range createdURLs {
-		shortenUrls = append(shortenUrls, storage.ShortURLs{
-			ShortURL:    h.BaseURL + "/" + fmt.Sprintf("%d", shortURL.ID),
-			OriginalURL: shortURL.URL,
-		})
+		shortenUrls = append(shortenUrls, h.userURL(shortURL))
 	}
 
 	res, err := json.Marshal(shortenUrls)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -190,9 +341,26 @@ func (h *Handler) GetUrlsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(res)
 }
 
+func (h *Handler) userURL(createURL storage.CreateURL) storage.ShortURLs {
+	return storage.ShortURLs{
+		ShortURL:    h.shortURL(createURL.ID),
+		OriginalURL: createURL.URL,
+		CreatedAt:   createURL.CreatedAt,
+		Status:      createURL.Status(),
+		Redirect:    createURL.Redirect,
+		Protected:   createURL.PasswordHash != "",
+		Clicks:      createURL.Clicks,
+		MaxClicks:   createURL.MaxClicks,
+		ExpiresAt:   createURL.ExpiresAt,
+		Rules:       createURL.Rules,
+		Variants:    createURL.VariantStats(),
+		Query:       createURL.Query,
+	}
+}
+
 func (h *Handler) PingHandler(w http.ResponseWriter, r *http.Request) {
 	if err := h.Storage.Ping(r.Context()); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -202,34 +370,85 @@ func (h *Handler) PingHandler(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) PostAPIShortenBatchHandler(w http.ResponseWriter, r *http.Request) {
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
 	var batchRequests []storage.BatchRequest
 	if err := json.Unmarshal(b, &batchRequests); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
+	settings := h.Settings()
+	if settings.MaxBatchSize > 0 && len(batchRequests) > settings.MaxBatchSize {
+		http.Error(w, fmt.Sprintf("batch is limited to %d urls", settings.MaxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	for i, batchRequest := range batchRequests {
+		if h.blocked(batchRequest.OriginURL) {
+			http.Error(w, fmt.Sprintf("%s: %s", ErrBlockedHost, batchRequest.CorrelationID), http.StatusBadRequest)
+			return
+		}
+		if _, err := redirectKind(batchRequest.Redirect); err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", err, batchRequest.CorrelationID), http.StatusBadRequest)
+			return
+		}
+		if batchRequest.MaxClicks < 0 {
+			http.Error(w, fmt.Sprintf("%s: %s", ErrMaxClicks, batchRequest.CorrelationID), http.StatusBadRequest)
+			return
+		}
+		if batchRequests[i].Rules, err = settings.CheckRules(batchRequest.Rules); err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", err, batchRequest.CorrelationID), http.StatusBadRequest)
+			return
+		}
+		if batchRequests[i].Variants, err = settings.CheckVariants(batchRequest.Variants); err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", err, batchRequest.CorrelationID), http.StatusBadRequest)
+			return
+		}
+		if batchRequests[i].Query, err = settings.CheckQuery(batchRequest.Query); err != nil {
+			http.Error(w, fmt.Sprintf("%s: %s", err, batchRequest.CorrelationID), http.StatusBadRequest)
+			return
+		}
+	}
+
 	idCookie, err := r.Cookie("user_id")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
+	// Batches tend to share one password, so each is hashed once.
+	hashes := make(map[string]string)
 	shortBatch := make([]storage.ShortenBatch, 0, len(batchRequests))
 	for _, batchRequest := range batchRequests {
+		passwordHash, ok := hashes[batchRequest.Password]
+		if !ok {
+			passwordHash, err = HashPassword(batchRequest.Password)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s: %s", err, batchRequest.CorrelationID), http.StatusBadRequest)
+				return
+			}
+			hashes[batchRequest.Password] = passwordHash
+		}
+
 		shortBatch = append(shortBatch, storage.ShortenBatch{
 			User:          idCookie.Value,
 			URL:           batchRequest.OriginURL,
 			CorrelationID: batchRequest.CorrelationID,
+			Redirect:      batchRequest.Redirect,
+			PasswordHash:  passwordHash,
+			MaxClicks:     batchRequest.MaxClicks,
+			Rules:         batchRequest.Rules,
+			Variants:      batchRequest.Variants,
+			Query:         batchRequest.Query,
 		})
 	}
 
 	shortBatch, err = h.Storage.PutBatch(r.Context(), shortBatch)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -238,13 +457,13 @@ func (h *Handler) PostAPIShortenBatchHandler(w http.ResponseWriter, r *http.Requ
 	for _, batchresp := range shortBatch {
 		batchResponses = append(batchResponses, storage.BatchResponse{
 			CorrelationID: batchresp.CorrelationID,
-			ShortURL:      h.BaseURL + "/" + fmt.Sprintf("%d", batchresp.ID),
+			ShortURL:      h.shortURL(batchresp.ID),
 		})
 	}
 
 	res, err := json.Marshal(batchResponses)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -256,13 +475,13 @@ func (h *Handler) PostAPIShortenBatchHandler(w http.ResponseWriter, r *http.Requ
 func (h *Handler) DeleteUrlsHandler(w http.ResponseWriter, r *http.Request) {
 	b, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
 	var deleteIDs []string
 	if err := json.Unmarshal(b, &deleteIDs); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
@@ -270,7 +489,7 @@ func (h *Handler) DeleteUrlsHandler(w http.ResponseWriter, r *http.Request) {
 	for _, deleteID := range deleteIDs {
 		id, err := strconv.Atoi(deleteID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			httpError(w, r, err, http.StatusInternalServerError)
 			return
 		}
 
@@ -279,21 +498,19 @@ func (h *Handler) DeleteUrlsHandler(w http.ResponseWriter, r *http.Request) {
 
 	idCookie, err := r.Cookie("user_id")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
 	outCh := make(chan int)
+	collected := make(chan []int)
 
 	go func() {
 		var idsToDelete []int
 		for id := range outCh {
 			idsToDelete = append(idsToDelete, id)
 		}
-		err = h.Storage.DeleteURLs(r.Context(), idsToDelete)
-		if err != nil {
-			return
-		}
+		collected <- idsToDelete
 	}()
 
 	wg := &sync.WaitGroup{}
@@ -312,7 +529,86 @@ func (h *Handler) DeleteUrlsHandler(w http.ResponseWriter, r *http.Request) {
 		}(id)
 	}
 
-	w.WriteHeader(http.StatusAccepted)
 	wg.Wait()
 	close(outCh)
+
+	task := workers.DeleteTask{
+		User:      idCookie.Value,
+		IDs:       <-collected,
+		RequestID: logger.RequestID(r.Context()),
+	}
+	if h.Deleter == nil {
+		err = h.Storage.DeleteURLs(r.Context(), task.IDs)
+	} else {
+		err = h.Deleter.Push(task)
+	}
+
+	if err != nil {
+		httpError(w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RestoreUrlsHandler undeletes the caller's links that were deleted within
+// the restore window and answers with the ids that were restored.
+func (h *Handler) RestoreUrlsHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	var restoreIDs []string
+	if err := json.Unmarshal(b, &restoreIDs); err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	window := h.Settings().RestoreWindow
+	ids := make([]int, 0, len(restoreIDs))
+	restored := make([]string, 0, len(restoreIDs))
+	for _, restoreID := range restoreIDs {
+		id, err := strconv.Atoi(restoreID)
+		if err != nil {
+			httpError(w, r, err, http.StatusBadRequest)
+			return
+		}
+
+		record, err := h.Storage.Lookup(r.Context(), id)
+		if err != nil || record.User != idCookie.Value || !record.Deleted {
+			continue
+		}
+		if window > 0 && record.DeletedAt != nil && time.Since(*record.DeletedAt) > window {
+			continue
+		}
+
+		ids = append(ids, id)
+		restored = append(restored, restoreID)
+	}
+
+	if err := h.Storage.RestoreURLs(r.Context(), ids); err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, restored)
+}
+
+func httpError(w http.ResponseWriter, r *http.Request, err error, code int) {
+	if errors.Is(err, middlewares.ErrBodyTooLarge) {
+		code = http.StatusRequestEntityTooLarge
+	}
+	if code >= http.StatusInternalServerError {
+		logger.FromContext(r.Context()).Error("request failed", "status", code, "error", err)
+	}
+
+	http.Error(w, err.Error(), code)
 }
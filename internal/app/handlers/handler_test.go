@@ -1,20 +1,34 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Fedorova199/red-cat/internal/app/middlewares"
 	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/app/webhooks"
 	"github.com/Fedorova199/red-cat/internal/interfaces"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func TestNewHandler(t *testing.T) {
@@ -190,7 +204,7 @@ func TestHandler_GetHandler(t *testing.T) {
 				File: file,
 			},
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "",
 				statusCode:  307,
 				redirectURL: "/test2.ru",
 			},
@@ -384,3 +398,1605 @@ func TestHandler_JSONHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_ReadyzHandler(t *testing.T) {
+	file, err := ioutil.TempFile("", "test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	tests := []struct {
+		name       string
+		storage    interfaces.Storage
+		draining   bool
+		statusCode int
+		body       string
+	}{
+		{
+			name:       "ready #1",
+			storage:    &storage.Models{Model: map[int]storage.CreateURL{}, File: file},
+			statusCode: 200,
+			body:       `"storage":{"status":"ok"}`,
+		},
+		{
+			name:       "storage without file #2",
+			storage:    &storage.Models{Model: map[int]storage.CreateURL{}},
+			statusCode: 503,
+			body:       `"storage":{"status":"unavailable"`,
+		},
+		{
+			name:       "draining #3",
+			storage:    &storage.Models{Model: map[int]storage.CreateURL{}, File: file},
+			draining:   true,
+			statusCode: 503,
+			body:       `"server":{"status":"draining"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewHandler(tt.storage, "test.ru", nil)
+			if tt.draining {
+				handler.SetDraining()
+			}
+			ts := httptest.NewServer(handler)
+			defer ts.Close()
+
+			resp, body := testRequest(t, ts, http.MethodGet, "/readyz", nil)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			assert.Contains(t, body, tt.body)
+
+			resp, _ = testRequest(t, ts, http.MethodGet, "/healthz", nil)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  string
+		target   string
+		location string
+	}{
+		{
+			name:     "default port #1",
+			address:  ":443",
+			target:   "http://short.example/abc?x=1",
+			location: "https://short.example/abc?x=1",
+		},
+		{
+			name:     "custom port #2",
+			address:  ":8443",
+			target:   "http://short.example:8080/api/user/urls",
+			location: "https://short.example:8443/api/user/urls",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			HTTPSRedirect(tt.address)(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestHandler_GetUrlsHandler_ForgedCookie(t *testing.T) {
+	storage := &storage.Models{
+		Counter: 2,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "victim", URL: "test1.ru"},
+		},
+	}
+	handler := NewHandler(storage, "test.ru", []interfaces.Middleware{
+		middlewares.NewAuth([]byte("secret key")),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "victim"})
+	req.AddCookie(&http.Cookie{Name: "sign", Value: "00"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.NotEqual(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "test1.ru")
+}
+
+func TestHandler_StatsHandler(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		subnet     *net.IPNet
+		realIP     string
+		statusCode int
+		body       string
+	}{
+		{
+			name:       "trusted #1",
+			subnet:     subnet,
+			realIP:     "10.1.2.3",
+			statusCode: http.StatusOK,
+			body:       `{"urls":3,"users":2}`,
+		},
+		{
+			name:       "outside subnet #2",
+			subnet:     subnet,
+			realIP:     "192.168.1.1",
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "no header #3",
+			subnet:     subnet,
+			statusCode: http.StatusForbidden,
+		},
+		{
+			name:       "subnet not configured #4",
+			realIP:     "10.1.2.3",
+			statusCode: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &storage.Models{
+				Counter: 5,
+				Model: map[int]storage.CreateURL{
+					1: {ID: 1, User: "user1", URL: "test1.ru"},
+					2: {ID: 2, User: "user1", URL: "test2.ru"},
+					3: {ID: 3, User: "user2", URL: "test3.ru"},
+					4: {ID: 4, User: "user3", URL: "test4.ru", Deleted: true},
+				},
+			}
+			handler := NewHandler(storage, "test.ru", nil)
+			handler.SetSettings(Settings{BaseURL: "test.ru", TrustedSubnet: tt.subnet})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.body != "" {
+				assert.JSONEq(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_Admin(t *testing.T) {
+	storage := &storage.Models{
+		Counter: 3,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user1", URL: "https://abuse.example"},
+			2: {ID: 2, User: "user1", URL: "https://fine.example"},
+		},
+	}
+	handler := NewHandler(storage, "http://test.ru", nil)
+
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodGet, "/api/admin/urls/1", "admin")
+	assert.Equal(t, http.StatusForbidden, w.Code, "disabled without a token")
+
+	handler.SetSettings(Settings{BaseURL: "http://test.ru", AdminToken: "admin"})
+
+	w = do(http.MethodGet, "/api/admin/urls/1", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = do(http.MethodGet, "/api/admin/urls/1", "admin")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":1,"short_url":"http://test.ru/1","original_url":"https://abuse.example","user_id":"user1","deleted":false}`, w.Body.String())
+
+	w = do(http.MethodGet, "/api/admin/urls?origin_url=https://fine.example", "admin")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":2`)
+
+	w = do(http.MethodGet, "/api/admin/urls/9", "admin")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(http.MethodDelete, "/api/admin/urls/1", "admin")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = do(http.MethodGet, "/1", "")
+	assert.Equal(t, http.StatusGone, w.Code)
+
+	w = do(http.MethodGet, "/api/admin/users/user1/urls", "admin")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":1,"short_url":"http://test.ru/1","original_url":"https://abuse.example","user_id":"user1","deleted":true`)
+
+	w = do(http.MethodPost, "/api/admin/urls/1/restore", "admin")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = do(http.MethodGet, "/1", "")
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+	actions := make([]string, 0, len(storage.Audit))
+	for _, record := range storage.Audit {
+		actions = append(actions, record.Action+" "+record.Target)
+	}
+	assert.Equal(t, []string{
+		"lookup 1",
+		"lookup https://fine.example",
+		"lookup 9",
+		"force_delete 1",
+		"list_user user1",
+		"restore 1",
+	}, actions)
+}
+
+func TestHandler_RestoreUrlsHandler(t *testing.T) {
+	recently := time.Now().Add(-time.Hour)
+	longAgo := time.Now().Add(-48 * time.Hour)
+	storage := &storage.Models{
+		Counter: 5,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user1", URL: "test1.ru", Deleted: true, DeletedAt: &recently},
+			2: {ID: 2, User: "user1", URL: "test2.ru", Deleted: true, DeletedAt: &longAgo},
+			3: {ID: 3, User: "user2", URL: "test3.ru", Deleted: true, DeletedAt: &recently},
+			4: {ID: 4, User: "user1", URL: "test4.ru"},
+		},
+	}
+	handler := NewHandler(storage, "test.ru", nil)
+	handler.SetSettings(Settings{BaseURL: "test.ru", RestoreWindow: 24 * time.Hour})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", strings.NewReader(`["1","2","3","4","5"]`))
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user1"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `["1"]`, w.Body.String())
+	assert.False(t, storage.Model[1].Deleted)
+	assert.Nil(t, storage.Model[1].DeletedAt)
+	assert.True(t, storage.Model[2].Deleted)
+	assert.True(t, storage.Model[3].Deleted)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", strings.NewReader(`["x"]`))
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user1"})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandler_GetUrlsHandler_Pagination(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	storage := &storage.Models{
+		Counter: 6,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user1", URL: "https://a.example/one", CreatedAt: day(1)},
+			2: {ID: 2, User: "user1", URL: "https://b.example/two", CreatedAt: day(2)},
+			3: {ID: 3, User: "user1", URL: "https://a.example/three", CreatedAt: day(3), Deleted: true},
+			4: {ID: 4, User: "user1", URL: "https://a.example/four", CreatedAt: day(4)},
+			5: {ID: 5, User: "user2", URL: "https://a.example/five", CreatedAt: day(5)},
+		},
+	}
+	handler := NewHandler(storage, "http://test.ru", nil)
+
+	list := func(target string) (*httptest.ResponseRecorder, []string) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.AddCookie(&http.Cookie{Name: "user_id", Value: "user1"})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var items []struct {
+			ShortURL string `json:"short_url"`
+			Status   string `json:"status"`
+		}
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+		}
+		ids := make([]string, 0, len(items))
+		for _, item := range items {
+			ids = append(ids, strings.TrimPrefix(item.ShortURL, "http://test.ru/")+":"+item.Status)
+		}
+		return w, ids
+	}
+
+	w, ids := list("/api/user/urls?limit=2")
+	assert.Equal(t, []string{"1:active", "2:active"}, ids)
+	next := w.Header().Get("Link")
+	require.Contains(t, next, `rel="next"`)
+	nextURL := strings.TrimSuffix(strings.TrimPrefix(next, "<"), `>; rel="next"`)
+
+	w, ids = list(nextURL)
+	assert.Equal(t, []string{"4:active"}, ids)
+	assert.Empty(t, w.Header().Get("Link"))
+
+	_, ids = list("/api/user/urls?sort=desc&status=all")
+	assert.Equal(t, []string{"4:active", "3:deleted", "2:active", "1:active"}, ids)
+
+	_, ids = list("/api/user/urls?q=A.EXAMPLE&from=2024-01-02")
+	assert.Equal(t, []string{"4:active"}, ids)
+
+	_, ids = list("/api/user/urls?to=2024-01-02T00:00:00Z")
+	assert.Equal(t, []string{"1:active"}, ids)
+
+	w, _ = list("/api/user/urls?q=nothing")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	for _, target := range []string{
+		"/api/user/urls?limit=0",
+		"/api/user/urls?cursor=bm9wZQ",
+		"/api/user/urls?sort=sideways",
+		"/api/user/urls?status=gone",
+		"/api/user/urls?from=yesterday",
+	} {
+		w, _ = list(target)
+		assert.Equal(t, http.StatusBadRequest, w.Code, target)
+	}
+}
+
+func TestHandler_ExportUrlsHandler(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	storage := &storage.Models{
+		Counter: 4,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user1", URL: "https://a.example/?x=1,2", CreatedAt: created, ExpiresAt: &expires},
+			2: {ID: 2, User: "user1", URL: "https://b.example", CreatedAt: created, Deleted: true},
+			3: {ID: 3, User: "user2", URL: "https://c.example", CreatedAt: created},
+		},
+	}
+	handler := NewHandler(storage, "http://test.ru", nil)
+
+	tests := []struct {
+		name        string
+		target      string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "csv by query #1",
+			target:      "/api/user/urls/export?format=csv",
+			contentType: "text/csv; charset=utf-8",
+			body: "short_url,original_url,created_at,status,expires_at\n" +
+				"http://test.ru/1,\"https://a.example/?x=1,2\",2024-01-02T03:04:05Z,active,2030-01-01T00:00:00Z\n",
+		},
+		{
+			name:        "jsonl by default with filters #2",
+			target:      "/api/user/urls/export?status=all&sort=desc",
+			contentType: "application/x-ndjson",
+			body: `{"short_url":"http://test.ru/2","original_url":"https://b.example","created_at":"2024-01-02T03:04:05Z","status":"deleted"}` + "\n" +
+				`{"short_url":"http://test.ru/1","original_url":"https://a.example/?x=1,2","created_at":"2024-01-02T03:04:05Z","status":"active","expires_at":"2030-01-01T00:00:00Z"}` + "\n",
+		},
+		{
+			name:        "csv by accept #3",
+			target:      "/api/user/urls/export?q=nothing",
+			accept:      "text/csv",
+			contentType: "text/csv; charset=utf-8",
+			body:        "short_url,original_url,created_at,status,expires_at\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.AddCookie(&http.Cookie{Name: "user_id", Value: "user1"})
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, tt.body, w.Body.String())
+		})
+	}
+}
+
+type failingList struct {
+	*storage.Models
+}
+
+func (failingList) ListUserURLs(ctx context.Context, query storage.ListQuery) ([]storage.CreateURL, error) {
+	return nil, errors.New("connection lost")
+}
+
+func TestHandler_ExportUrlsHandler_Error(t *testing.T) {
+	handler := NewHandler(failingList{&storage.Models{Model: map[int]storage.CreateURL{}}}, "http://test.ru", nil)
+
+	for _, target := range []string{"/api/user/urls/export?format=csv", "/api/user/urls/export"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.AddCookie(&http.Cookie{Name: "user_id", Value: "user1"})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code, target)
+		assert.Empty(t, w.Header().Get("Content-Disposition"), target)
+		assert.NotContains(t, w.Body.String(), "short_url", target)
+	}
+}
+
+func TestHandler_ImportUrlsHandler(t *testing.T) {
+	models := &storage.Models{
+		Counter: 2,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "someone", URL: "https://exists.example"},
+		},
+	}
+	handler := NewHandler(models, "http://test.ru", nil)
+	handler.SetSettings(Settings{BaseURL: "http://test.ru", BlockedHosts: []string{"evil.com"}})
+
+	importRows := func(contentType, body string) storage.ImportResult {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/urls/import", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.AddCookie(&http.Cookie{Name: "user_id", Value: "user1"})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var result storage.ImportResult
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+
+	result := importRows("text/csv", "original_url,alias,expires_at\n"+
+		"https://new.example,,\n"+
+		"https://exists.example,,\n"+
+		"https://evil.com/x,,\n"+
+		"https://new.example,,\n"+
+		",,\n"+
+		"https://aliased.example,promo,2030-01-01\n"+
+		"https://expired.example,,2000-01-01T00:00:00Z\n"+
+		"https://exists.example/later,,soon\n"+
+		"\"broken,\n")
+	assert.Equal(t, 2, result.Imported)
+	assert.Equal(t, 1, result.Existing)
+	assert.Equal(t, 6, result.Failed)
+	require.Len(t, result.Rows, 9)
+	assert.Equal(t, "http://test.ru/2", result.Rows[0].ShortURL)
+	assert.True(t, result.Rows[1].Existed)
+	assert.Equal(t, "http://test.ru/1", result.Rows[1].ShortURL)
+	assert.Equal(t, ErrBlockedHost.Error(), result.Rows[2].Error)
+	assert.Equal(t, "duplicate of row 1", result.Rows[3].Error)
+	assert.Equal(t, "original_url is required", result.Rows[4].Error)
+	assert.Equal(t, []string{"alias is not supported and was ignored"}, result.Rows[5].Warnings)
+	assert.Equal(t, ErrPastExpiry.Error(), result.Rows[6].Error)
+	assert.Equal(t, "expires_at must be an RFC 3339 time or a date", result.Rows[7].Error)
+	assert.NotEmpty(t, result.Rows[8].Error)
+	require.NotNil(t, models.Model[3].ExpiresAt)
+	assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), *models.Model[3].ExpiresAt)
+	assert.Nil(t, models.Model[2].ExpiresAt)
+
+	result = importRows("application/x-ndjson", `{"original_url":"https://lines.example"}`+"\n\n"+`not json`+"\n")
+	assert.Equal(t, 1, result.Imported)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, 3, result.Rows[1].Row)
+	assert.Equal(t, "user1", models.Model[4].User)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/user/urls/import?format=xml", strings.NewReader(""))
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user1"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandler_Redirect(t *testing.T) {
+	models := &storage.Models{
+		Counter: 6,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "http://test1.ru"},
+			2: {ID: 2, User: "user", URL: "http://test2.ru", Redirect: storage.RedirectMovedPermanently},
+			3: {ID: 3, User: "user", URL: "http://test3.ru", Redirect: storage.RedirectPermanent},
+			4: {ID: 4, User: "user", URL: "http://test4.ru?a=1&b=<2>", Redirect: storage.RedirectInterstitial},
+			5: {ID: 5, User: "user", URL: "http://test5.ru", Redirect: storage.RedirectTemporary},
+		},
+	}
+	handler := NewHandler(models, "test.ru", nil)
+	handler.SetSettings(Settings{BaseURL: "test.ru", Redirect: storage.RedirectFound})
+
+	tests := []struct {
+		name       string
+		path       string
+		statusCode int
+		location   string
+	}{
+		{name: "server default #1", path: "/1", statusCode: http.StatusFound, location: "http://test1.ru"},
+		{name: "moved permanently #2", path: "/2", statusCode: http.StatusMovedPermanently, location: "http://test2.ru"},
+		{name: "permanent redirect #3", path: "/3", statusCode: http.StatusPermanentRedirect, location: "http://test3.ru"},
+		{name: "interstitial #4", path: "/4", statusCode: http.StatusOK},
+		{name: "temporary redirect #5", path: "/5", statusCode: http.StatusTemporaryRedirect, location: "http://test5.ru"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+			if tt.location != "" {
+				assert.Empty(t, w.Header().Get("Content-Type"))
+				assert.Empty(t, w.Body.String())
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/4", nil))
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `href="http://test4.ru?a=1&amp;b=%3c2%3e"`)
+	assert.Contains(t, w.Body.String(), "test4.ru")
+}
+
+func TestHandler_CreateWithRedirect(t *testing.T) {
+	models := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+	handler := NewHandler(models, "test.ru", nil)
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		statusCode int
+		redirect   string
+	}{
+		{name: "text body #1", path: "/?redirect=308", body: "http://test1.ru", statusCode: http.StatusCreated, redirect: "308"},
+		{name: "json body #2", path: "/api/shorten", body: `{"url":"http://test2.ru","redirect":"interstitial"}`, statusCode: http.StatusCreated, redirect: "interstitial"},
+		{name: "no redirect #3", path: "/api/shorten", body: `{"url":"http://test3.ru"}`, statusCode: http.StatusCreated},
+		{name: "unknown redirect #4", path: "/?redirect=303", body: "http://test4.ru", statusCode: http.StatusBadRequest},
+		{name: "unknown batch redirect #5", path: "/api/shorten/batch", body: `[{"correlation_id":"a","original_url":"http://test5.ru","redirect":"300"}]`, statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode == http.StatusCreated {
+				createURL, err := models.Get(req.Context(), models.Counter-1)
+				require.NoError(t, err)
+				assert.Equal(t, tt.redirect, createURL.Redirect)
+			}
+		})
+	}
+	assert.Len(t, models.Model, 3)
+}
+
+func TestHandler_PreviewHandler(t *testing.T) {
+	createdAt := time.Date(2022, 3, 14, 10, 0, 0, 0, time.UTC)
+	models := &storage.Models{
+		Counter: 3,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "http://test1.ru/<b>", CreatedAt: createdAt, Clicks: 7},
+			2: {ID: 2, User: "user", URL: "http://test2.ru", Deleted: true},
+		},
+	}
+	handler := NewHandler(models, "http://short.ru", nil)
+
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		statusCode  int
+		contentType string
+	}{
+		{name: "plus suffix #1", path: "/1+", statusCode: http.StatusOK, contentType: "text/html; charset=utf-8"},
+		{name: "preview path #2", path: "/1/preview", statusCode: http.StatusOK, contentType: "text/html; charset=utf-8"},
+		{name: "json #3", path: "/1+", accept: "application/json", statusCode: http.StatusOK, contentType: "application/json"},
+		{name: "deleted #4", path: "/2+", statusCode: http.StatusGone, contentType: "text/plain; charset=utf-8"},
+		{name: "missing #5", path: "/9/preview", statusCode: http.StatusNotFound, contentType: "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.Empty(t, w.Header().Get("Location"))
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/1+", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.JSONEq(t, `{"short_url":"http://short.ru/1","original_url":"http://test1.ru/<b>","created_at":"2022-03-14T10:00:00Z","clicks":7}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1+", nil))
+	body := w.Body.String()
+	assert.Contains(t, body, "http://test1.ru/&lt;b&gt;")
+	assert.Contains(t, body, "2022-03-14")
+	assert.Contains(t, body, "7 times")
+	assert.Contains(t, body, `href="http://short.ru/1"`)
+	assert.Equal(t, 7, models.Model[1].Clicks)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, 8, models.Model[1].Clicks)
+}
+
+func TestHandler_QRHandler(t *testing.T) {
+	models := &storage.Models{
+		Counter: 3,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "http://test1.ru"},
+			2: {ID: 2, User: "user", URL: "http://test2.ru", Deleted: true},
+		},
+	}
+	handler := NewHandler(models, "http://short.ru", nil)
+
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		statusCode  int
+		contentType string
+	}{
+		{name: "png #1", path: "/1/qr", statusCode: http.StatusOK, contentType: "image/png"},
+		{name: "svg by format #2", path: "/1/qr?format=svg&ec=H&margin=0", statusCode: http.StatusOK, contentType: "image/svg+xml"},
+		{name: "svg by accept #3", path: "/1/qr", accept: "image/svg+xml", statusCode: http.StatusOK, contentType: "image/svg+xml"},
+		{name: "deleted #4", path: "/2/qr", statusCode: http.StatusGone},
+		{name: "unknown format #5", path: "/1/qr?format=gif", statusCode: http.StatusBadRequest},
+		{name: "size too large #6", path: "/1/qr?size=100000", statusCode: http.StatusBadRequest},
+		{name: "unknown level #7", path: "/1/qr?ec=X", statusCode: http.StatusBadRequest},
+		{name: "negative margin #8", path: "/1/qr?margin=-1", statusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode == http.StatusOK {
+				assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+				assert.Contains(t, w.Header().Get("Cache-Control"), "max-age=31536000")
+				assert.NotEmpty(t, w.Header().Get("ETag"))
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/1/qr?size=300", nil))
+	img, err := png.Decode(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 300, img.Bounds().Dx())
+
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	require.NoError(t, err)
+	result, err := qrcode.NewQRCodeReader().Decode(bmp, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "http://short.ru/1", result.GetText())
+
+	req := httptest.NewRequest(http.MethodGet, "/1/qr?size=300", nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestHandler_PasswordProtected(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	models := &storage.Models{
+		Counter: 3,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "http://test1.ru", PasswordHash: string(hash)},
+			2: {ID: 2, User: "user", URL: "http://test2.ru", PasswordHash: string(hash)},
+		},
+	}
+	handler := NewHandler(models, "http://short.ru", nil)
+
+	get := func(path string, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for key := range header {
+			req.Header.Set(key, header.Get(key))
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		return w
+	}
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		statusCode int
+		body       string
+	}{
+		{name: "form #1", header: http.Header{"Accept": {"text/html"}}, statusCode: http.StatusUnauthorized, body: `name="password"`},
+		{name: "plain text #2", statusCode: http.StatusUnauthorized, body: ErrPasswordNeeded.Error()},
+		{name: "wrong header #3", header: http.Header{passwordHeader: {"guess"}}, statusCode: http.StatusUnauthorized, body: ErrPasswordInvalid.Error()},
+		{name: "right header #4", header: http.Header{passwordHeader: {"secret"}}, statusCode: http.StatusTemporaryRedirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get("/1", tt.header)
+
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Contains(t, w.Body.String(), tt.body)
+		})
+	}
+	assert.Equal(t, 1, models.Model[1].Clicks)
+
+	w := get("/1", http.Header{passwordHeader: {"secret"}})
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "unlock_1", cookies[0].Name)
+	assert.Equal(t, "/", cookies[0].Path)
+
+	// A browser sends the cookie to the preview pages of the link too.
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	linkURL, err := url.Parse("http://short.ru/1")
+	require.NoError(t, err)
+	jar.SetCookies(linkURL, cookies)
+	for _, path := range []string{"/1", "/1+", "/1/preview"} {
+		sent := jar.Cookies(linkURL.ResolveReference(&url.URL{Path: path}))
+		require.Len(t, sent, 1, path)
+		assert.Equal(t, "unlock_1", sent[0].Name, path)
+	}
+
+	w = get("/1+", http.Header{"Accept": {"application/json"}}, cookies[0])
+	assert.Contains(t, w.Body.String(), "http://test1.ru")
+
+	w = get("/1", nil, cookies[0])
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "http://test1.ru", w.Header().Get("Location"))
+
+	w = get("/1/preview", http.Header{"Accept": {"application/json"}}, cookies[0])
+	assert.Contains(t, w.Body.String(), "http://test1.ru")
+	w = get("/1/preview", http.Header{"Accept": {"application/json"}})
+	assert.NotContains(t, w.Body.String(), "http://test1.ru")
+	assert.Contains(t, w.Body.String(), `"protected":true`)
+
+	forged := &http.Cookie{Name: "unlock_2", Value: strings.Replace(cookies[0].Value, ".", ".0", 1)}
+	assert.Equal(t, http.StatusUnauthorized, get("/2", nil, forged).Code)
+	other := &http.Cookie{Name: "unlock_2", Value: cookies[0].Value}
+	assert.Equal(t, http.StatusUnauthorized, get("/2", nil, other).Code)
+
+	post := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/2", strings.NewReader("password="+password))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "text/html")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w = post("guess")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), ErrPasswordInvalid.Error())
+
+	w = post("secret")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/2", w.Header().Get("Location"))
+	require.Len(t, w.Result().Cookies(), 1)
+	assert.Equal(t, http.StatusTemporaryRedirect, get("/2", nil, w.Result().Cookies()[0]).Code)
+
+	for i := 0; i < passwordAttemptBurst-2; i++ {
+		assert.Equal(t, http.StatusUnauthorized, post("guess").Code)
+	}
+	w = post("secret")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestHandler_CreateWithPassword(t *testing.T) {
+	models := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+	handler := NewHandler(models, "test.ru", nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"http://test1.ru","password":"secret"}`))
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(models.Model[1].PasswordHash), []byte("secret")))
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("http://test2.ru"))
+	req.Header.Set(passwordHeader, strings.Repeat("x", maxPasswordLength+1))
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, models.Model, 1)
+}
+
+// deletedOnClick deletes each link just before its click is counted, as a
+// concurrent DELETE would.
+type deletedOnClick struct {
+	*storage.Models
+}
+
+func (s deletedOnClick) AddClick(ctx context.Context, id int, variant string) (int, error) {
+	if err := s.Models.DeleteURLs(ctx, []int{id}); err != nil {
+		return 0, err
+	}
+
+	return s.Models.AddClick(ctx, id, variant)
+}
+
+func TestHandler_DeletedBeforeClick(t *testing.T) {
+	models := &storage.Models{
+		Counter: 3,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "http://test1.ru", MaxClicks: 1},
+			2: {ID: 2, User: "user", URL: "http://test2.ru"},
+		},
+	}
+	handler := NewHandler(deletedOnClick{models}, "http://short.ru", nil)
+
+	for _, path := range []string{"/1", "/2"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+	assert.Zero(t, models.Model[1].Clicks)
+	assert.Zero(t, models.Model[2].Clicks)
+}
+
+func TestHandler_MaxClicks(t *testing.T) {
+	models := &storage.Models{
+		Counter: 3,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "http://test1.ru", MaxClicks: 1},
+			2: {ID: 2, User: "user", URL: "http://test2.ru", MaxClicks: 3},
+		},
+	}
+	handler := NewHandler(models, "http://short.ru", nil)
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	codes := make(chan int, 20)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- get("/1")
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{http.StatusTemporaryRedirect: 1, http.StatusGone: 19}, counts)
+	assert.Equal(t, 1, models.Model[1].Clicks)
+	assert.Equal(t, storage.StatusExhausted, models.Model[1].Status())
+	assert.Equal(t, http.StatusGone, get("/1+"))
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusTemporaryRedirect, get("/2"))
+	}
+	assert.Equal(t, http.StatusGone, get("/2"))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"http://test3.ru","max_clicks":-1}`))
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/?max_clicks=1", strings.NewReader("http://test3.ru"))
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 1, models.Model[3].MaxClicks)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/user/urls?status=exhausted", nil)
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed []storage.ShortURLs
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed, 2)
+	assert.Equal(t, storage.StatusExhausted, listed[0].Status)
+}
+
+func TestHandler_PatchURLHandler(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	models := &storage.Models{
+		Counter: 5,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "http://test1.ru"},
+			2: {ID: 2, User: "other", URL: "http://test2.ru"},
+			3: {ID: 3, User: "user", URL: "http://test3.ru", Deleted: true},
+			4: {ID: 4, User: "user", URL: "http://test4.ru", ExpiresAt: &past},
+		},
+	}
+	handler := NewHandler(models, "http://short.ru", nil)
+	handler.SetSettings(Settings{BaseURL: "http://short.ru", BlockedHosts: []string{"evil.ru"}})
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		code int
+	}{
+		{name: "change destination #1", path: "/api/user/urls/1", body: `{"original_url":"http://new1.ru"}`, code: http.StatusOK},
+		{name: "change redirect #2", path: "/api/user/urls/1", body: `{"redirect":"301"}`, code: http.StatusOK},
+		{name: "another user #3", path: "/api/user/urls/2", body: `{"original_url":"http://new2.ru"}`, code: http.StatusForbidden},
+		{name: "missing link #4", path: "/api/user/urls/9", body: `{"redirect":"301"}`, code: http.StatusNotFound},
+		{name: "deleted link #5", path: "/api/user/urls/3", body: `{"redirect":"301"}`, code: http.StatusGone},
+		{name: "taken destination #6", path: "/api/user/urls/1", body: `{"original_url":"http://test2.ru"}`, code: http.StatusConflict},
+		{name: "blocked destination #7", path: "/api/user/urls/1", body: `{"original_url":"http://evil.ru"}`, code: http.StatusBadRequest},
+		{name: "unknown redirect #8", path: "/api/user/urls/1", body: `{"redirect":"303"}`, code: http.StatusBadRequest},
+		{name: "expiry in the past #9", path: "/api/user/urls/1", body: `{"expires_at":"2000-01-01T00:00:00Z"}`, code: http.StatusBadRequest},
+		{name: "empty body #10", path: "/api/user/urls/1", body: `{}`, code: http.StatusBadRequest},
+		{name: "clear expiry #11", path: "/api/user/urls/4", body: `{"expires_at":null}`, code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, tt.path, strings.NewReader(tt.body))
+			req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+		})
+	}
+
+	assert.Equal(t, "http://new1.ru", models.Model[1].URL)
+	assert.Equal(t, storage.RedirectMovedPermanently, models.Model[1].Redirect)
+	assert.Equal(t, "http://test2.ru", models.Model[2].URL)
+	assert.Nil(t, models.Model[4].ExpiresAt)
+}
+
+func TestHandler_URLHistory(t *testing.T) {
+	models := &storage.Models{
+		Counter: 3,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "http://test1.ru"},
+			2: {ID: 2, User: "other", URL: "http://test2.ru"},
+		},
+	}
+	handler := NewHandler(models, "http://short.ru", nil)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, destination := range []string{"http://v2.ru", "http://v3.ru"} {
+		require.Equal(t, http.StatusOK, do(http.MethodPatch, "/api/user/urls/1", `{"original_url":"`+destination+`"}`).Code)
+	}
+	// Changing only the redirect kind keeps the destination out of history.
+	require.Equal(t, http.StatusOK, do(http.MethodPatch, "/api/user/urls/1", `{"redirect":"308"}`).Code)
+
+	w := do(http.MethodGet, "/api/user/urls/1/history", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var versions []storage.URLVersion
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &versions))
+	require.Len(t, versions, 2)
+	assert.Equal(t, 1, versions[0].Version)
+	assert.Equal(t, "http://test1.ru", versions[0].OriginalURL)
+	assert.Equal(t, "http://v2.ru", versions[1].OriginalURL)
+
+	get := httptest.NewRecorder()
+	handler.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/1", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, get.Code)
+	assert.Equal(t, "http://v3.ru", get.Header().Get("Location"))
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/user/urls/2/history", "").Code)
+}
+
+func TestHandler_Expiry(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	models := &storage.Models{
+		Counter: 3,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "http://test1.ru", ExpiresAt: &future},
+			2: {ID: 2, User: "user", URL: "http://test2.ru", ExpiresAt: &past},
+		},
+	}
+	handler := NewHandler(models, "http://short.ru", nil)
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusTemporaryRedirect, get("/1"))
+	assert.Equal(t, http.StatusGone, get("/2"))
+	assert.Equal(t, http.StatusGone, get("/2+"))
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/user/urls/1", strings.NewReader(`{"expires_at":"`+past.Format(time.RFC3339)+`"}`))
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/user/urls?status=expired", nil)
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []storage.ShortURLs
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, "http://short.ru/2", listed[0].ShortURL)
+	assert.Equal(t, storage.StatusExpired, listed[0].Status)
+	require.NotNil(t, listed[0].ExpiresAt)
+}
+
+func TestHandler_Rules(t *testing.T) {
+	models := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+	handler := NewHandler(models, "http://short.ru", nil)
+	handler.SetSettings(Settings{BaseURL: "http://short.ru", CountryHeader: "X-Country-Code", BlockedHosts: []string{"evil.ru"}})
+
+	body := `{"url":"https://example.com","rules":[
+		{"platform":"iOS","url":"https://apps.apple.com/app"},
+		{"platform":"android","url":"https://play.google.com/store/apps"},
+		{"language":"de","country":"at","url":"https://example.com/at"},
+		{"language":"de","url":"https://example.com/de"}
+	]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+	req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Len(t, models.Model[1].Rules, 4)
+	assert.Equal(t, "ios", models.Model[1].Rules[0].Platform)
+	assert.Equal(t, "AT", models.Model[1].Rules[2].Country)
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		location string
+	}{
+		{name: "iphone #1", headers: map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X)"}, location: "https://apps.apple.com/app"},
+		{name: "android #2", headers: map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 14; Pixel 8)"}, location: "https://play.google.com/store/apps"},
+		{name: "desktop #3", headers: map[string]string{"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64)"}, location: "https://example.com"},
+		{name: "german #4", headers: map[string]string{"Accept-Language": "de-DE,de;q=0.9,en;q=0.8"}, location: "https://example.com/de"},
+		{name: "german in austria #5", headers: map[string]string{"Accept-Language": "de-AT", "X-Country-Code": "at"}, location: "https://example.com/at"},
+		{name: "german second choice #6", headers: map[string]string{"Accept-Language": "en-US,de;q=0.5"}, location: "https://example.com"},
+		{name: "first rule wins #7", headers: map[string]string{"User-Agent": "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)", "Accept-Language": "de"}, location: "https://apps.apple.com/app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/1", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+			assert.Equal(t, []string{"User-Agent", "Accept-Language", "X-Country-Code"}, w.Header().Values("Vary"))
+		})
+	}
+
+	// A rule whose host gets blocked later falls through to the next one.
+	handler.SetSettings(Settings{BaseURL: "http://short.ru", CountryHeader: "X-Country-Code", BlockedHosts: []string{"apple.com"}})
+	req = httptest.NewRequest(http.MethodGet, "/1", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone)")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, "https://example.com", w.Header().Get("Location"))
+}
+
+func TestHandler_RulesValidation(t *testing.T) {
+	models := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+	handler := NewHandler(models, "http://short.ru", nil)
+	handler.SetSettings(Settings{BaseURL: "http://short.ru", BlockedHosts: []string{"evil.ru"}})
+
+	tests := []struct {
+		name  string
+		rules string
+		err   error
+	}{
+		{name: "no url #1", rules: `[{"platform":"ios"}]`, err: ErrRuleURL},
+		{name: "no condition #2", rules: `[{"url":"https://a.ru"}]`, err: ErrRuleCondition},
+		{name: "unknown platform #3", rules: `[{"platform":"symbian","url":"https://a.ru"}]`, err: ErrRulePlatform},
+		{name: "bad language #4", rules: `[{"language":"english","url":"https://a.ru"}]`, err: ErrRuleLanguage},
+		{name: "bad country #5", rules: `[{"country":"AUT","url":"https://a.ru"}]`, err: ErrRuleCountry},
+		{name: "blocked url #6", rules: `[{"platform":"ios","url":"https://evil.ru"}]`, err: ErrBlockedHost},
+		{name: "country header not configured #7", rules: `[{"country":"AT","url":"https://a.ru"}]`, err: ErrNoCountryHeader},
+		{name: "too many rules #8", rules: "[" + strings.Repeat(`{"platform":"ios","url":"https://a.ru"},`, maxRules) + `{"platform":"ios","url":"https://a.ru"}]`, err: ErrTooManyRules},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com","rules":`+tt.rules+`}`))
+			req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.err.Error())
+
+			req = httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(`[{"correlation_id":"a","original_url":"https://example.com","rules":`+tt.rules+`}]`))
+			req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+			w = httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+	assert.Empty(t, models.Model)
+}
+
+func TestHandler_Variants(t *testing.T) {
+	models := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+	handler := NewHandler(models, "http://short.ru", nil)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	visit := func(remoteAddr string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/1", nil)
+		req.RemoteAddr = remoteAddr
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/shorten", `{"url":"https://example.com","variants":[{"url":"https://example.com/a","weight":3},{"name":"B","url":"https://example.com/b","weight":1}]}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, []string{"a", "b"}, []string{models.Model[1].Variants[0].Name, models.Model[1].Variants[1].Name})
+
+	served := map[string]int{}
+	for i := 0; i < 400; i++ {
+		w := visit(fmt.Sprintf("10.0.%d.%d:1234", i/250, i%250))
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+		served[w.Header().Get("Location")]++
+	}
+	assert.InDelta(t, 300, served["https://example.com/a"], 40)
+	assert.InDelta(t, 100, served["https://example.com/b"], 40)
+	assert.Equal(t, 400, served["https://example.com/a"]+served["https://example.com/b"])
+	assert.Equal(t, storage.ClickCounts{"a": served["https://example.com/a"], "b": served["https://example.com/b"]}, models.Model[1].VariantClicks)
+
+	// The same visitor lands on the same variant, and the cookie keeps it
+	// there from another address.
+	first := visit("192.0.2.1:1234")
+	require.Len(t, first.Result().Cookies(), 1)
+	cookie := first.Result().Cookies()[0]
+	assert.Equal(t, "variant_1", cookie.Name)
+	assert.Equal(t, "/1", cookie.Path)
+	assert.Equal(t, first.Header().Get("Location"), visit("192.0.2.1:1234").Header().Get("Location"))
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, first.Header().Get("Location"), visit(fmt.Sprintf("198.51.100.%d:1234", i), cookie).Header().Get("Location"))
+	}
+
+	// Pausing the variant moves its visitors.
+	other := map[string]string{"a": "b", "b": "a"}[cookie.Value]
+	w = do(http.MethodPatch, "/api/user/urls/1", fmt.Sprintf(`{"weights":{%q:0,%q:1}}`, cookie.Value, other))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEqual(t, first.Header().Get("Location"), visit("192.0.2.1:1234", cookie).Header().Get("Location"))
+
+	w = do(http.MethodGet, "/api/user/urls", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []storage.ShortURLs
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	require.Len(t, listed[0].Variants, 2)
+	assert.Equal(t, listed[0].Clicks, listed[0].Variants[0].Clicks+listed[0].Variants[1].Clicks)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPatch, "/api/user/urls/1", `{"weights":{"c":1}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPatch, "/api/user/urls/1", `{"weights":{"a":0,"b":0}}`).Code)
+}
+
+func TestHandler_VariantsValidation(t *testing.T) {
+	models := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+	handler := NewHandler(models, "http://short.ru", nil)
+	handler.SetSettings(Settings{BaseURL: "http://short.ru", BlockedHosts: []string{"evil.ru"}})
+
+	tests := []struct {
+		name     string
+		variants string
+		err      error
+	}{
+		{name: "single variant #1", variants: `[{"url":"https://a.ru","weight":1}]`, err: ErrVariantCount},
+		{name: "duplicate names #2", variants: `[{"name":"x","url":"https://a.ru","weight":1},{"name":"X","url":"https://b.ru","weight":1}]`, err: ErrVariantDup},
+		{name: "bad name #3", variants: `[{"name":"a b","url":"https://a.ru","weight":1},{"url":"https://b.ru","weight":1}]`, err: ErrVariantName},
+		{name: "no url #4", variants: `[{"weight":1},{"url":"https://b.ru","weight":1}]`, err: ErrVariantURL},
+		{name: "blocked url #5", variants: `[{"url":"https://evil.ru","weight":1},{"url":"https://b.ru","weight":1}]`, err: ErrBlockedHost},
+		{name: "negative weight #6", variants: `[{"url":"https://a.ru","weight":-1},{"url":"https://b.ru","weight":1}]`, err: ErrVariantWeight},
+		{name: "no weight #7", variants: `[{"url":"https://a.ru"},{"url":"https://b.ru"}]`, err: ErrNoWeight},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com","variants":`+tt.variants+`}`))
+			req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.err.Error())
+		})
+	}
+	assert.Empty(t, models.Model)
+}
+
+func TestHandler_QueryPassthrough(t *testing.T) {
+	models := &storage.Models{
+		Counter: 5,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "https://example.com/landing?utm_source=site&ref=1", Query: &storage.QueryOptions{Passthrough: storage.PassthroughAll, Fixed: []string{"admin"}}},
+			2: {ID: 2, User: "user", URL: "https://example.com/plain"},
+			3: {ID: 3, User: "user", URL: "https://example.com/utm#top", Query: &storage.QueryOptions{
+				Passthrough: storage.PassthroughUTM,
+				Template:    map[string]string{"utm_medium": "shortlink", "utm_campaign": "link-{id}", "utm_content": "{variant}"},
+			}},
+			4: {ID: 4, User: "user", URL: "https://example.com/file?token=secret&id=7"},
+		},
+	}
+	handler := NewHandler(models, "http://short.ru", nil)
+
+	tests := []struct {
+		name     string
+		path     string
+		location string
+	}{
+		{name: "merge #1", path: "/1?utm_medium=mail&x=1", location: "https://example.com/landing?ref=1&utm_medium=mail&utm_source=site&x=1"},
+		{name: "fixed parameter kept #2", path: "/1?utm_source=evil", location: "https://example.com/landing?utm_source=site&ref=1"},
+		{name: "no query #3", path: "/1", location: "https://example.com/landing?utm_source=site&ref=1"},
+		{name: "default is none #4", path: "/2?utm_source=news", location: "https://example.com/plain"},
+		{name: "template #5", path: "/3", location: "https://example.com/utm?utm_campaign=link-3&utm_medium=shortlink#top"},
+		{name: "template with passthrough #6", path: "/3?utm_source=news&utm_medium=mail&other=1", location: "https://example.com/utm?utm_campaign=link-3&utm_medium=mail&utm_source=news#top"},
+		{name: "escaped values #7", path: "/3?utm_term=a%26b%3Dc", location: "https://example.com/utm?utm_campaign=link-3&utm_medium=shortlink&utm_term=a%26b%3Dc#top"},
+		{name: "destination parameter kept #8", path: "/1?ref=2", location: "https://example.com/landing?utm_source=site&ref=1"},
+		{name: "fixed parameter not added #9", path: "/1?admin=1", location: "https://example.com/landing?utm_source=site&ref=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+		})
+	}
+
+	handler.SetSettings(Settings{BaseURL: "http://short.ru", QueryPassthrough: storage.PassthroughUTM})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/2?utm_source=news&b=1", nil))
+	assert.Equal(t, "https://example.com/plain?utm_source=news", w.Header().Get("Location"))
+
+	handler.SetSettings(Settings{BaseURL: "http://short.ru", QueryPassthrough: storage.PassthroughAll})
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/4?token=guess&id=1&page=2", nil))
+	assert.Equal(t, "https://example.com/file?id=7&page=2&token=secret", w.Header().Get("Location"))
+}
+
+func TestHandler_CreateWithQuery(t *testing.T) {
+	models := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+	handler := NewHandler(models, "http://short.ru", nil)
+
+	tests := []struct {
+		name  string
+		query string
+		code  int
+	}{
+		{name: "options #1", query: `{"passthrough":"UTM","template":{"utm_source":"short"},"fixed":["ref"]}`, code: http.StatusCreated},
+		{name: "unknown passthrough #2", query: `{"passthrough":"some"}`, code: http.StatusBadRequest},
+		{name: "unknown placeholder #3", query: `{"template":{"utm_source":"{user}"}}`, code: http.StatusBadRequest},
+		{name: "empty name #4", query: `{"fixed":[""]}`, code: http.StatusBadRequest},
+		{name: "empty options #5", query: `{}`, code: http.StatusCreated},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"url":"https://example.com/%d","query":%s}`, i, tt.query)
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+			req.AddCookie(&http.Cookie{Name: "user_id", Value: "user"})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code, w.Body.String())
+		})
+	}
+
+	assert.Equal(t, &storage.QueryOptions{Passthrough: storage.PassthroughUTM, Template: map[string]string{"utm_source": "short"}, Fixed: []string{"ref"}}, models.Model[1].Query)
+	assert.Nil(t, models.Model[2].Query)
+}
+
+func TestHandler_Webhooks(t *testing.T) {
+	models := &storage.Models{
+		Counter: 2,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "http://test1.ru"},
+		},
+	}
+	var handler *Handler
+	events := webhooks.NewNotifier(models, func(id int) string { return handler.shortURL(id) })
+	handler = NewHandler(webhooks.NewStorage(models, events), "http://short.ru", nil)
+	handler.Events = events
+	handler.SetSettings(Settings{BaseURL: "http://short.ru", BlockedHosts: []string{"evil.ru"}})
+
+	do := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "user_id", Value: user})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{name: "subscribe #1", body: `{"url":"https://hooks.example.com/in","events":["link.created","link.deleted","link.click_threshold","link.created"],"click_threshold":2}`, code: http.StatusCreated},
+		{name: "relative url #2", body: `{"url":"/in","events":["link.created"]}`, code: http.StatusBadRequest},
+		{name: "ftp url #3", body: `{"url":"ftp://hooks.example.com","events":["link.created"]}`, code: http.StatusBadRequest},
+		{name: "blocked host #4", body: `{"url":"https://evil.ru/in","events":["link.created"]}`, code: http.StatusBadRequest},
+		{name: "no events #5", body: `{"url":"https://hooks.example.com/in","events":[]}`, code: http.StatusBadRequest},
+		{name: "unknown event #6", body: `{"url":"https://hooks.example.com/in","events":["link.visited"]}`, code: http.StatusBadRequest},
+		{name: "threshold missing #7", body: `{"url":"https://hooks.example.com/in","events":["link.click_threshold"]}`, code: http.StatusBadRequest},
+		{name: "threshold without event #8", body: `{"url":"https://hooks.example.com/in","events":["link.created"],"click_threshold":5}`, code: http.StatusBadRequest},
+		{name: "bad json #9", body: `{"url":`, code: http.StatusBadRequest},
+		{name: "loopback #10", body: `{"url":"http://127.0.0.1:8080/in","events":["link.created"]}`, code: http.StatusBadRequest},
+		{name: "metadata address #11", body: `{"url":"http://169.254.169.254/latest","events":["link.created"]}`, code: http.StatusBadRequest},
+		{name: "localhost #12", body: `{"url":"http://localhost/in","events":["link.created"]}`, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, do("user", http.MethodPost, "/api/user/webhooks", tt.body).Code)
+		})
+	}
+
+	var listed []storage.Webhook
+	require.NoError(t, json.Unmarshal(do("user", http.MethodGet, "/api/user/webhooks", "").Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, 1, listed[0].ID)
+	assert.Empty(t, listed[0].Secret, "secret listed")
+	assert.Equal(t, []string{storage.EventCreated, storage.EventDeleted, storage.EventClickThreshold}, listed[0].Events)
+	assert.Len(t, models.Webhooks[0].Secret, 64)
+	assert.JSONEq(t, `[]`, do("other", http.MethodGet, "/api/user/webhooks", "").Body.String())
+
+	assert.Equal(t, http.StatusCreated, do("user", http.MethodPost, "/api/shorten", `{"url":"http://test2.ru"}`).Code)
+	assert.Equal(t, http.StatusCreated, do("other", http.MethodPost, "/api/shorten", `{"url":"http://test3.ru"}`).Code)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusTemporaryRedirect, do("visitor", http.MethodGet, "/1", "").Code)
+	}
+	assert.Equal(t, http.StatusAccepted, do("user", http.MethodDelete, "/api/user/urls", `["2"]`).Code)
+	assert.Equal(t, http.StatusAccepted, do("user", http.MethodDelete, "/api/user/urls", `["2"]`).Code)
+
+	var deliveries []storage.Delivery
+	w := do("user", http.MethodGet, "/api/user/webhooks/deliveries", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+	require.Len(t, deliveries, 3)
+	for i, event := range []string{storage.EventDeleted, storage.EventClickThreshold, storage.EventCreated} {
+		assert.Equal(t, event, deliveries[i].Event)
+		assert.Equal(t, storage.DeliveryPending, deliveries[i].Status)
+	}
+
+	var payload webhooks.Payload
+	require.NoError(t, json.Unmarshal(deliveries[1].Payload, &payload))
+	assert.Equal(t, 2, payload.Threshold)
+	assert.Equal(t, webhooks.Link{ID: 1, ShortURL: "http://short.ru/1", OriginalURL: "http://test1.ru", Clicks: 2}, payload.Link)
+	require.NoError(t, json.Unmarshal(deliveries[2].Payload, &payload))
+	assert.Equal(t, "http://short.ru/2", payload.Link.ShortURL)
+
+	queries := []struct {
+		name  string
+		query string
+		code  int
+		count int
+	}{
+		{name: "limit #1", query: "?limit=1", code: http.StatusOK, count: 1},
+		{name: "status #2", query: "?status=dead", code: http.StatusOK, count: 0},
+		{name: "webhook #3", query: "?webhook_id=2", code: http.StatusOK, count: 0},
+		{name: "bad limit #4", query: "?limit=0", code: http.StatusBadRequest},
+		{name: "bad status #5", query: "?status=lost", code: http.StatusBadRequest},
+		{name: "bad webhook #6", query: "?webhook_id=x", code: http.StatusBadRequest},
+	}
+	for _, tt := range queries {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do("user", http.MethodGet, "/api/user/webhooks/deliveries"+tt.query, "")
+			require.Equal(t, tt.code, w.Code)
+			if tt.code == http.StatusOK {
+				var deliveries []storage.Delivery
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+				assert.Len(t, deliveries, tt.count)
+			}
+		})
+	}
+
+	assert.Equal(t, http.StatusNotFound, do("user", http.MethodPost, "/api/user/webhooks/deliveries/1/retry", "").Code)
+	models.Deliveries[0].Status = storage.DeliveryDead
+	assert.Equal(t, http.StatusNotFound, do("other", http.MethodPost, "/api/user/webhooks/deliveries/1/retry", "").Code)
+	assert.Equal(t, http.StatusAccepted, do("user", http.MethodPost, "/api/user/webhooks/deliveries/1/retry", "").Code)
+	assert.Equal(t, storage.DeliveryPending, models.Deliveries[0].Status)
+
+	assert.Equal(t, http.StatusNotFound, do("other", http.MethodDelete, "/api/user/webhooks/1", "").Code)
+	assert.Equal(t, http.StatusNoContent, do("user", http.MethodDelete, "/api/user/webhooks/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("user", http.MethodDelete, "/api/user/webhooks/1", "").Code)
+	assert.Empty(t, models.Deliveries)
+
+	for i := 0; i < maxWebhooks; i++ {
+		assert.Equal(t, http.StatusCreated, do("user", http.MethodPost, "/api/user/webhooks", `{"url":"https://hooks.example.com","events":["link.expired"]}`).Code)
+	}
+	assert.Equal(t, http.StatusConflict, do("user", http.MethodPost, "/api/user/webhooks", `{"url":"https://hooks.example.com","events":["link.expired"]}`).Code)
+}
+
+func TestHandler_Collections(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	models := &storage.Models{
+		Counter: 7,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "https://docs.example.com/start"},
+			2: {ID: 2, User: "user", URL: "http://test2.ru", Deleted: true},
+			3: {ID: 3, User: "other", URL: "http://test3.ru"},
+			4: {ID: 4, User: "user", URL: "https://secret.example.com", PasswordHash: "hash"},
+			5: {ID: 5, User: "user", URL: "http://test5.ru", ExpiresAt: &past},
+			6: {ID: 6, User: "user", URL: "https://evil.ru/page"},
+		},
+	}
+	handler := NewHandler(models, "http://short.ru", nil)
+	handler.SetSettings(Settings{BaseURL: "http://short.ru", BlockedHosts: []string{"evil.ru"}})
+
+	do := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.AddCookie(&http.Cookie{Name: "user_id", Value: user})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{name: "create #1", body: `{"slug":"My-Links","title":" My links ","description":"Everything","links":[{"id":4},{"id":1,"title":"Docs"},{"id":2},{"id":5},{"id":6}]}`, code: http.StatusCreated},
+		{name: "taken slug #2", body: `{"slug":"my-links","title":"Again"}`, code: http.StatusConflict},
+		{name: "bad slug #3", body: `{"slug":"-links","title":"Links"}`, code: http.StatusBadRequest},
+		{name: "slug with slash #4", body: `{"slug":"a/b","title":"Links"}`, code: http.StatusBadRequest},
+		{name: "no title #5", body: `{"slug":"links"}`, code: http.StatusBadRequest},
+		{name: "foreign link #6", body: `{"slug":"links","title":"Links","links":[{"id":3}]}`, code: http.StatusBadRequest},
+		{name: "missing link #7", body: `{"slug":"links","title":"Links","links":[{"id":42}]}`, code: http.StatusBadRequest},
+		{name: "duplicate link #8", body: `{"slug":"links","title":"Links","links":[{"id":1},{"id":1}]}`, code: http.StatusBadRequest},
+		{name: "bad json #9", body: `{"slug":`, code: http.StatusBadRequest},
+		{name: "empty collection #10", body: `{"slug":"empty","title":"Empty"}`, code: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, do("user", http.MethodPost, "/api/user/collections", tt.body).Code)
+		})
+	}
+
+	var info storage.CollectionInfo
+	w := do("user", http.MethodGet, "/api/user/collections/1", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, "my-links", info.Slug)
+	assert.Equal(t, "My links", info.Title)
+	assert.Equal(t, "http://short.ru/c/my-links", info.PageURL)
+	require.Len(t, info.Links, 5)
+	assert.Equal(t, storage.CollectionLink{ID: 1, Title: "Docs", ShortURL: "http://short.ru/1", OriginalURL: "https://docs.example.com/start", Status: storage.StatusActive}, info.Links[1])
+	assert.Equal(t, storage.StatusDeleted, info.Links[2].Status)
+	assert.Equal(t, storage.StatusExpired, info.Links[3].Status)
+
+	assert.Equal(t, http.StatusNotFound, do("other", http.MethodGet, "/api/user/collections/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("other", http.MethodPatch, "/api/user/collections/1", `{"title":"Mine"}`).Code)
+	assert.Equal(t, http.StatusNotFound, do("other", http.MethodDelete, "/api/user/collections/1", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do("user", http.MethodGet, "/api/user/collections/x", "").Code)
+	assert.JSONEq(t, `[]`, do("other", http.MethodGet, "/api/user/collections", "").Body.String())
+
+	var listed []storage.CollectionInfo
+	require.NoError(t, json.Unmarshal(do("user", http.MethodGet, "/api/user/collections", "").Body.Bytes(), &listed))
+	require.Len(t, listed, 2)
+	assert.Equal(t, "empty", listed[1].Slug)
+	assert.Empty(t, listed[1].Links)
+
+	page := func(slug string) storage.CollectionPage {
+		req := httptest.NewRequest(http.MethodGet, "/c/"+slug, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+
+		var page storage.CollectionPage
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return page
+	}
+
+	assert.Equal(t, storage.CollectionPage{
+		Title:       "My links",
+		Description: "Everything",
+		Links: []storage.PageLink{
+			{Title: "http://short.ru/4", URL: "http://short.ru/c/my-links/4", ShortURL: "http://short.ru/4"},
+			{Title: "Docs", URL: "http://short.ru/c/my-links/1", ShortURL: "http://short.ru/1"},
+		},
+	}, page("My-Links"))
+
+	w = do("visitor", http.MethodGet, "/c/my-links", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `<a href="http://short.ru/c/my-links/1" rel="noopener noreferrer">Docs</a>`)
+	assert.NotContains(t, w.Body.String(), "secret.example.com")
+	assert.Equal(t, http.StatusNotFound, do("visitor", http.MethodGet, "/c/nothing", "").Code)
+
+	for i := 0; i < 2; i++ {
+		w = do("visitor", http.MethodGet, "/c/my-links/1", "")
+		require.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "http://short.ru/1", w.Header().Get("Location"))
+	}
+	assert.Equal(t, http.StatusFound, do("visitor", http.MethodGet, "/c/my-links/4", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("visitor", http.MethodGet, "/c/my-links/3", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("visitor", http.MethodGet, "/c/empty/1", "").Code)
+	assert.Equal(t, 0, models.Model[1].Clicks, "the short link counts its own visits")
+
+	tests = []struct {
+		name string
+		body string
+		code int
+	}{
+		{name: "reorder #1", body: `{"title":"Start here","links":[{"id":1,"title":"Docs"},{"id":4,"title":"Private"}]}`, code: http.StatusOK},
+		{name: "empty update #2", body: `{}`, code: http.StatusBadRequest},
+		{name: "taken slug #3", body: `{"slug":"empty"}`, code: http.StatusConflict},
+		{name: "empty title #4", body: `{"title":""}`, code: http.StatusBadRequest},
+		{name: "foreign link #5", body: `{"links":[{"id":3}]}`, code: http.StatusBadRequest},
+		{name: "long description #6", body: `{"description":"` + strings.Repeat("a", 1001) + `"}`, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, do("user", http.MethodPatch, "/api/user/collections/1", tt.body).Code)
+		})
+	}
+
+	w = do("user", http.MethodGet, "/api/user/collections/1", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+	assert.Equal(t, "Start here", info.Title)
+	assert.Equal(t, "my-links", info.Slug)
+	assert.Equal(t, 3, info.Clicks)
+	require.Len(t, info.Links, 2)
+	assert.Equal(t, 1, info.Links[0].ID)
+	assert.Equal(t, 2, info.Links[0].Clicks)
+	assert.Equal(t, "Private", info.Links[1].Title)
+	assert.Equal(t, 1, info.Links[1].Clicks)
+	assert.Equal(t, []string{"Docs", "Private"}, []string{page("my-links").Links[0].Title, page("my-links").Links[1].Title})
+
+	assert.Equal(t, http.StatusNoContent, do("user", http.MethodDelete, "/api/user/collections/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("user", http.MethodDelete, "/api/user/collections/1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("visitor", http.MethodGet, "/c/my-links", "").Code)
+	assert.Equal(t, http.StatusOK, do("visitor", http.MethodGet, "/c/empty", "").Code)
+	assert.Contains(t, models.Model, 1, "links outlive the collection")
+
+	// The id of the newest collection is not given to the next one.
+	assert.Equal(t, http.StatusNoContent, do("user", http.MethodDelete, "/api/user/collections/2", "").Code)
+	assert.Equal(t, http.StatusCreated, do("user", http.MethodPost, "/api/user/collections", `{"slug":"again","title":"Again"}`).Code)
+	assert.Equal(t, http.StatusNotFound, do("user", http.MethodGet, "/api/user/collections/2", "").Code)
+	assert.Equal(t, http.StatusOK, do("user", http.MethodGet, "/api/user/collections/3", "").Code)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const readinessTimeout = 2 * time.Second
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+	statusDraining    = "draining"
+	statusDisabled    = "disabled"
+)
+
+type componentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentStatus `json:"components,omitempty"`
+}
+
+func (h *Handler) SetDraining() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+func (h *Handler) Draining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
+func (h *Handler) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: statusOK})
+}
+
+func (h *Handler) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ready := true
+	components := make(map[string]componentStatus)
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	if err := h.Storage.Ping(ctx); err != nil {
+		ready = false
+		components["storage"] = componentStatus{Status: statusUnavailable, Error: err.Error()}
+	} else {
+		components["storage"] = componentStatus{Status: statusOK}
+	}
+
+	switch {
+	case h.Deleter == nil:
+		components["delete_worker"] = componentStatus{Status: statusDisabled}
+	case h.Deleter.Running():
+		components["delete_worker"] = componentStatus{Status: statusOK}
+	default:
+		ready = false
+		components["delete_worker"] = componentStatus{Status: statusUnavailable}
+	}
+
+	if h.Draining() {
+		ready = false
+		components["server"] = componentStatus{Status: statusDraining}
+	} else {
+		components["server"] = componentStatus{Status: statusOK}
+	}
+
+	if !ready {
+		writeHealth(w, http.StatusServiceUnavailable, healthResponse{Status: statusUnavailable, Components: components})
+		return
+	}
+
+	writeHealth(w, http.StatusOK, healthResponse{Status: statusOK, Components: components})
+}
+
+func writeHealth(w http.ResponseWriter, code int, response healthResponse) {
+	res, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(res)
+}
@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+)
+
+// HTTPSRedirect sends plain HTTP requests to the same host and path on the
+// HTTPS listener. The port is dropped when it is the default 443.
+func HTTPSRedirect(httpsAddress string) http.HandlerFunc {
+	_, port, _ := net.SplitHostPort(httpsAddress)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	}
+}
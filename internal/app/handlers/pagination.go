@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// parseListQuery reads the page options of GET /api/user/urls:
+// limit, cursor, sort (asc or desc by creation), status (active, deleted,
+// exhausted, expired or all), q (url substring) and from/to (RFC 3339 or
+// YYYY-MM-DD, to is exclusive).
+func parseListQuery(values url.Values, userID string) (storage.ListQuery, error) {
+	query := storage.ListQuery{
+		User:     userID,
+		Limit:    defaultPageSize,
+		Status:   storage.StatusActive,
+		Contains: values.Get("q"),
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		query.Limit = limit
+	}
+
+	if raw := values.Get("cursor"); raw != "" {
+		id, err := decodeCursor(raw)
+		if err != nil {
+			return query, err
+		}
+		query.AfterID = id
+	}
+
+	switch values.Get("sort") {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, errors.New("sort must be asc or desc")
+	}
+
+	switch status := values.Get("status"); status {
+	case "":
+	case storage.StatusActive, storage.StatusDeleted, storage.StatusExhausted, storage.StatusExpired, storage.StatusAll:
+		query.Status = status
+	default:
+		return query, fmt.Errorf("unknown status %q", status)
+	}
+
+	var err error
+	if query.CreatedFrom, err = parseTime(values.Get("from")); err != nil {
+		return query, fmt.Errorf("from: %w", err)
+	}
+	if query.CreatedTo, err = parseTime(values.Get("to")); err != nil {
+		return query, fmt.Errorf("to: %w", err)
+	}
+
+	return query, nil
+}
+
+func parseTime(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+
+	return time.Parse("2006-01-02", raw)
+}
+
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+
+	id, err := strconv.Atoi(string(raw))
+	if err != nil || id < 1 {
+		return 0, errInvalidCursor
+	}
+
+	return id, nil
+}
+
+// setNextLink points the client at the page after lastID, keeping the
+// other query parameters.
+func setNextLink(w http.ResponseWriter, r *http.Request, lastID int) {
+	values := r.URL.Query()
+	values.Set("cursor", encodeCursor(lastID))
+
+	next := url.URL{Path: r.URL.Path, RawQuery: values.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/middlewares"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordHeader    = "X-Link-Password"
+	unlockCookiePref  = "unlock_"
+	unlockTTL         = 15 * time.Minute
+	maxPasswordLength = 72 // bcrypt ignores anything longer
+
+	// Each client gets five tries per link, then one more every 12 seconds.
+	passwordAttemptBurst = 5
+	passwordAttemptRate  = 1.0 / 12
+)
+
+var (
+	ErrPasswordLength  = fmt.Errorf("password must be 1 to %d bytes long", maxPasswordLength)
+	ErrPasswordNeeded  = errors.New("link is password protected")
+	ErrPasswordInvalid = errors.New("wrong password")
+)
+
+var passwordTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+</head>
+<body>
+<form method="post">
+<p>This link is password protected.</p>
+{{if .}}<p><strong>{{.}}</strong></p>{{end}}
+<p><input type="password" name="password" autofocus required> <button type="submit">Continue</button></p>
+</form>
+</body>
+</html>
+`))
+
+func newPasswordAttempts() *middlewares.RateLimit {
+	return middlewares.NewRateLimit(passwordAttemptRate, passwordAttemptBurst)
+}
+
+// HashPassword returns the hash to store for a link password; an empty
+// password leaves the link open.
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	if len(password) > maxPasswordLength {
+		return "", ErrPasswordLength
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// unlocked lets a request through a password protected link. It accepts an
+// unlock cookie or the password header; otherwise it answers the request
+// itself and returns false.
+func (h *Handler) unlocked(w http.ResponseWriter, r *http.Request, origin storage.CreateURL) bool {
+	if origin.PasswordHash == "" || h.validUnlockCookie(r, origin) {
+		return true
+	}
+
+	password := r.Header.Get(passwordHeader)
+	if password == "" {
+		h.passwordForm(w, r, http.StatusUnauthorized, "")
+		return false
+	}
+
+	if code, err := h.checkPassword(r, origin, password); err != nil {
+		httpError(w, r, err, code)
+		return false
+	}
+
+	h.setUnlockCookie(w, r, origin)
+
+	return true
+}
+
+// UnlockHandler takes the password form and sends the browser back to the
+// short link with an unlock cookie.
+func (h *Handler) UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	origin, code, err := h.link(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err, code)
+		return
+	}
+
+	if origin.PasswordHash == "" {
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if code, err := h.checkPassword(r, origin, r.PostForm.Get("password")); err != nil {
+		if code == http.StatusTooManyRequests {
+			httpError(w, r, err, code)
+			return
+		}
+		h.passwordForm(w, r, code, err.Error())
+		return
+	}
+
+	h.setUnlockCookie(w, r, origin)
+	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+}
+
+func (h *Handler) checkPassword(r *http.Request, origin storage.CreateURL, password string) (int, error) {
+	allowed, retryAfter := h.PasswordAttempts.Allow(middlewares.ClientKey(r) + "/" + strconv.Itoa(origin.ID))
+	if !allowed {
+		return http.StatusTooManyRequests, fmt.Errorf("too many password attempts, retry in %d seconds", int(retryAfter.Seconds()+1))
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(origin.PasswordHash), []byte(password)) != nil {
+		return http.StatusUnauthorized, ErrPasswordInvalid
+	}
+
+	return http.StatusOK, nil
+}
+
+func (h *Handler) passwordForm(w http.ResponseWriter, r *http.Request, code int, message string) {
+	w.Header().Set("Cache-Control", "no-store")
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		if message == "" {
+			message = ErrPasswordNeeded.Error()
+		}
+		http.Error(w, message, code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	passwordTemplate.Execute(w, message)
+}
+
+// The unlock cookie is "<expiry>.<mac>", signed over the link id and its
+// password hash, so changing the password locks the link again.
+func (h *Handler) unlockSign(origin storage.CreateURL, expires int64) string {
+	key := h.Settings().UnlockKey
+	if len(key) == 0 {
+		key = h.unlockKey
+	}
+
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d|%d|%s", origin.ID, expires, origin.PasswordHash)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (h *Handler) setUnlockCookie(w http.ResponseWriter, r *http.Request, origin storage.CreateURL) {
+	expires := time.Now().Add(unlockTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookiePref + strconv.Itoa(origin.ID),
+		Value:    strconv.FormatInt(expires.Unix(), 10) + "." + h.unlockSign(origin, expires.Unix()),
+		Path:     "/",
+		MaxAge:   int(unlockTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (h *Handler) validUnlockCookie(r *http.Request, origin storage.CreateURL) bool {
+	cookie, err := r.Cookie(unlockCookiePref + strconv.Itoa(origin.ID))
+	if err != nil {
+		return false
+	}
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(parts[1]), []byte(h.unlockSign(origin, expires)))
+}
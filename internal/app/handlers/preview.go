@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Preview of {{.ShortURL}}</title>
+</head>
+<body>
+{{if .Protected}}<p><code>{{.ShortURL}}</code> is password protected.</p>
+{{else}}<p><code>{{.ShortURL}}</code> leads to:</p>
+<p><code>{{.OriginalURL}}</code></p>
+{{end}}
+<p>Created {{.CreatedAt.Format "2006-01-02"}}, followed {{.Clicks}} times.</p>
+<p><a href="{{.ShortURL}}" rel="noopener noreferrer">Continue</a></p>
+</body>
+</html>
+`))
+
+// PreviewHandler shows where a short link leads instead of following it.
+// The continue button goes through the short link so the visit is counted
+// like any other.
+func (h *Handler) PreviewHandler(w http.ResponseWriter, r *http.Request) {
+	origin, code, err := h.link(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err, code)
+		return
+	}
+
+	preview := storage.Preview{
+		ShortURL:    h.shortURL(origin.ID),
+		OriginalURL: origin.URL,
+		CreatedAt:   origin.CreatedAt,
+		Clicks:      origin.Clicks,
+	}
+	if origin.PasswordHash != "" && !h.validUnlockCookie(r, origin) {
+		preview.OriginalURL = ""
+		preview.Protected = true
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, r, http.StatusOK, preview)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	previewTemplate.Execute(w, preview)
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Fedorova199/red-cat/internal/app/qrcode"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultQRSize   = 256
+	minQRSize       = 64
+	maxQRSize       = 2048
+	defaultQRMargin = 4
+	maxQRMargin     = 32
+
+	qrFormatPNG = "png"
+	qrFormatSVG = "svg"
+)
+
+type qrOptions struct {
+	format string
+	size   int
+	level  qrcode.Level
+	margin int
+}
+
+// QRHandler answers with a QR code of the short link. The image only
+// depends on the short URL and the options, so it is cached for good.
+func (h *Handler) QRHandler(w http.ResponseWriter, r *http.Request) {
+	origin, code, err := h.link(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err, code)
+		return
+	}
+
+	opts, err := parseQROptions(r)
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	shortURL := h.shortURL(origin.ID)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s|%d", shortURL, opts.format, opts.size, opts.level, opts.margin)))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept")
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	qr, err := qrcode.Encode([]byte(shortURL), opts.level)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if opts.format == qrFormatSVG {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.WriteHeader(http.StatusOK)
+		qr.WriteSVG(w, opts.size, opts.margin)
+		return
+	}
+
+	data, err := qr.PNG(opts.size, opts.margin)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func parseQROptions(r *http.Request) (qrOptions, error) {
+	values := r.URL.Query()
+	opts := qrOptions{
+		format: values.Get("format"),
+		size:   defaultQRSize,
+		level:  qrcode.Medium,
+		margin: defaultQRMargin,
+	}
+
+	switch opts.format {
+	case qrFormatPNG, qrFormatSVG:
+	case "":
+		opts.format = qrFormatPNG
+		if strings.Contains(r.Header.Get("Accept"), "image/svg+xml") {
+			opts.format = qrFormatSVG
+		}
+	default:
+		return opts, fmt.Errorf("unknown format %q, want png or svg", opts.format)
+	}
+
+	var err error
+	if opts.size, err = intParam(values, "size", defaultQRSize, minQRSize, maxQRSize); err != nil {
+		return opts, err
+	}
+	if opts.margin, err = intParam(values, "margin", defaultQRMargin, 0, maxQRMargin); err != nil {
+		return opts, err
+	}
+	if ec := values.Get("ec"); ec != "" {
+		if opts.level, err = qrcode.ParseLevel(ec); err != nil {
+			return opts, errors.New("ec must be one of L, M, Q or H")
+		}
+	}
+
+	return opts, nil
+}
+
+func intParam(values url.Values, name string, def, min, max int) (int, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return def, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("%s must be a number between %d and %d", name, min, max)
+	}
+
+	return value, nil
+}
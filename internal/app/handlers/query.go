@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+)
+
+const (
+	maxQueryParams     = 20
+	maxQueryNameLength = 64
+	maxQueryValue      = 256
+)
+
+var (
+	ErrUnknownPassthrough = errors.New("query passthrough must be none, utm or all")
+	ErrQueryParams        = fmt.Errorf("query template and fixed list take at most %d parameters each", maxQueryParams)
+	ErrQueryName          = fmt.Errorf("query parameter names must be 1 to %d bytes long", maxQueryNameLength)
+	ErrQueryValue         = fmt.Errorf("query template values must be at most %d bytes long", maxQueryValue)
+	ErrQueryPlaceholder   = errors.New("query template placeholders are {id}, {variant}, {platform}, {language} and {country}")
+)
+
+var placeholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+var placeholders = map[string]bool{
+	"{id}":       true,
+	"{variant}":  true,
+	"{platform}": true,
+	"{language}": true,
+	"{country}":  true,
+}
+
+// CheckQuery validates the query options of a link. Options that change
+// nothing are dropped.
+func (s Settings) CheckQuery(options *storage.QueryOptions) (*storage.QueryOptions, error) {
+	if options == nil {
+		return nil, nil
+	}
+
+	checked := storage.QueryOptions{Passthrough: strings.ToLower(strings.TrimSpace(options.Passthrough))}
+	if checked.Passthrough != "" && !storage.ValidPassthrough(checked.Passthrough) {
+		return nil, ErrUnknownPassthrough
+	}
+	if len(options.Template) > maxQueryParams || len(options.Fixed) > maxQueryParams {
+		return nil, ErrQueryParams
+	}
+
+	for name, value := range options.Template {
+		if name == "" || len(name) > maxQueryNameLength {
+			return nil, ErrQueryName
+		}
+		if len(value) > maxQueryValue {
+			return nil, ErrQueryValue
+		}
+		for _, placeholder := range placeholderPattern.FindAllString(value, -1) {
+			if !placeholders[placeholder] {
+				return nil, fmt.Errorf("%w: %s", ErrQueryPlaceholder, placeholder)
+			}
+		}
+		if checked.Template == nil {
+			checked.Template = make(map[string]string, len(options.Template))
+		}
+		checked.Template[name] = value
+	}
+
+	for _, name := range options.Fixed {
+		if name == "" || len(name) > maxQueryNameLength {
+			return nil, ErrQueryName
+		}
+		checked.Fixed = append(checked.Fixed, name)
+	}
+
+	if checked.Passthrough == "" && checked.Template == nil && checked.Fixed == nil {
+		return nil, nil
+	}
+
+	return &checked, nil
+}
+
+// withQuery builds the destination url for this request: template
+// parameters the destination lacks are added, then the query of the short
+// url is copied in as the link or the server default allows. Parameters
+// already in the destination and those the owner fixed are never taken
+// from the request; template values can be overridden.
+func (h *Handler) withQuery(r *http.Request, origin storage.CreateURL, destination, variant string) string {
+	var options storage.QueryOptions
+	if origin.Query != nil {
+		options = *origin.Query
+	}
+	mode := options.Passthrough
+	if mode == "" {
+		mode = h.Settings().QueryPassthrough
+	}
+
+	incoming := r.URL.Query()
+	if len(options.Template) == 0 && (len(incoming) == 0 || mode == "" || mode == storage.PassthroughNone) {
+		return destination
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+	query := u.Query()
+	own := u.Query()
+	changed := false
+
+	if len(options.Template) > 0 {
+		visitor := h.visitor(r)
+		replacer := strings.NewReplacer(
+			"{id}", strconv.Itoa(origin.ID),
+			"{variant}", variant,
+			"{platform}", visitor.Platform,
+			"{language}", visitor.Language,
+			"{country}", visitor.Country,
+		)
+		for name, value := range options.Template {
+			if _, ok := query[name]; ok {
+				continue
+			}
+			if value = replacer.Replace(value); value != "" {
+				query.Set(name, value)
+				changed = true
+			}
+		}
+	}
+
+	fixed := make(map[string]bool, len(own)+len(options.Fixed))
+	for name := range own {
+		fixed[name] = true
+	}
+	for _, name := range options.Fixed {
+		fixed[name] = true
+	}
+	for name, values := range incoming {
+		if fixed[name] || !passes(mode, name) {
+			continue
+		}
+		query[name] = values
+		changed = true
+	}
+
+	if !changed {
+		return destination
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+func passes(mode, name string) bool {
+	switch mode {
+	case storage.PassthroughAll:
+		return true
+	case storage.PassthroughUTM:
+		return strings.HasPrefix(name, "utm_")
+	}
+
+	return false
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+)
+
+var ErrUnknownRedirect = errors.New("unknown redirect kind, want 301, 302, 307, 308 or interstitial")
+
+var interstitialTemplate = template.Must(template.New("interstitial").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Leaving for {{.Host}}</title>
+</head>
+<body>
+<p>This link leads to <strong>{{.Host}}</strong>:</p>
+<p><code>{{.URL}}</code></p>
+<p><a href="{{.URL}}" rel="noopener noreferrer">Continue</a></p>
+</body>
+</html>
+`))
+
+// redirectKind validates the redirect a link is created with; an empty kind
+// is kept so the link follows the server default.
+func redirectKind(kind string) (string, error) {
+	if kind != "" && !storage.ValidRedirect(kind) {
+		return "", ErrUnknownRedirect
+	}
+
+	return kind, nil
+}
+
+// redirect sends the client to origin the way the link asks for, falling
+// back to the server default and then to 307.
+func (h *Handler) redirect(w http.ResponseWriter, r *http.Request, origin storage.CreateURL) {
+	kind := origin.Redirect
+	if kind == "" {
+		kind = h.Settings().Redirect
+	}
+
+	if kind == storage.RedirectInterstitial {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		interstitialTemplate.Execute(w, struct{ Host, URL string }{destinationHost(origin.URL), origin.URL})
+		return
+	}
+
+	code, err := strconv.Atoi(kind)
+	if err != nil {
+		code = http.StatusTemporaryRedirect
+	}
+
+	// A nil Content-Type keeps http.Redirect from adding an HTML body.
+	w.Header()["Content-Type"] = nil
+	http.Redirect(w, r, origin.URL, code)
+}
@@ -1,36 +1,111 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"net"
 	"net/http"
+	"sync/atomic"
+	"time"
 
+	"github.com/Fedorova199/red-cat/internal/app/metrics"
+	"github.com/Fedorova199/red-cat/internal/app/middlewares"
+	"github.com/Fedorova199/red-cat/internal/app/webhooks"
+	"github.com/Fedorova199/red-cat/internal/app/workers"
 	"github.com/Fedorova199/red-cat/internal/interfaces"
 	"github.com/go-chi/chi/v5"
 )
 
+// Settings holds the handler options that can be replaced while the
+// server is running.
+type Settings struct {
+	BaseURL          string
+	MaxBatchSize     int
+	BlockedHosts     []string
+	TrustedSubnet    *net.IPNet
+	AdminToken       string
+	RestoreWindow    time.Duration
+	Redirect         string
+	UnlockKey        []byte
+	CountryHeader    string
+	QueryPassthrough string
+}
+
 type Handler struct {
 	*chi.Mux
-	Storage interfaces.Storage
-	BaseURL string
+	Storage          interfaces.Storage
+	Deleter          *workers.Deleter
+	Events           *webhooks.Notifier
+	Redirects        *metrics.CounterVec
+	PasswordAttempts *middlewares.RateLimit
+	settings         atomic.Value
+	draining         int32
+	// unlockKey signs unlock cookies until a key comes with the settings.
+	unlockKey []byte
 }
 
 func NewHandler(storage interfaces.Storage, baseURL string, middlewares []interfaces.Middleware) *Handler {
+	unlockKey := make([]byte, 32)
+	rand.Read(unlockKey)
+
 	router := &Handler{
-		Mux:     chi.NewMux(),
-		Storage: storage,
-		BaseURL: baseURL,
+		Mux:              chi.NewMux(),
+		Storage:          storage,
+		PasswordAttempts: newPasswordAttempts(),
+		unlockKey:        unlockKey,
 	}
+	router.SetSettings(Settings{BaseURL: baseURL})
 
 	router.Get("/ping", Middlewares(router.PingHandler, middlewares))
+	router.Get("/healthz", router.HealthzHandler)
+	router.Get("/readyz", router.ReadyzHandler)
 	router.Get("/{id}", Middlewares(router.GetHandler, middlewares))
+	router.Post("/{id}", Middlewares(router.UnlockHandler, middlewares))
+	router.Get("/{id}+", Middlewares(router.PreviewHandler, middlewares))
+	router.Get("/{id}/preview", Middlewares(router.PreviewHandler, middlewares))
+	router.Get("/{id}/qr", Middlewares(router.QRHandler, middlewares))
 	router.Get("/api/user/urls", Middlewares(router.GetUrlsHandler, middlewares))
 	router.Post("/", Middlewares(router.PostHandler, middlewares))
 	router.Post("/api/shorten", Middlewares(router.JSONHandler, middlewares))
 	router.Post("/api/shorten/batch", Middlewares(router.PostAPIShortenBatchHandler, middlewares))
 	router.Delete("/api/user/urls", Middlewares(router.DeleteUrlsHandler, middlewares))
+	router.Post("/api/user/urls/restore", Middlewares(router.RestoreUrlsHandler, middlewares))
+	router.Get("/api/user/urls/export", Middlewares(router.ExportUrlsHandler, middlewares))
+	router.Post("/api/user/urls/import", Middlewares(router.ImportUrlsHandler, middlewares))
+	router.Patch("/api/user/urls/{id}", Middlewares(router.PatchURLHandler, middlewares))
+	router.Get("/api/user/urls/{id}/history", Middlewares(router.URLHistoryHandler, middlewares))
+	router.Post("/api/user/webhooks", Middlewares(router.CreateWebhookHandler, middlewares))
+	router.Get("/api/user/webhooks", Middlewares(router.GetWebhooksHandler, middlewares))
+	router.Delete("/api/user/webhooks/{id}", Middlewares(router.DeleteWebhookHandler, middlewares))
+	router.Get("/api/user/webhooks/deliveries", Middlewares(router.DeliveriesHandler, middlewares))
+	router.Post("/api/user/webhooks/deliveries/{id}/retry", Middlewares(router.RetryDeliveryHandler, middlewares))
+	router.Post("/api/user/collections", Middlewares(router.CreateCollectionHandler, middlewares))
+	router.Get("/api/user/collections", Middlewares(router.GetCollectionsHandler, middlewares))
+	router.Get("/api/user/collections/{id}", Middlewares(router.GetCollectionHandler, middlewares))
+	router.Patch("/api/user/collections/{id}", Middlewares(router.PatchCollectionHandler, middlewares))
+	router.Delete("/api/user/collections/{id}", Middlewares(router.DeleteCollectionHandler, middlewares))
+	router.Get("/c/{slug}", Middlewares(router.CollectionPageHandler, middlewares))
+	router.Get("/c/{slug}/{id}", Middlewares(router.CollectionClickHandler, middlewares))
+	router.Get("/api/internal/stats", Middlewares(router.StatsHandler, middlewares))
+
+	router.Route("/api/admin", func(r chi.Router) {
+		r.Get("/urls", Middlewares(router.adminOnly(router.AdminFindURLHandler), middlewares))
+		r.Get("/urls/{id}", Middlewares(router.adminOnly(router.AdminGetURLHandler), middlewares))
+		r.Delete("/urls/{id}", Middlewares(router.adminOnly(router.AdminDeleteURLHandler), middlewares))
+		r.Post("/urls/{id}/restore", Middlewares(router.adminOnly(router.AdminRestoreURLHandler), middlewares))
+		r.Get("/users/{userID}/urls", Middlewares(router.adminOnly(router.AdminUserURLsHandler), middlewares))
+	})
 
 	return router
 }
 
+func (h *Handler) Settings() Settings {
+	return h.settings.Load().(Settings)
+}
+
+func (h *Handler) SetSettings(settings Settings) {
+	h.settings.Store(settings)
+}
+
 func Middlewares(handler http.HandlerFunc, middlewares []interfaces.Middleware) http.HandlerFunc {
 	for _, middleware := range middlewares {
 		handler = middleware.Handle(handler)
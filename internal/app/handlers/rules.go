@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+)
+
+const maxRules = 20
+
+var (
+	ErrTooManyRules    = fmt.Errorf("a link takes at most %d rules", maxRules)
+	ErrRuleURL         = errors.New("rule url must not be empty")
+	ErrRuleCondition   = errors.New("rule needs a platform, language or country")
+	ErrRulePlatform    = errors.New("platform must be ios, android, windows, macos or linux")
+	ErrRuleLanguage    = errors.New("language must be a language tag such as en or pt-BR")
+	ErrRuleCountry     = errors.New("country must be a two letter country code")
+	ErrNoCountryHeader = errors.New("country rules need a country header in the server config")
+)
+
+var (
+	languageTagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+	countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+	platformUserAgentOS = []struct {
+		platform string
+		markers  []string
+	}{
+		// iOS and Android user agents also name macOS and Linux, so they
+		// are looked for first.
+		{storage.PlatformIOS, []string{"iPhone", "iPad", "iPod"}},
+		{storage.PlatformAndroid, []string{"Android"}},
+		{storage.PlatformWindows, []string{"Windows"}},
+		{storage.PlatformMacOS, []string{"Macintosh", "Mac OS X"}},
+		{storage.PlatformLinux, []string{"Linux", "X11"}},
+	}
+)
+
+// CheckRules validates routing rules and returns them normalized: platforms
+// and languages in lower case, countries in upper case.
+func (s Settings) CheckRules(rules storage.Rules) (storage.Rules, error) {
+	if len(rules) > maxRules {
+		return nil, ErrTooManyRules
+	}
+
+	checked := make(storage.Rules, 0, len(rules))
+	for i, rule := range rules {
+		rule.URL = strings.TrimSpace(rule.URL)
+		rule.Platform = strings.ToLower(strings.TrimSpace(rule.Platform))
+		rule.Language = strings.ToLower(strings.TrimSpace(rule.Language))
+		rule.Country = strings.ToUpper(strings.TrimSpace(rule.Country))
+
+		var err error
+		switch {
+		case rule.URL == "":
+			err = ErrRuleURL
+		case s.Blocked(rule.URL):
+			err = ErrBlockedHost
+		case rule.Platform == "" && rule.Language == "" && rule.Country == "":
+			err = ErrRuleCondition
+		case rule.Platform != "" && !validPlatform(rule.Platform):
+			err = ErrRulePlatform
+		case rule.Language != "" && !languageTagPattern.MatchString(rule.Language):
+			err = ErrRuleLanguage
+		case rule.Country != "" && !countryCodePattern.MatchString(rule.Country):
+			err = ErrRuleCountry
+		case rule.Country != "" && s.CountryHeader == "":
+			err = ErrNoCountryHeader
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+
+		checked = append(checked, rule)
+	}
+	if len(checked) == 0 {
+		return nil, nil
+	}
+
+	return checked, nil
+}
+
+func validPlatform(platform string) bool {
+	for _, os := range platformUserAgentOS {
+		if os.platform == platform {
+			return true
+		}
+	}
+
+	return false
+}
+
+// route sends the visitor to the url of the first matching rule and
+// reports whether one matched. Rules pointing at a host that has been
+// blocked since are skipped.
+func (h *Handler) route(w http.ResponseWriter, r *http.Request, origin storage.CreateURL) (storage.CreateURL, bool) {
+	if len(origin.Rules) == 0 {
+		return origin, false
+	}
+
+	settings := h.Settings()
+	visitor := h.visitor(r)
+
+	// Caches must not hand one visitor's redirect to another.
+	vary := map[string]bool{}
+	for _, rule := range origin.Rules {
+		if rule.Platform != "" {
+			vary["User-Agent"] = true
+		}
+		if rule.Language != "" {
+			vary["Accept-Language"] = true
+		}
+		if rule.Country != "" && settings.CountryHeader != "" {
+			vary[settings.CountryHeader] = true
+		}
+	}
+	for _, header := range []string{"User-Agent", "Accept-Language", settings.CountryHeader} {
+		if vary[header] {
+			w.Header().Add("Vary", header)
+		}
+	}
+
+	for _, rule := range origin.Rules {
+		if ruleMatches(rule, visitor) && !settings.Blocked(rule.URL) {
+			origin.URL = rule.URL
+			return origin, true
+		}
+	}
+
+	return origin, false
+}
+
+// visitor describes the client in the terms rules match on.
+func (h *Handler) visitor(r *http.Request) storage.Rule {
+	visitor := storage.Rule{
+		Platform: userAgentPlatform(r.UserAgent()),
+		Language: preferredLanguage(r.Header.Get("Accept-Language")),
+	}
+	if header := h.Settings().CountryHeader; header != "" {
+		visitor.Country = strings.ToUpper(strings.TrimSpace(r.Header.Get(header)))
+	}
+
+	return visitor
+}
+
+func ruleMatches(rule, visitor storage.Rule) bool {
+	if rule.Platform != "" && rule.Platform != visitor.Platform {
+		return false
+	}
+	if rule.Language != "" && rule.Language != visitor.Language && !strings.HasPrefix(visitor.Language, rule.Language+"-") {
+		return false
+	}
+	if rule.Country != "" && rule.Country != visitor.Country {
+		return false
+	}
+
+	return true
+}
+
+func userAgentPlatform(userAgent string) string {
+	for _, os := range platformUserAgentOS {
+		for _, marker := range os.markers {
+			if strings.Contains(userAgent, marker) {
+				return os.platform
+			}
+		}
+	}
+
+	return ""
+}
+
+// preferredLanguage returns the language with the highest weight in an
+// Accept-Language header, lower cased. Wildcards are ignored.
+func preferredLanguage(header string) string {
+	var best string
+	bestQ := 0.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+)
+
+// StatsHandler reports service totals to clients inside the trusted subnet.
+// The client address is taken from X-Real-IP, which the proxy in front of
+// the service is expected to set.
+func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.Settings().Trusted(r.Header.Get("X-Real-IP")) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	urls, err := h.Storage.CountURLs(r.Context())
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	users, err := h.Storage.CountUsers(r.Context())
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(storage.Stats{URLs: urls, Users: users})
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func (s Settings) Trusted(rawIP string) bool {
+	if s.TrustedSubnet == nil {
+		return false
+	}
+
+	ip := net.ParseIP(rawIP)
+	return ip != nil && s.TrustedSubnet.Contains(ip)
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+)
+
+const (
+	formatCSV   = "csv"
+	formatJSONL = "jsonl"
+
+	exportPageSize  = 1000
+	importChunkSize = 1000
+)
+
+var (
+	csvHeader = []string{"short_url", "original_url", "created_at", "status", "expires_at"}
+
+	errUnknownFormat = errors.New("format must be csv or jsonl")
+)
+
+// transferFormat picks csv or jsonl from the format query parameter and
+// falls back to the given media type. JSON lines is the default.
+func transferFormat(r *http.Request, mediaType string) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case formatCSV, formatJSONL:
+		return format, nil
+	case "":
+	default:
+		return "", errUnknownFormat
+	}
+
+	mediaType, _, _ = mime.ParseMediaType(mediaType)
+	if mediaType == "text/csv" {
+		return formatCSV, nil
+	}
+
+	return formatJSONL, nil
+}
+
+// ExportUrlsHandler streams the caller's links page by page. It accepts the
+// same filters as GET /api/user/urls.
+func (h *Handler) ExportUrlsHandler(w http.ResponseWriter, r *http.Request) {
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	format, err := transferFormat(r, r.Header.Get("Accept"))
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	query, err := parseListQuery(r.URL.Query(), idCookie.Value)
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	query.Limit = exportPageSize
+
+	// Nothing is written before the first page is in, so a failure there
+	// can still be answered with an error status.
+	var begin func() error
+	var write func(storage.ShortURLs) error
+	var flush func()
+	if format == formatCSV {
+		cw := csv.NewWriter(w)
+		begin = func() error {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			return cw.Write(csvHeader)
+		}
+		write = func(item storage.ShortURLs) error {
+			var expiresAt string
+			if item.ExpiresAt != nil {
+				expiresAt = item.ExpiresAt.Format(time.RFC3339)
+			}
+			return cw.Write([]string{item.ShortURL, item.OriginalURL, item.CreatedAt.Format(time.RFC3339), item.Status, expiresAt})
+		}
+		flush = cw.Flush
+	} else {
+		enc := json.NewEncoder(w)
+		begin = func() error {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			return nil
+		}
+		write = func(item storage.ShortURLs) error { return enc.Encode(item) }
+		flush = func() {}
+	}
+
+	flusher, _ := w.(http.Flusher)
+	started := false
+	for {
+		page, err := h.Storage.ListUserURLs(r.Context(), query)
+		if err != nil && !started {
+			httpError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			// The status line is already out; a cut-off body is all the
+			// client can be told.
+			logger.FromContext(r.Context()).Error("export urls", "error", err)
+			return
+		}
+
+		if !started {
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="urls.%s"`, format))
+			if err := begin(); err != nil {
+				return
+			}
+			started = true
+		}
+
+		for _, createURL := range page {
+			err := write(storage.ShortURLs{
+				ShortURL:    h.shortURL(createURL.ID),
+				OriginalURL: createURL.URL,
+				CreatedAt:   createURL.CreatedAt,
+				Status:      createURL.Status(),
+				ExpiresAt:   createURL.ExpiresAt,
+			})
+			if err != nil {
+				return
+			}
+		}
+		flush()
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(page) < query.Limit {
+			return
+		}
+		query.AfterID = page[len(page)-1].ID
+	}
+}
+
+// ImportUrlsHandler creates links from an uploaded CSV or JSON lines file
+// and reports the outcome of every row. Rows are validated first, links
+// that already exist are reported with their short url, and the rest go
+// through PutBatch in chunks.
+func (h *Handler) ImportUrlsHandler(w http.ResponseWriter, r *http.Request) {
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	format, err := transferFormat(r, r.Header.Get("Content-Type"))
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	var rows []storage.ImportRow
+	if format == formatCSV {
+		rows, err = readCSVRows(r.Body)
+	} else {
+		rows, err = readJSONLRows(r.Body)
+	}
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	settings := h.Settings()
+	results := make([]storage.ImportRowResult, len(rows))
+	pending := make([]int, 0, len(rows))
+	seen := make(map[string]int, len(rows))
+	for i, row := range rows {
+		result := &results[i]
+		result.Row = row.Line
+
+		switch {
+		case row.Error != "":
+			result.Error = row.Error
+			continue
+		case row.OriginalURL == "":
+			result.Error = "original_url is required"
+			continue
+		case settings.Blocked(row.OriginalURL):
+			result.Error = ErrBlockedHost.Error()
+			continue
+		}
+		if first, ok := seen[row.OriginalURL]; ok {
+			result.Error = fmt.Sprintf("duplicate of row %d", rows[first].Line)
+			continue
+		}
+		seen[row.OriginalURL] = i
+
+		if row.Alias != "" {
+			result.Warnings = append(result.Warnings, "alias is not supported and was ignored")
+		}
+		if row.ExpiresAt != "" {
+			expiry, err := parseExpiry(row.ExpiresAt)
+			if err != nil {
+				result.Error = err.Error()
+				continue
+			}
+			rows[i].Expiry = &expiry
+		}
+
+		if existing, err := h.Storage.GetOriginURL(r.Context(), row.OriginalURL); err == nil {
+			result.ShortURL = h.shortURL(existing.ID)
+			result.Existed = true
+			if row.ExpiresAt != "" {
+				result.Warnings = append(result.Warnings, "expires_at was not applied to the existing link")
+			}
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	for start := 0; start < len(pending); start += importChunkSize {
+		end := start + importChunkSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		h.importChunk(r, idCookie.Value, rows, results, pending[start:end])
+	}
+
+	summary := storage.ImportResult{Rows: results}
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			summary.Failed++
+		case result.Existed:
+			summary.Existing++
+		default:
+			summary.Imported++
+		}
+	}
+
+	writeJSON(w, r, http.StatusOK, summary)
+}
+
+// importChunk stores a chunk with one PutBatch call. When the batch fails,
+// for example because another request created one of the links meanwhile,
+// the rows are retried one by one so the error lands on the right row.
+func (h *Handler) importChunk(r *http.Request, userID string, rows []storage.ImportRow, results []storage.ImportRowResult, chunk []int) {
+	batch := make([]storage.ShortenBatch, 0, len(chunk))
+	for _, i := range chunk {
+		batch = append(batch, storage.ShortenBatch{User: userID, URL: rows[i].OriginalURL, ExpiresAt: rows[i].Expiry})
+	}
+
+	stored, err := h.Storage.PutBatch(r.Context(), batch)
+	if err == nil {
+		for n, i := range chunk {
+			results[i].ShortURL = h.shortURL(stored[n].ID)
+		}
+		return
+	}
+
+	for _, i := range chunk {
+		id, err := h.Storage.Set(r.Context(), storage.CreateURL{User: userID, URL: rows[i].OriginalURL, ExpiresAt: rows[i].Expiry})
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].ShortURL = h.shortURL(id)
+	}
+}
+
+// parseExpiry reads an RFC 3339 time or a plain date, which expires at
+// midnight UTC. Expiries that already passed are refused.
+func parseExpiry(value string) (time.Time, error) {
+	expiry, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if expiry, err = time.Parse("2006-01-02", value); err != nil {
+			return time.Time{}, errors.New("expires_at must be an RFC 3339 time or a date")
+		}
+	}
+	if !expiry.After(time.Now()) {
+		return time.Time{}, ErrPastExpiry
+	}
+
+	return expiry.UTC(), nil
+}
+
+func readCSVRows(body io.Reader) ([]storage.ImportRow, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["original_url"]; !ok {
+		return nil, errors.New("csv header must contain original_url")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []storage.ImportRow
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+
+		row := storage.ImportRow{Line: line}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			row.Error = parseErr.Err.Error()
+		} else {
+			row.OriginalURL = field(record, "original_url")
+			row.Alias = field(record, "alias")
+			row.ExpiresAt = field(record, "expires_at")
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readJSONLRows(body io.Reader) ([]storage.ImportRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	var rows []storage.ImportRow
+	for line := 1; scanner.Scan(); line++ {
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+
+		row := storage.ImportRow{Line: line}
+		if err := json.Unmarshal([]byte(data), &row); err != nil {
+			row.Error = err.Error()
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, scanner.Err()
+}
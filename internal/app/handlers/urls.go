@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBlockedHost = errors.New("destination host is blocked")
+	ErrMaxClicks   = errors.New("max_clicks must be a number not below 0")
+)
+
+func (h *Handler) shortURL(id int) string {
+	return h.Settings().ShortURL(id)
+}
+
+func (h *Handler) blocked(rawURL string) bool {
+	return h.Settings().Blocked(rawURL)
+}
+
+func (s Settings) ShortURL(id int) string {
+	return s.BaseURL + "/" + strconv.Itoa(id)
+}
+
+// Blocked reports whether the destination host or one of its parent
+// domains is on the blocklist.
+func (s Settings) Blocked(rawURL string) bool {
+	if len(s.BlockedHosts) == 0 {
+		return false
+	}
+
+	host := destinationHost(rawURL)
+	for _, blockedHost := range s.BlockedHosts {
+		if host == blockedHost || strings.HasSuffix(host, "."+blockedHost) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func destinationHost(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
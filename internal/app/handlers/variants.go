@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/middlewares"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+)
+
+const (
+	maxVariants       = 10
+	maxVariantWeight  = 1000
+	variantCookiePref = "variant_"
+	variantTTL        = 30 * 24 * time.Hour
+)
+
+var (
+	ErrVariantCount   = fmt.Errorf("a split link takes 2 to %d variants", maxVariants)
+	ErrVariantURL     = errors.New("variant url must not be empty")
+	ErrVariantName    = errors.New("variant name must be 1 to 32 of a-z, 0-9, - and _")
+	ErrVariantDup     = errors.New("variant names must be unique")
+	ErrVariantWeight  = fmt.Errorf("variant weight must be 0 to %d", maxVariantWeight)
+	ErrNoWeight       = errors.New("at least one variant needs a weight above 0")
+	ErrNoVariants     = errors.New("link has no variants")
+	ErrUnknownVariant = errors.New("unknown variant")
+)
+
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// CheckVariants validates the destinations of a split link. Variants
+// without a name are named a, b, c and so on by position.
+func (s Settings) CheckVariants(variants storage.Variants) (storage.Variants, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) < 2 || len(variants) > maxVariants {
+		return nil, ErrVariantCount
+	}
+
+	checked := make(storage.Variants, 0, len(variants))
+	names := make(map[string]bool, len(variants))
+	for i, variant := range variants {
+		variant.Name = strings.ToLower(strings.TrimSpace(variant.Name))
+		if variant.Name == "" {
+			variant.Name = string(rune('a' + i))
+		}
+		variant.URL = strings.TrimSpace(variant.URL)
+		variant.Clicks = 0
+
+		var err error
+		switch {
+		case !variantNamePattern.MatchString(variant.Name):
+			err = ErrVariantName
+		case names[variant.Name]:
+			err = ErrVariantDup
+		case variant.URL == "":
+			err = ErrVariantURL
+		case s.Blocked(variant.URL):
+			err = ErrBlockedHost
+		}
+		if err != nil {
+			return nil, fmt.Errorf("variant %d: %w", i+1, err)
+		}
+
+		names[variant.Name] = true
+		checked = append(checked, variant)
+	}
+
+	return checked, checkWeights(checked)
+}
+
+func checkWeights(variants storage.Variants) error {
+	total := 0
+	for _, variant := range variants {
+		if variant.Weight < 0 || variant.Weight > maxVariantWeight {
+			return fmt.Errorf("variant %s: %w", variant.Name, ErrVariantWeight)
+		}
+		total += variant.Weight
+	}
+	if total == 0 {
+		return ErrNoWeight
+	}
+
+	return nil
+}
+
+// reweight returns the variants with the weights given by name.
+func reweight(variants storage.Variants, weights map[string]int) (storage.Variants, error) {
+	if len(variants) == 0 {
+		return nil, ErrNoVariants
+	}
+
+	updated := make(storage.Variants, len(variants))
+	copy(updated, variants)
+	for name, weight := range weights {
+		found := false
+		for i := range updated {
+			if updated[i].Name == name {
+				updated[i].Weight = weight
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w %q", ErrUnknownVariant, name)
+		}
+	}
+
+	return updated, checkWeights(updated)
+}
+
+// pickVariant chooses the variant for this visitor. A visitor keeps the
+// variant named in their cookie while it is running; others are placed by
+// a hash of their address and user agent and get the cookie.
+func (h *Handler) pickVariant(w http.ResponseWriter, r *http.Request, origin storage.CreateURL) (storage.Variant, bool) {
+	settings := h.Settings()
+	running := make(storage.Variants, 0, len(origin.Variants))
+	total := 0
+	for _, variant := range origin.Variants {
+		if variant.Weight > 0 && !settings.Blocked(variant.URL) {
+			running = append(running, variant)
+			total += variant.Weight
+		}
+	}
+	if total == 0 {
+		return storage.Variant{}, false
+	}
+
+	// The answer depends on the visitor, so it must not be cached.
+	w.Header().Set("Cache-Control", "no-store")
+
+	cookieName := variantCookiePref + strconv.Itoa(origin.ID)
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		for _, variant := range running {
+			if variant.Name == cookie.Value {
+				return variant, true
+			}
+		}
+	}
+
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d|%s|%s", origin.ID, middlewares.ClientKey(r), r.UserAgent())
+	n := int(hash.Sum64() % uint64(total))
+
+	chosen := running[len(running)-1]
+	for _, variant := range running {
+		if n < variant.Weight {
+			chosen = variant
+			break
+		}
+		n -= variant.Weight
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    chosen.Name,
+		Path:     "/" + strconv.Itoa(origin.ID),
+		MaxAge:   int(variantTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return chosen, true
+}
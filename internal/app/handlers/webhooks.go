@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/app/webhooks"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxWebhooks          = 10
+	maxWebhookURL        = 2048
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+var (
+	ErrWebhookURL       = errors.New("webhook url must be an absolute http or https url")
+	ErrWebhookEvents    = errors.New("events must list link.created, link.deleted, link.expired or link.click_threshold")
+	ErrClickThreshold   = errors.New("click_threshold must be above 0 with link.click_threshold and is only allowed with it")
+	ErrTooManyWebhooks  = fmt.Errorf("a user can have at most %d webhooks", maxWebhooks)
+	ErrDeliveryNotFound = errors.New("no dead delivery with that id")
+)
+
+// CheckWebhook validates a subscription. Events are deduplicated.
+func (s Settings) CheckWebhook(request storage.WebhookRequest) (storage.Webhook, error) {
+	webhook := storage.Webhook{URL: strings.TrimSpace(request.URL), ClickThreshold: request.ClickThreshold}
+
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(webhook.URL) > maxWebhookURL {
+		return storage.Webhook{}, ErrWebhookURL
+	}
+	if s.Blocked(webhook.URL) {
+		return storage.Webhook{}, ErrBlockedHost
+	}
+	// Names are checked again when the dispatcher connects; literal
+	// addresses and localhost can be refused right away.
+	if ip := net.ParseIP(u.Hostname()); (ip != nil && !webhooks.PublicIP(ip)) || strings.EqualFold(u.Hostname(), "localhost") {
+		return storage.Webhook{}, webhooks.ErrPrivateAddress
+	}
+
+	seen := make(map[string]bool, len(request.Events))
+	for _, event := range request.Events {
+		if !storage.ValidEvent(event) {
+			return storage.Webhook{}, fmt.Errorf("%w: unknown event %q", ErrWebhookEvents, event)
+		}
+		if !seen[event] {
+			seen[event] = true
+			webhook.Events = append(webhook.Events, event)
+		}
+	}
+	if len(webhook.Events) == 0 {
+		return storage.Webhook{}, ErrWebhookEvents
+	}
+
+	if (webhook.ClickThreshold > 0) != seen[storage.EventClickThreshold] || webhook.ClickThreshold < 0 {
+		return storage.Webhook{}, ErrClickThreshold
+	}
+
+	return webhook, nil
+}
+
+// CreateWebhookHandler subscribes the caller to link events. The secret
+// that signs the deliveries is only shown in this response.
+func (h *Handler) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	var request storage.WebhookRequest
+	if err := json.Unmarshal(b, &request); err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.Settings().CheckWebhook(request)
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	existing, err := h.Storage.UserWebhooks(r.Context(), idCookie.Value)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if len(existing) >= maxWebhooks {
+		httpError(w, r, ErrTooManyWebhooks, http.StatusConflict)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	webhook.User = idCookie.Value
+	webhook.Secret = hex.EncodeToString(secret)
+
+	if webhook.ID, err = h.Storage.AddWebhook(r.Context(), webhook); err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, http.StatusCreated, webhook)
+}
+
+func (h *Handler) GetWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	webhooks, err := h.Storage.UserWebhooks(r.Context(), idCookie.Value)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	writeJSON(w, r, http.StatusOK, webhooks)
+}
+
+// DeleteWebhookHandler removes one of the caller's webhooks along with its
+// queued and logged deliveries.
+func (h *Handler) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = h.Storage.DeleteWebhook(r.Context(), idCookie.Value, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeliveriesHandler is the delivery log of the caller's webhooks, newest
+// first. It takes webhook_id, status (pending, delivered or dead) and
+// limit.
+func (h *Handler) DeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	query, err := parseDeliveryQuery(r.URL.Query(), idCookie.Value)
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.Storage.ListDeliveries(r.Context(), query)
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, deliveries)
+}
+
+// RetryDeliveryHandler queues a dead delivery again with a fresh set of
+// attempts.
+func (h *Handler) RetryDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	idCookie, err := r.Cookie("user_id")
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		httpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	err = h.Storage.RetryDelivery(r.Context(), idCookie.Value, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		httpError(w, r, ErrDeliveryNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func parseDeliveryQuery(values url.Values, userID string) (storage.DeliveryQuery, error) {
+	query := storage.DeliveryQuery{User: userID, Limit: defaultDeliveryLimit}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxDeliveryLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxDeliveryLimit)
+		}
+		query.Limit = limit
+	}
+
+	if raw := values.Get("webhook_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id < 1 {
+			return query, errors.New("webhook_id must be a positive number")
+		}
+		query.WebhookID = id
+	}
+
+	switch status := values.Get("status"); status {
+	case "", storage.DeliveryPending, storage.DeliveryDelivered, storage.DeliveryDead:
+		query.Status = status
+	default:
+		return query, fmt.Errorf("unknown status %q", status)
+	}
+
+	return query, nil
+}
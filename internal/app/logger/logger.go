@@ -0,0 +1,241 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	}
+
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return DebugLevel, nil
+	case "", "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+
+	return InfoLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type Logger struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	level  *int32
+	json   bool
+	fields []interface{}
+}
+
+func New(out io.Writer, level Level, format string) (*Logger, error) {
+	var isJSON bool
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", FormatJSON:
+		isJSON = true
+	case FormatText:
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	lvl := int32(level)
+
+	return &Logger{
+		out:   out,
+		mu:    &sync.Mutex{},
+		level: &lvl,
+		json:  isJSON,
+	}, nil
+}
+
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+
+	child := *l
+	child.fields = fields
+
+	return &child
+}
+
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(l.level))
+}
+
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(l.level, int32(level))
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.log(DebugLevel, msg, keyvals)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.log(InfoLevel, msg, keyvals)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.log(WarnLevel, msg, keyvals)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.log(ErrorLevel, msg, keyvals)
+}
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if level < l.Level() {
+		return
+	}
+
+	all := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	all = append(all, l.fields...)
+	all = append(all, keyvals...)
+
+	var buf bytes.Buffer
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if l.json {
+		writeJSON(&buf, now, level, msg, all)
+	} else {
+		writeText(&buf, now, level, msg, all)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, now string, level Level, msg string, keyvals []interface{}) {
+	buf.WriteString(`{"time":`)
+	writeJSONValue(buf, now)
+	buf.WriteString(`,"level":`)
+	writeJSONValue(buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(buf, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		buf.WriteByte(',')
+		writeJSONValue(buf, fmt.Sprint(keyvals[i]))
+		buf.WriteByte(':')
+		writeJSONValue(buf, value(keyvals, i+1))
+	}
+	buf.WriteString("}\n")
+}
+
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+func writeText(buf *bytes.Buffer, now string, level Level, msg string, keyvals []interface{}) {
+	fmt.Fprintf(buf, "%s %s %s", now, strings.ToUpper(level.String()), msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		v := fmt.Sprint(value(keyvals, i+1))
+		if strings.ContainsAny(v, " \"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(buf, " %v=%s", keyvals[i], v)
+	}
+	buf.WriteByte('\n')
+}
+
+func value(keyvals []interface{}, i int) interface{} {
+	if i >= len(keyvals) {
+		return "(MISSING)"
+	}
+
+	switch v := keyvals[i].(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+
+	return keyvals[i]
+}
+
+var (
+	defaultMu        sync.RWMutex
+	defaultLogger, _ = New(os.Stderr, InfoLevel, FormatJSON)
+)
+
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+
+	return defaultLogger
+}
+
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultLogger = l
+}
+
+type ctxKey struct{}
+
+func WithContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*Logger); ok {
+		return l
+	}
+
+	return Default()
+}
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
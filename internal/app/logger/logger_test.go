@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, InfoLevel, FormatJSON)
+	require.NoError(t, err)
+
+	l.With("request_id", "abc").Error("save url", "error", errors.New(`bad "url"`), "took", time.Second, "odd")
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	_, err = time.Parse(time.RFC3339Nano, line["time"].(string))
+	assert.NoError(t, err)
+	delete(line, "time")
+	assert.Equal(t, map[string]interface{}{
+		"level":      "error",
+		"msg":        "save url",
+		"request_id": "abc",
+		"error":      `bad "url"`,
+		"took":       "1s",
+		"odd":        "(MISSING)",
+	}, line)
+}
+
+func TestLogger_Text(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, DebugLevel, FormatText)
+	require.NoError(t, err)
+
+	l.Warn("slow request", "path", "/a b", "status", 200)
+
+	out := buf.String()
+	assert.True(t, strings.HasSuffix(out, ` WARN slow request path="/a b" status=200`+"\n"), out)
+}
+
+func TestLogger_Level(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, WarnLevel, FormatJSON)
+	require.NoError(t, err)
+	child := l.With("component", "test")
+
+	child.Info("dropped")
+	assert.Empty(t, buf.String())
+
+	// Children share the level of their parent.
+	l.SetLevel(DebugLevel)
+	child.Debug("kept")
+	assert.Contains(t, buf.String(), `"msg":"kept"`)
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Level
+		wantErr bool
+	}{
+		{name: "debug #1", value: "debug", want: DebugLevel},
+		{name: "empty is info #2", value: "", want: InfoLevel},
+		{name: "warning #3", value: " Warning ", want: WarnLevel},
+		{name: "error #4", value: "ERROR", want: ErrorLevel},
+		{name: "unknown #5", value: "trace", want: InfoLevel, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, err := ParseLevel(tt.value)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, level)
+		})
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Same(t, Default(), FromContext(context.Background()))
+
+	var buf bytes.Buffer
+	l, err := New(&buf, InfoLevel, FormatJSON)
+	require.NoError(t, err)
+
+	ctx := WithRequestID(context.Background(), "abc")
+	ctx = WithContext(ctx, l.With("request_id", RequestID(ctx)))
+	FromContext(ctx).Error("count click", "error", errors.New("storage is down"))
+
+	assert.Equal(t, "abc", RequestID(ctx))
+	assert.Contains(t, buf.String(), `"request_id":"abc","error":"storage is down"`)
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import "database/sql"
+
+func RegisterDBStats(registry *Registry, db *sql.DB) {
+	stat := func(fn func(stats sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			return fn(db.Stats())
+		}
+	}
+
+	registry.NewGaugeFunc("shortener_db_open_connections", "Number of established database connections.", stat(func(s sql.DBStats) float64 {
+		return float64(s.OpenConnections)
+	}))
+	registry.NewGaugeFunc("shortener_db_in_use_connections", "Number of database connections currently in use.", stat(func(s sql.DBStats) float64 {
+		return float64(s.InUse)
+	}))
+	registry.NewGaugeFunc("shortener_db_idle_connections", "Number of idle database connections.", stat(func(s sql.DBStats) float64 {
+		return float64(s.Idle)
+	}))
+	registry.NewGaugeFunc("shortener_db_max_open_connections", "Maximum number of open database connections.", stat(func(s sql.DBStats) float64 {
+		return float64(s.MaxOpenConnections)
+	}))
+	registry.NewGaugeFunc("shortener_db_wait_count", "Total number of connections waited for.", stat(func(s sql.DBStats) float64 {
+		return float64(s.WaitCount)
+	}))
+	registry.NewGaugeFunc("shortener_db_wait_duration_seconds", "Total time blocked waiting for a new connection.", stat(func(s sql.DBStats) float64 {
+		return s.WaitDuration.Seconds()
+	}))
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	registry := NewRegistry()
+
+	requests := registry.NewCounterVec("test_requests_total", "Requests by path.\nSecond line with a \\.", "path")
+	requests.Inc("/b")
+	requests.Add(2, "/a")
+	requests.Inc(`quote " backslash \ newline` + "\n")
+
+	latency := registry.NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.5, 1}, "method")
+	latency.Observe(0.25, "GET")
+	latency.Observe(0.5, "GET")
+	latency.Observe(0.75, "GET")
+	latency.Observe(4, "GET")
+
+	registry.NewGaugeFunc("test_links", "Links.", func() float64 { return 3 })
+
+	var nilCounter *CounterVec
+	nilCounter.Inc()
+
+	want := `# HELP test_requests_total Requests by path.\nSecond line with a \\.
+# TYPE test_requests_total counter
+test_requests_total{path="/a"} 2
+test_requests_total{path="/b"} 1
+test_requests_total{path="quote \" backslash \\ newline\n"} 1
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{method="GET",le="0.5"} 2
+test_latency_seconds_bucket{method="GET",le="1"} 3
+test_latency_seconds_bucket{method="GET",le="+Inf"} 4
+test_latency_seconds_sum{method="GET"} 5.5
+test_latency_seconds_count{method="GET"} 4
+# HELP test_links Links.
+# TYPE test_links gauge
+test_links 3
+`
+
+	var buf bytes.Buffer
+	require.NoError(t, registry.Write(&buf))
+	assert.Equal(t, want, buf.String())
+}
+
+func TestCounterVec_LabelCount(t *testing.T) {
+	counter := NewRegistry().NewCounterVec("test_total", "Test.", "a", "b")
+	assert.Panics(t, func() { counter.Inc("only one") })
+}
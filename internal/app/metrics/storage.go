@@ -0,0 +1,318 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/interfaces"
+)
+
+type Storage struct {
+	interfaces.Storage
+	duration *HistogramVec
+	errors   *CounterVec
+}
+
+func NewStorage(s interfaces.Storage, registry *Registry) *Storage {
+	return &Storage{
+		Storage:  s,
+		duration: registry.NewHistogramVec("shortener_storage_operation_duration_seconds", "Storage operation latency by method.", nil, "method"),
+		errors:   registry.NewCounterVec("shortener_storage_operation_errors_total", "Storage operation errors by method.", "method"),
+	}
+}
+
+func (s *Storage) observe(method string, start time.Time, err error) {
+	s.duration.Observe(time.Since(start).Seconds(), method)
+	if err != nil {
+		s.errors.Inc(method)
+	}
+}
+
+func (s *Storage) Get(ctx context.Context, id int) (storage.CreateURL, error) {
+	start := time.Now()
+	createURL, err := s.Storage.Get(ctx, id)
+	s.observe("Get", start, err)
+
+	return createURL, err
+}
+
+func (s *Storage) GetOriginURL(ctx context.Context, originURL string) (storage.CreateURL, error) {
+	start := time.Now()
+	createURL, err := s.Storage.GetOriginURL(ctx, originURL)
+	s.observe("GetOriginURL", start, err)
+
+	return createURL, err
+}
+
+func (s *Storage) GetUser(ctx context.Context, userID string) ([]storage.CreateURL, error) {
+	start := time.Now()
+	createURLs, err := s.Storage.GetUser(ctx, userID)
+	s.observe("GetUser", start, err)
+
+	return createURLs, err
+}
+
+func (s *Storage) Set(ctx context.Context, createURL storage.CreateURL) (int, error) {
+	start := time.Now()
+	id, err := s.Storage.Set(ctx, createURL)
+	s.observe("Set", start, err)
+
+	return id, err
+}
+
+func (s *Storage) PutBatch(ctx context.Context, shortBatch []storage.ShortenBatch) ([]storage.ShortenBatch, error) {
+	start := time.Now()
+	shortBatch, err := s.Storage.PutBatch(ctx, shortBatch)
+	s.observe("PutBatch", start, err)
+
+	return shortBatch, err
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := s.Storage.Ping(ctx)
+	s.observe("Ping", start, err)
+
+	return err
+}
+
+func (s *Storage) DeleteURLs(ctx context.Context, ids []int) error {
+	start := time.Now()
+	err := s.Storage.DeleteURLs(ctx, ids)
+	s.observe("DeleteURLs", start, err)
+
+	return err
+}
+
+func (s *Storage) CountURLs(ctx context.Context) (int, error) {
+	start := time.Now()
+	count, err := s.Storage.CountURLs(ctx)
+	s.observe("CountURLs", start, err)
+
+	return count, err
+}
+
+func (s *Storage) CountUsers(ctx context.Context) (int, error) {
+	start := time.Now()
+	count, err := s.Storage.CountUsers(ctx)
+	s.observe("CountUsers", start, err)
+
+	return count, err
+}
+
+func (s *Storage) Lookup(ctx context.Context, id int) (storage.CreateURL, error) {
+	start := time.Now()
+	createURL, err := s.Storage.Lookup(ctx, id)
+	s.observe("Lookup", start, err)
+
+	return createURL, err
+}
+
+func (s *Storage) LookupOriginURL(ctx context.Context, originURL string) (storage.CreateURL, error) {
+	start := time.Now()
+	createURL, err := s.Storage.LookupOriginURL(ctx, originURL)
+	s.observe("LookupOriginURL", start, err)
+
+	return createURL, err
+}
+
+func (s *Storage) LookupUser(ctx context.Context, userID string) ([]storage.CreateURL, error) {
+	start := time.Now()
+	createURLs, err := s.Storage.LookupUser(ctx, userID)
+	s.observe("LookupUser", start, err)
+
+	return createURLs, err
+}
+
+func (s *Storage) RestoreURLs(ctx context.Context, ids []int) error {
+	start := time.Now()
+	err := s.Storage.RestoreURLs(ctx, ids)
+	s.observe("RestoreURLs", start, err)
+
+	return err
+}
+
+func (s *Storage) AddAudit(ctx context.Context, record storage.AuditRecord) error {
+	start := time.Now()
+	err := s.Storage.AddAudit(ctx, record)
+	s.observe("AddAudit", start, err)
+
+	return err
+}
+
+func (s *Storage) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	start := time.Now()
+	purged, err := s.Storage.PurgeDeleted(ctx, before)
+	s.observe("PurgeDeleted", start, err)
+
+	return purged, err
+}
+
+func (s *Storage) ListUserURLs(ctx context.Context, query storage.ListQuery) ([]storage.CreateURL, error) {
+	start := time.Now()
+	createURLs, err := s.Storage.ListUserURLs(ctx, query)
+	s.observe("ListUserURLs", start, err)
+
+	return createURLs, err
+}
+
+func (s *Storage) AddClick(ctx context.Context, id int, variant string) (int, error) {
+	start := time.Now()
+	clicks, err := s.Storage.AddClick(ctx, id, variant)
+	s.observe("AddClick", start, err)
+
+	return clicks, err
+}
+
+func (s *Storage) UpdateURL(ctx context.Context, createURL storage.CreateURL) error {
+	start := time.Now()
+	err := s.Storage.UpdateURL(ctx, createURL)
+	s.observe("UpdateURL", start, err)
+
+	return err
+}
+
+func (s *Storage) URLHistory(ctx context.Context, id int) ([]storage.URLVersion, error) {
+	start := time.Now()
+	versions, err := s.Storage.URLHistory(ctx, id)
+	s.observe("URLHistory", start, err)
+
+	return versions, err
+}
+
+func (s *Storage) MarkExpired(ctx context.Context, now time.Time) ([]storage.CreateURL, error) {
+	start := time.Now()
+	createURLs, err := s.Storage.MarkExpired(ctx, now)
+	s.observe("MarkExpired", start, err)
+
+	return createURLs, err
+}
+
+func (s *Storage) AddWebhook(ctx context.Context, webhook storage.Webhook) (int, error) {
+	start := time.Now()
+	id, err := s.Storage.AddWebhook(ctx, webhook)
+	s.observe("AddWebhook", start, err)
+
+	return id, err
+}
+
+func (s *Storage) UserWebhooks(ctx context.Context, userID string) ([]storage.Webhook, error) {
+	start := time.Now()
+	webhooks, err := s.Storage.UserWebhooks(ctx, userID)
+	s.observe("UserWebhooks", start, err)
+
+	return webhooks, err
+}
+
+func (s *Storage) DeleteWebhook(ctx context.Context, userID string, id int) error {
+	start := time.Now()
+	err := s.Storage.DeleteWebhook(ctx, userID, id)
+	s.observe("DeleteWebhook", start, err)
+
+	return err
+}
+
+func (s *Storage) EnqueueEvent(ctx context.Context, event storage.WebhookEvent) (int, error) {
+	start := time.Now()
+	queued, err := s.Storage.EnqueueEvent(ctx, event)
+	s.observe("EnqueueEvent", start, err)
+
+	return queued, err
+}
+
+func (s *Storage) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]storage.Delivery, error) {
+	start := time.Now()
+	deliveries, err := s.Storage.ClaimDeliveries(ctx, now, lease, limit)
+	s.observe("ClaimDeliveries", start, err)
+
+	return deliveries, err
+}
+
+func (s *Storage) UpdateDelivery(ctx context.Context, delivery storage.Delivery) error {
+	start := time.Now()
+	err := s.Storage.UpdateDelivery(ctx, delivery)
+	s.observe("UpdateDelivery", start, err)
+
+	return err
+}
+
+func (s *Storage) ListDeliveries(ctx context.Context, query storage.DeliveryQuery) ([]storage.Delivery, error) {
+	start := time.Now()
+	deliveries, err := s.Storage.ListDeliveries(ctx, query)
+	s.observe("ListDeliveries", start, err)
+
+	return deliveries, err
+}
+
+func (s *Storage) RetryDelivery(ctx context.Context, userID string, id int) error {
+	start := time.Now()
+	err := s.Storage.RetryDelivery(ctx, userID, id)
+	s.observe("RetryDelivery", start, err)
+
+	return err
+}
+
+func (s *Storage) PruneDeliveries(ctx context.Context, before time.Time) (int, error) {
+	start := time.Now()
+	pruned, err := s.Storage.PruneDeliveries(ctx, before)
+	s.observe("PruneDeliveries", start, err)
+
+	return pruned, err
+}
+
+func (s *Storage) AddCollection(ctx context.Context, collection storage.Collection) (int, error) {
+	start := time.Now()
+	id, err := s.Storage.AddCollection(ctx, collection)
+	s.observe("AddCollection", start, err)
+
+	return id, err
+}
+
+func (s *Storage) UpdateCollection(ctx context.Context, collection storage.Collection) error {
+	start := time.Now()
+	err := s.Storage.UpdateCollection(ctx, collection)
+	s.observe("UpdateCollection", start, err)
+
+	return err
+}
+
+func (s *Storage) DeleteCollection(ctx context.Context, userID string, id int) error {
+	start := time.Now()
+	err := s.Storage.DeleteCollection(ctx, userID, id)
+	s.observe("DeleteCollection", start, err)
+
+	return err
+}
+
+func (s *Storage) UserCollections(ctx context.Context, userID string) ([]storage.Collection, error) {
+	start := time.Now()
+	collections, err := s.Storage.UserCollections(ctx, userID)
+	s.observe("UserCollections", start, err)
+
+	return collections, err
+}
+
+func (s *Storage) LookupCollection(ctx context.Context, id int) (storage.Collection, error) {
+	start := time.Now()
+	collection, err := s.Storage.LookupCollection(ctx, id)
+	s.observe("LookupCollection", start, err)
+
+	return collection, err
+}
+
+func (s *Storage) GetCollection(ctx context.Context, slug string) (storage.Collection, error) {
+	start := time.Now()
+	collection, err := s.Storage.GetCollection(ctx, slug)
+	s.observe("GetCollection", start, err)
+
+	return collection, err
+}
+
+func (s *Storage) AddCollectionClick(ctx context.Context, id, linkID int) error {
+	start := time.Now()
+	err := s.Storage.AddCollectionClick(ctx, id, linkID)
+	s.observe("AddCollectionClick", start, err)
+
+	return err
+}
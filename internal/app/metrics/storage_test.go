@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/interfaces"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeStorage struct {
+	interfaces.Storage
+}
+
+func (fakeStorage) Get(ctx context.Context, id int) (storage.CreateURL, error) {
+	if id == 0 {
+		return storage.CreateURL{}, errors.New("not found")
+	}
+
+	return storage.CreateURL{ID: id}, nil
+}
+
+func (fakeStorage) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestStorage_Errors(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry()
+	s := NewStorage(fakeStorage{}, registry)
+
+	_, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	_, err = s.Get(ctx, 0)
+	require.Error(t, err)
+	_, err = s.Get(ctx, 0)
+	require.Error(t, err)
+	require.Error(t, s.Ping(ctx))
+
+	var buf bytes.Buffer
+	require.NoError(t, registry.Write(&buf))
+	out := buf.String()
+
+	assert.Contains(t, out, "shortener_storage_operation_errors_total{method=\"Get\"} 2\n")
+	assert.Contains(t, out, "shortener_storage_operation_errors_total{method=\"Ping\"} 1\n")
+	assert.Contains(t, out, "shortener_storage_operation_duration_seconds_count{method=\"Get\"} 3\n")
+	assert.Contains(t, out, "shortener_storage_operation_duration_seconds_count{method=\"Ping\"} 1\n")
+}
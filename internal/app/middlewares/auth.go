@@ -6,50 +6,102 @@ import (
 	"encoding/hex"
 	"io"
 	"net/http"
+	"sync/atomic"
 
+	"github.com/Fedorova199/red-cat/internal/app/tracing"
 	"github.com/google/uuid"
 )
 
+type authKeys struct {
+	current  []byte
+	previous [][]byte
+}
+
 type Auth struct {
-	secretKey []byte
+	keys atomic.Value
 }
 
-func NewAuth(secret []byte) *Auth {
-	return &Auth{secretKey: secret}
+func NewAuth(secret []byte, previous ...[]byte) *Auth {
+	a := &Auth{}
+	a.SetKeys(secret, previous...)
+
+	return a
 }
 
-func (a Auth) Handle(next http.HandlerFunc) http.HandlerFunc {
+// SetKeys replaces the signing key. Cookies signed with one of the previous
+// keys are still accepted and get re-signed with the current key.
+func (a *Auth) SetKeys(secret []byte, previous ...[]byte) {
+	a.keys.Store(authKeys{current: secret, previous: previous})
+}
+
+func (a *Auth) Handle(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "middleware.Auth")
 		idCookie, userErr := r.Cookie("user_id")
 		signCookie, signErr := r.Cookie("sign")
 
-		if userErr != nil || signErr != nil {
-			newUserID, sign, _ := a.generateUserID()
-			a.setCookies(w, r, newUserID, sign)
-		} else {
-			h := hmac.New(sha256.New, a.secretKey)
-			h.Write([]byte(idCookie.Value))
-			calculatedSign := h.Sum(nil)
-			sign, err := hex.DecodeString(signCookie.Value)
-			if err != nil {
+		var userID, sign string
+		if userErr == nil && signErr == nil {
+			if _, err := hex.DecodeString(signCookie.Value); err != nil {
+				span.RecordError(err)
+				span.End()
 				io.WriteString(w, err.Error())
 				return
 			}
+			userID, sign = idCookie.Value, signCookie.Value
+		}
 
-			if !hmac.Equal(calculatedSign, sign) {
-				newUserID, sign, _ := a.generateUserID()
-				a.setCookies(w, r, newUserID, sign)
-			}
+		newUserID, newSign := a.Identify(userID, sign)
+		if newUserID != userID || newSign != sign {
+			a.setCookies(w, r, newUserID, newSign)
 		}
+		span.End()
 
 		next.ServeHTTP(w, r)
 	}
 }
 
-func (a Auth) generateUserID() (string, string, error) {
+// Identify checks a user id against its hex encoded signature and returns
+// the identity to trust along with the signature the client should keep.
+// Ids signed with a previous key are re-signed; anything else gets a new id.
+func (a *Auth) Identify(userID, sign string) (string, string) {
+	keys := a.keys.Load().(authKeys)
+	rawSign, err := hex.DecodeString(sign)
+	if userID != "" && err == nil {
+		switch {
+		case hmac.Equal(calculateSign(keys.current, userID), rawSign):
+			return userID, sign
+		case signedWithAny(keys.previous, userID, rawSign):
+			return userID, hex.EncodeToString(calculateSign(keys.current, userID))
+		}
+	}
+
+	newUserID, newSign, _ := a.generateUserID()
+
+	return newUserID, newSign
+}
+
+func calculateSign(key []byte, userID string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(userID))
+
+	return h.Sum(nil)
+}
+
+func signedWithAny(keys [][]byte, userID string, sign []byte) bool {
+	for _, key := range keys {
+		if hmac.Equal(calculateSign(key, userID), sign) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *Auth) generateUserID() (string, string, error) {
 	newUserID := uuid.New().String()
 
-	h := hmac.New(sha256.New, a.secretKey)
+	h := hmac.New(sha256.New, a.keys.Load().(authKeys).current)
 	_, err := h.Write([]byte(newUserID))
 
 	if err != nil {
@@ -61,7 +113,7 @@ func (a Auth) generateUserID() (string, string, error) {
 	return newUserID, hex.EncodeToString(sign), nil
 }
 
-func (a Auth) setCookies(w http.ResponseWriter, r *http.Request, userID, sign string) {
+func (a *Auth) setCookies(w http.ResponseWriter, r *http.Request, userID, sign string) {
 	userIDCookie := &http.Cookie{
 		Name:  "user_id",
 		Value: userID,
@@ -74,13 +126,14 @@ func (a Auth) setCookies(w http.ResponseWriter, r *http.Request, userID, sign st
 	http.SetCookie(w, userIDCookie)
 	http.SetCookie(w, signCookie)
 
-	_, err := r.Cookie("user_id")
-	if err != nil {
-		r.AddCookie(userIDCookie)
-	}
-
-	_, err = r.Cookie("sign")
-	if err != nil {
-		r.AddCookie(signCookie)
+	// Replace whatever the client sent so handlers never see an unsigned id.
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != "user_id" && c.Name != "sign" {
+			r.AddCookie(c)
+		}
 	}
+	r.AddCookie(userIDCookie)
+	r.AddCookie(signCookie)
 }
@@ -0,0 +1,76 @@
+package middlewares
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signed(key, userID string) string {
+	return hex.EncodeToString(calculateSign([]byte(key), userID))
+}
+
+func TestAuth_SetKeys(t *testing.T) {
+	auth := NewAuth([]byte("old"))
+	auth.SetKeys([]byte("new"), []byte("old"))
+
+	tests := []struct {
+		name     string
+		userID   string
+		sign     string
+		wantUser bool
+		wantSign string
+	}{
+		{name: "current key #1", userID: "user", sign: signed("new", "user"), wantUser: true, wantSign: signed("new", "user")},
+		{name: "previous key is re-signed #2", userID: "user", sign: signed("old", "user"), wantUser: true, wantSign: signed("new", "user")},
+		{name: "unknown key #3", userID: "user", sign: signed("other", "user")},
+		{name: "no cookie #4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := auth.Handle(func(w http.ResponseWriter, r *http.Request) {
+				c, err := r.Cookie("user_id")
+				require.NoError(t, err)
+				seen = c.Value
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.userID != "" {
+				req.AddCookie(&http.Cookie{Name: "user_id", Value: tt.userID})
+				req.AddCookie(&http.Cookie{Name: "sign", Value: tt.sign})
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			cookies := make(map[string]string)
+			for _, c := range w.Result().Cookies() {
+				cookies[c.Name] = c.Value
+			}
+
+			if !tt.wantUser {
+				assert.NotEqual(t, tt.userID, seen, "unsigned id trusted")
+				assert.Equal(t, seen, cookies["user_id"])
+				assert.Equal(t, signed("new", seen), cookies["sign"])
+				return
+			}
+
+			assert.Equal(t, tt.userID, seen)
+			if tt.sign == tt.wantSign {
+				assert.Empty(t, cookies, "valid cookies set again")
+				return
+			}
+			assert.Equal(t, tt.userID, cookies["user_id"])
+			assert.Equal(t, tt.wantSign, cookies["sign"])
+		})
+	}
+
+	// Once the old key is dropped its cookies are no longer trusted.
+	auth.SetKeys([]byte("new"))
+	userID, _ := auth.Identify("user", signed("old", "user"))
+	assert.NotEqual(t, "user", userID)
+}
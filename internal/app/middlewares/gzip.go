@@ -5,6 +5,8 @@ import (
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/Fedorova199/red-cat/internal/app/tracing"
 )
 
 type gzipWriter struct {
@@ -16,6 +18,17 @@ func (w gzipWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush sends what the handler has written so far, so streamed responses
+// are not held back until the gzip stream is closed.
+func (w gzipWriter) Flush() {
+	if gz, ok := w.Writer.(*gzip.Writer); ok {
+		gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 type GzipEncoder struct{}
 
 func (g GzipEncoder) Handle(next http.HandlerFunc) http.HandlerFunc {
@@ -25,15 +38,19 @@ func (g GzipEncoder) Handle(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		ctx, span := tracing.Start(r.Context(), "middleware.GzipEncoder")
+		defer span.End()
+
 		gz, err := gzip.NewWriterLevel(w, gzip.BestSpeed)
 		if err != nil {
+			span.RecordError(err)
 			io.WriteString(w, err.Error())
 			return
 		}
 		defer gz.Close()
 
 		w.Header().Set("Content-Encoding", "gzip")
-		next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: gz}, r)
+		next.ServeHTTP(gzipWriter{ResponseWriter: w, Writer: gz}, r.WithContext(ctx))
 	}
 }
 
@@ -46,15 +63,20 @@ func (g GzipDecoder) Handle(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		ctx, span := tracing.Start(r.Context(), "middleware.GzipDecoder")
+		defer span.End()
+
 		gz, err := gzip.NewReader(r.Body)
 		if err != nil {
+			span.RecordError(err)
 			io.WriteString(w, err.Error())
 			return
 		}
 		defer gz.Close()
 
 		r.Body = gz
+		r.ContentLength = -1
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
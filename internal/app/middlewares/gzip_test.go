@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGzipEncoder_Flush(t *testing.T) {
+	w := httptest.NewRecorder()
+	var flushed []byte
+	handler := GzipEncoder{}.Handle(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, "first page\n")
+		flusher, ok := rw.(http.Flusher)
+		require.True(t, ok, "gzip writer cannot flush")
+		flusher.Flush()
+
+		// What was flushed decodes before the stream is closed.
+		gz, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+		require.NoError(t, err)
+		flushed = make([]byte, len("first page\n"))
+		_, err = io.ReadFull(gz, flushed)
+		require.NoError(t, err)
+
+		io.WriteString(rw, "second page\n")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler(w, req)
+
+	assert.Equal(t, "first page\n", string(flushed))
+	assert.True(t, w.Flushed)
+	gz, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "first page\nsecond page\n", string(body))
+}
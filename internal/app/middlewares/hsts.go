@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type HSTS struct {
+	value string
+}
+
+func NewHSTS(maxAge time.Duration) HSTS {
+	return HSTS{value: fmt.Sprintf("max-age=%d; includeSubDomains", int64(maxAge/time.Second))}
+}
+
+func (h HSTS) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", h.value)
+		}
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,168 @@
+package middlewares
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBodyTooLarge is returned by reads past the body limit.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+type BodyLimit struct {
+	maxBytes int64
+}
+
+func NewBodyLimit(maxBytes int64) *BodyLimit {
+	return &BodyLimit{maxBytes: maxBytes}
+}
+
+func (b *BodyLimit) SetMaxBytes(maxBytes int64) {
+	atomic.StoreInt64(&b.maxBytes, maxBytes)
+}
+
+func (b *BodyLimit) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		maxBytes := atomic.LoadInt64(&b.maxBytes)
+		if maxBytes <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > maxBytes {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		r.Body = &limitedBody{ReadCloser: r.Body, left: maxBytes}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// limitedBody fails with ErrBodyTooLarge once more than left bytes are
+// read. It limits what handlers see, so a decoded body is limited too.
+type limitedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.left < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.left {
+		n = int(b.left)
+		b.left = -1
+		return n, ErrBodyTooLarge
+	}
+	b.left -= int64(n)
+
+	return n, err
+}
+
+const bucketIdleTimeout = 10 * time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type RateLimit struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*bucket
+	sweep   time.Time
+}
+
+func NewRateLimit(rate float64, burst int) *RateLimit {
+	return &RateLimit{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *RateLimit) SetLimit(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = rate
+	l.burst = burst
+	l.buckets = make(map[string]*bucket)
+}
+
+func (l *RateLimit) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed, retryAfter := l.Allow(ClientKey(r))
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+1)))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Allow takes a token from the bucket of key and otherwise reports how
+// long to wait for the next one.
+func (l *RateLimit) Allow(key string) (bool, time.Duration) {
+	return l.allow(key, time.Now())
+}
+
+func (l *RateLimit) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	if now.Sub(l.sweep) > bucketIdleTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > bucketIdleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.sweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > float64(l.burst) {
+		b.tokens = float64(l.burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+func ClientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
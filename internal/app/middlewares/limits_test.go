@@ -0,0 +1,152 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxBytes      int64
+		body          string
+		contentLength int64
+		want          int
+		wantCalled    bool
+	}{
+		{name: "under the limit #1", maxBytes: 10, body: "short", contentLength: 5, want: http.StatusOK, wantCalled: true},
+		{name: "exactly the limit #2", maxBytes: 5, body: "short", contentLength: 5, want: http.StatusOK, wantCalled: true},
+		{name: "content length over the limit #3", maxBytes: 4, body: "short", contentLength: 5, want: http.StatusRequestEntityTooLarge},
+		{name: "unknown length over the limit #4", maxBytes: 4, body: "short", contentLength: -1, want: http.StatusRequestEntityTooLarge, wantCalled: true},
+		{name: "no limit #5", body: strings.Repeat("a", 1<<16), contentLength: 1 << 16, want: http.StatusOK, wantCalled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := NewBodyLimit(tt.maxBytes).Handle(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				_, err := io.ReadAll(r.Body)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.ContentLength = tt.contentLength
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+			assert.Equal(t, tt.wantCalled, called)
+		})
+	}
+}
+
+func TestRateLimit_Allow(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		key       string
+		after     time.Duration
+		want      bool
+		wantRetry time.Duration
+	}{
+		{name: "burst #1", key: "a", want: true},
+		{name: "burst #2", key: "a", want: true},
+		{name: "burst used up #3", key: "a", wantRetry: 500 * time.Millisecond},
+		{name: "other client has its own bucket #4", key: "b", want: true},
+		{name: "partly refilled #5", key: "a", after: 250 * time.Millisecond, wantRetry: 250 * time.Millisecond},
+		{name: "refilled #6", key: "a", after: 500 * time.Millisecond, want: true},
+		{name: "refill is capped at burst #7", key: "a", after: time.Hour, want: true},
+		{name: "refill is capped at burst #8", key: "a", after: time.Hour, want: true},
+		{name: "refill is capped at burst #9", key: "a", after: time.Hour, wantRetry: 500 * time.Millisecond},
+	}
+
+	limit := NewRateLimit(2, 2)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, retry := limit.allow(tt.key, start.Add(tt.after))
+			assert.Equal(t, tt.want, allowed)
+			assert.Equal(t, tt.wantRetry, retry)
+		})
+	}
+}
+
+func TestRateLimit_Eviction(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	limit := NewRateLimit(1, 1)
+
+	limit.allow("idle", start)
+	limit.allow("busy", start)
+	limit.allow("busy", start.Add(bucketIdleTimeout/2))
+	assert.Len(t, limit.buckets, 2)
+
+	limit.allow("new", start.Add(bucketIdleTimeout+time.Second))
+	assert.Len(t, limit.buckets, 2)
+	assert.NotContains(t, limit.buckets, "idle")
+	assert.Contains(t, limit.buckets, "busy")
+
+	limit.SetLimit(1, 1)
+	assert.Empty(t, limit.buckets)
+}
+
+func TestRateLimit_Handle(t *testing.T) {
+	handler := NewRateLimit(0.4, 1).Handle(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       int
+		wantRetry  string
+	}{
+		{name: "first request #1", remoteAddr: "192.0.2.1:1000", want: http.StatusOK},
+		{name: "same client, other port #2", remoteAddr: "192.0.2.1:2000", want: http.StatusTooManyRequests, wantRetry: "3"},
+		{name: "other client #3", remoteAddr: "192.0.2.2:1000", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+			assert.Equal(t, tt.wantRetry, w.Header().Get("Retry-After"))
+		})
+	}
+
+	// A zero rate turns limiting off.
+	unlimited := NewRateLimit(0, 0).Handle(func(w http.ResponseWriter, r *http.Request) {})
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		unlimited(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		want       string
+	}{
+		{name: "ipv4 #1", remoteAddr: "192.0.2.1:1234", want: "192.0.2.1"},
+		{name: "ipv6 #2", remoteAddr: "[2001:db8::1]:1234", want: "2001:db8::1"},
+		{name: "no port #3", remoteAddr: "192.0.2.1", want: "192.0.2.1"},
+		{name: "unix socket #4", remoteAddr: "@", want: "@"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			assert.Equal(t, tt.want, ClientKey(req))
+		})
+	}
+}
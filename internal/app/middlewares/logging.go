@@ -0,0 +1,75 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/tracing"
+	"github.com/google/uuid"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 128
+)
+
+type Logging struct {
+	logger *logger.Logger
+}
+
+func NewLogging(l *logger.Logger) *Logging {
+	return &Logging{logger: l}
+}
+
+func (l *Logging) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		log := l.logger.With("request_id", requestID)
+		if sc, ok := tracing.SpanContextFromContext(r.Context()); ok {
+			log = log.With("trace_id", sc.TraceID.String(), "span_id", sc.SpanID.String())
+		}
+		ctx := logger.WithRequestID(r.Context(), requestID)
+		ctx = logger.WithContext(ctx, log)
+		r = r.WithContext(ctx)
+
+		sw := newStatusWriter(w)
+		next.ServeHTTP(sw, r)
+
+		var userID string
+		if idCookie, err := r.Cookie("user_id"); err == nil {
+			userID = idCookie.Value
+		}
+
+		log.Info("request",
+			"method", r.Method,
+			"route", routePattern(r),
+			"path", r.URL.Path,
+			"status", sw.Status(),
+			"bytes", sw.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"user_id", userID,
+		)
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,97 @@
+package middlewares
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogging_Handle(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		keep      bool
+	}{
+		{name: "valid request id is kept #1", requestID: "abc-123", keep: true},
+		{name: "no request id #2"},
+		{name: "request id with a space #3", requestID: "abc 123"},
+		{name: "request id with a control character #4", requestID: "abc\x01"},
+		{name: "request id too long #5", requestID: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "request id at the length limit #6", requestID: strings.Repeat("a", maxRequestIDLength), keep: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log, err := logger.New(&buf, logger.InfoLevel, logger.FormatJSON)
+			require.NoError(t, err)
+
+			var seen string
+			router := chi.NewRouter()
+			router.Get("/{id}", NewLogging(log).Handle(func(w http.ResponseWriter, r *http.Request) {
+				seen = logger.RequestID(r.Context())
+				logger.FromContext(r.Context()).Error("count click", "error", errors.New("storage is down"))
+				w.WriteHeader(http.StatusTeapot)
+				w.Write([]byte("body"))
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/42", nil)
+			if tt.requestID != "" {
+				req.Header.Set(requestIDHeader, tt.requestID)
+			}
+			req.AddCookie(&http.Cookie{Name: "user_id", Value: "user1"})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			requestID := w.Header().Get(requestIDHeader)
+			if tt.keep {
+				assert.Equal(t, tt.requestID, requestID)
+			} else {
+				_, err := uuid.Parse(requestID)
+				assert.NoError(t, err, "replacement is not a uuid")
+			}
+			assert.Equal(t, requestID, seen)
+
+			var lines []map[string]interface{}
+			scanner := bufio.NewScanner(&buf)
+			scanner.Buffer(nil, 1<<20)
+			for scanner.Scan() {
+				var line map[string]interface{}
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+				lines = append(lines, line)
+			}
+			require.Len(t, lines, 2)
+
+			storageLine, accessLine := lines[0], lines[1]
+			assert.Equal(t, "error", storageLine["level"])
+			assert.Equal(t, "storage is down", storageLine["error"])
+			assert.Equal(t, requestID, storageLine["request_id"])
+
+			assert.NotEmpty(t, accessLine["time"])
+			assert.IsType(t, 0.0, accessLine["duration_ms"])
+			delete(accessLine, "time")
+			delete(accessLine, "duration_ms")
+			assert.Equal(t, map[string]interface{}{
+				"level":      "info",
+				"msg":        "request",
+				"request_id": requestID,
+				"method":     http.MethodGet,
+				"route":      "/{id}",
+				"path":       "/42",
+				"status":     float64(http.StatusTeapot),
+				"bytes":      float64(4),
+				"user_id":    "user1",
+			}, accessLine)
+		})
+	}
+}
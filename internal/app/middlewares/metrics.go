@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/metrics"
+	"github.com/go-chi/chi/v5"
+)
+
+type Metrics struct {
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+}
+
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests: registry.NewCounterVec("shortener_http_requests_total", "HTTP requests by method, route and status code.", "method", "route", "code"),
+		duration: registry.NewHistogramVec("shortener_http_request_duration_seconds", "HTTP request latency by method and route.", nil, "method", "route"),
+	}
+}
+
+func (m *Metrics) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := newStatusWriter(w)
+
+		next.ServeHTTP(sw, r)
+
+		route := routePattern(r)
+		m.requests.Inc(r.Method, route, strconv.Itoa(sw.Status()))
+		m.duration.Observe(time.Since(start).Seconds(), r.Method, route)
+	}
+}
+
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.RoutePattern() == "" {
+		return "unknown"
+	}
+
+	return rctx.RoutePattern()
+}
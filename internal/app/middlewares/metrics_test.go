@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Fedorova199/red-cat/internal/app/metrics"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_Handle(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry)
+
+	router := chi.NewRouter()
+	router.Get("/{id}", m.Handle(func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "id") == "0" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	router.Post("/api/shorten", m.Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	for _, target := range []string{"/1", "/2", "/0"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/shorten", nil))
+
+	// Outside a router there is no pattern to report.
+	m.Handle(func(w http.ResponseWriter, r *http.Request) {})(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/plain", nil))
+
+	var buf bytes.Buffer
+	require.NoError(t, registry.Write(&buf))
+	out := buf.String()
+
+	assert.Contains(t, out, `shortener_http_requests_total{method="GET",route="/{id}",code="307"} 2`+"\n")
+	assert.Contains(t, out, `shortener_http_requests_total{method="GET",route="/{id}",code="404"} 1`+"\n")
+	assert.Contains(t, out, `shortener_http_requests_total{method="POST",route="/api/shorten",code="201"} 1`+"\n")
+	assert.Contains(t, out, `shortener_http_requests_total{method="GET",route="unknown",code="200"} 1`+"\n")
+	assert.Contains(t, out, `shortener_http_request_duration_seconds_count{method="GET",route="/{id}"} 3`+"\n")
+	assert.NotContains(t, out, `route="/1"`)
+}
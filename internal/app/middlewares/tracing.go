@@ -0,0 +1,43 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Fedorova199/red-cat/internal/app/tracing"
+)
+
+const traceparentHeader = "traceparent"
+
+type Tracing struct {
+	tracer *tracing.Tracer
+}
+
+func NewTracing(tracer *tracing.Tracer) *Tracing {
+	return &Tracing{tracer: tracer}
+}
+
+func (t *Tracing) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, err := tracing.ParseTraceparent(r.Header.Get(traceparentHeader)); err == nil {
+			ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+		}
+
+		route := routePattern(r)
+		ctx, span := t.tracer.Start(ctx, r.Method+" "+route, tracing.KindServer)
+		defer span.End()
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+
+		sw := newStatusWriter(w)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", sw.Status())
+		if sw.Status() >= http.StatusInternalServerError {
+			span.RecordError(fmt.Errorf("%d %s", sw.Status(), http.StatusText(sw.Status())))
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+)
+
+const defaultBatchSize = 1000
+
+type Source interface {
+	Records(ctx context.Context, afterID, limit int) ([]storage.CreateURL, error)
+	CountRecords(ctx context.Context) (total, deleted int, err error)
+}
+
+type Target interface {
+	ImportRecords(ctx context.Context, records []storage.CreateURL) error
+	CountRecords(ctx context.Context) (total, deleted int, err error)
+}
+
+// sequenceResetter is implemented by targets that hand out ids themselves
+// and need to skip past the imported ones.
+type sequenceResetter interface {
+	ResetSequence(ctx context.Context) error
+}
+
+type Options struct {
+	BatchSize int
+	// Checkpoint is a file holding the last copied id. A run that finds it
+	// continues after that id; it is removed once the copy is verified.
+	Checkpoint string
+	Logger     *logger.Logger
+}
+
+type Result struct {
+	Copied  int
+	Total   int
+	Deleted int
+}
+
+var ErrCountMismatch = errors.New("record counts do not match")
+
+// Run copies every record from src to dst in id order, keeping ids, owners
+// and deleted flags, then checks that both sides hold the same counts.
+func Run(ctx context.Context, src Source, dst Target, opts Options) (Result, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	log := opts.Logger
+	if log == nil {
+		log = logger.Default()
+	}
+
+	afterID, err := readCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return Result{}, err
+	}
+	if afterID > 0 {
+		log.Info("resuming migration", "after_id", afterID)
+	}
+
+	var result Result
+	for {
+		records, err := src.Records(ctx, afterID, opts.BatchSize)
+		if err != nil {
+			return result, fmt.Errorf("read records after id %d: %w", afterID, err)
+		}
+		if len(records) == 0 {
+			break
+		}
+
+		if err := dst.ImportRecords(ctx, records); err != nil {
+			return result, fmt.Errorf("import records after id %d: %w", afterID, err)
+		}
+
+		afterID = records[len(records)-1].ID
+		result.Copied += len(records)
+		if err := writeCheckpoint(opts.Checkpoint, afterID); err != nil {
+			return result, err
+		}
+		log.Info("records copied", "count", len(records), "last_id", afterID)
+	}
+
+	if resetter, ok := dst.(sequenceResetter); ok {
+		if err := resetter.ResetSequence(ctx); err != nil {
+			return result, fmt.Errorf("reset id sequence: %w", err)
+		}
+	}
+
+	srcTotal, srcDeleted, err := src.CountRecords(ctx)
+	if err != nil {
+		return result, fmt.Errorf("count source records: %w", err)
+	}
+	dstTotal, dstDeleted, err := dst.CountRecords(ctx)
+	if err != nil {
+		return result, fmt.Errorf("count target records: %w", err)
+	}
+	result.Total, result.Deleted = dstTotal, dstDeleted
+
+	if srcTotal != dstTotal || srcDeleted != dstDeleted {
+		return result, fmt.Errorf("%w: source has %d (%d deleted), target has %d (%d deleted)",
+			ErrCountMismatch, srcTotal, srcDeleted, dstTotal, dstDeleted)
+	}
+
+	if opts.Checkpoint != "" {
+		if err := os.Remove(opts.Checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+func readCheckpoint(name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("checkpoint %s: %w", name, err)
+	}
+
+	return id, nil
+}
+
+func writeCheckpoint(name string, id int) error {
+	if name == "" {
+		return nil
+	}
+
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(id)+"\n"), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
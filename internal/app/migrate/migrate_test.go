@@ -0,0 +1,119 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingTarget struct {
+	*storage.Models
+	failAfter int
+	calls     int
+}
+
+func (t *failingTarget) ImportRecords(ctx context.Context, records []storage.CreateURL) error {
+	t.calls++
+	if t.calls > t.failAfter {
+		return errors.New("connection lost")
+	}
+
+	return t.Models.ImportRecords(ctx, records)
+}
+
+func newSource() *storage.Models {
+	return &storage.Models{
+		Counter: 6,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user1", URL: "test1.ru"},
+			2: {ID: 2, User: "user1", URL: "test2.ru", Deleted: true},
+			3: {ID: 3, User: "user2", URL: "test3.ru", History: []storage.URLVersion{
+				{Version: 1, OriginalURL: "old3.ru", ReplacedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+				{Version: 2, OriginalURL: "older3.ru", ReplacedAt: time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)},
+			}},
+			5: {ID: 5, User: "user2", URL: "test5.ru", Deleted: true},
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	src := newSource()
+	dst := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+
+	result, err := Run(ctx, src, dst, Options{BatchSize: 3, Checkpoint: checkpoint})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Copied: 4, Total: 4, Deleted: 2}, result)
+	assert.Equal(t, src.Model, dst.Model)
+	assert.Equal(t, 6, dst.Counter)
+	assert.NoFileExists(t, checkpoint)
+
+	result, err = Run(ctx, src, dst, Options{BatchSize: 3, Checkpoint: checkpoint})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Copied: 4, Total: 4, Deleted: 2}, result)
+	assert.Equal(t, src.Model, dst.Model)
+}
+
+func TestRun_History(t *testing.T) {
+	ctx := context.Background()
+	src := newSource()
+	dst := &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}}
+
+	_, err := Run(ctx, src, dst, Options{Checkpoint: filepath.Join(t.TempDir(), "checkpoint")})
+	require.NoError(t, err)
+
+	want, err := src.URLHistory(ctx, 3)
+	require.NoError(t, err)
+	got, err := dst.URLHistory(ctx, 3)
+	require.NoError(t, err)
+	assert.Len(t, got, 2)
+	assert.Equal(t, want, got)
+}
+
+func TestRun_Resume(t *testing.T) {
+	ctx := context.Background()
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	src := newSource()
+	dst := &failingTarget{
+		Models:    &storage.Models{Counter: 1, Model: map[int]storage.CreateURL{}},
+		failAfter: 1,
+	}
+
+	_, err := Run(ctx, src, dst, Options{BatchSize: 2, Checkpoint: checkpoint})
+	require.Error(t, err)
+	data, err := os.ReadFile(checkpoint)
+	require.NoError(t, err)
+	assert.Equal(t, "2\n", string(data))
+	assert.Len(t, dst.Model, 2)
+
+	dst.failAfter = 10
+	result, err := Run(ctx, src, dst, Options{BatchSize: 2, Checkpoint: checkpoint})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Copied)
+	assert.Equal(t, src.Model, dst.Model)
+	assert.NoFileExists(t, checkpoint)
+}
+
+func TestRun_CountMismatch(t *testing.T) {
+	ctx := context.Background()
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	dst := &storage.Models{
+		Counter: 10,
+		Model: map[int]storage.CreateURL{
+			9: {ID: 9, User: "user3", URL: "test9.ru"},
+		},
+	}
+
+	result, err := Run(ctx, newSource(), dst, Options{Checkpoint: checkpoint})
+	assert.ErrorIs(t, err, ErrCountMismatch)
+	assert.Equal(t, 5, result.Total)
+	assert.FileExists(t, checkpoint)
+}
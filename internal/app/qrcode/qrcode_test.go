@@ -0,0 +1,132 @@
+package qrcode
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	zxingqr "github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, img image.Image) string {
+	t.Helper()
+
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	require.NoError(t, err)
+
+	result, err := zxingqr.NewQRCodeReader().Decode(bmp, map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_PURE_BARCODE: true,
+	})
+	require.NoError(t, err)
+
+	return result.GetText()
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		level   Level
+		version int
+	}{
+		{name: "short url #1", data: "http://localhost:8080/1", level: Medium, version: 2},
+		{name: "low level #2", data: "http://localhost:8080/1", level: Low, version: 2},
+		{name: "high level #3", data: "https://example.com/42", level: High, version: 3},
+		{name: "non ascii #4", data: "https://пример.рф/ссылка", level: Quartile, version: 4},
+		{name: "version info #5", data: strings.Repeat("https://example.com/", 6), level: Medium, version: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Encode([]byte(tt.data), tt.level)
+			require.NoError(t, err)
+			assert.Equal(t, tt.version, code.Version)
+			assert.Equal(t, tt.version*4+17, code.Size)
+
+			data, err := code.PNG(0, 4)
+			require.NoError(t, err)
+			img, err := png.Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.data, decode(t, img))
+		})
+	}
+}
+
+func TestEncode_AllVersions(t *testing.T) {
+	for level := Low; level <= High; level++ {
+		for version := minVersion; version <= maxVersion; version++ {
+			data := make([]byte, capacity(version, level))
+			for i := range data {
+				data[i] = "0123456789abcdefghijklmnopqrstuvwxyz"[(i*7+version)%36]
+			}
+
+			code, err := Encode(data, level)
+			require.NoError(t, err)
+			require.Equal(t, version, code.Version, "level %s", level)
+
+			assert.Equal(t, string(data), decode(t, code.Image(0, 4)), "version %d level %s", version, level)
+		}
+	}
+
+	_, err := Encode(make([]byte, capacity(maxVersion, Low)+1), Low)
+	assert.ErrorIs(t, err, ErrTooLong)
+}
+
+func TestCode_Image(t *testing.T) {
+	code, err := Encode([]byte("http://localhost:8080/1"), Medium)
+	require.NoError(t, err)
+
+	img := code.Image(256, 4)
+	assert.Equal(t, image.Rect(0, 0, 256, 256), img.Bounds())
+	assert.Equal(t, "http://localhost:8080/1", decode(t, img))
+
+	img = code.Image(10, 2)
+	assert.Equal(t, image.Rect(0, 0, code.Size+4, code.Size+4), img.Bounds())
+}
+
+func TestCode_WriteSVG(t *testing.T) {
+	code, err := Encode([]byte("http://localhost:8080/1"), Medium)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, code.WriteSVG(&buf, 300, 2))
+	svg := buf.String()
+	assert.Contains(t, svg, `width="300" height="300" viewBox="0 0 29 29"`)
+
+	// Paint the path back onto a grid and compare it with the symbol.
+	grid := make([][]bool, code.Size)
+	for i := range grid {
+		grid[i] = make([]bool, code.Size)
+	}
+	for _, m := range regexp.MustCompile(`M(\d+) (\d+)h(\d+)`).FindAllStringSubmatch(svg, -1) {
+		x, _ := strconv.Atoi(m[1])
+		y, _ := strconv.Atoi(m[2])
+		run, _ := strconv.Atoi(m[3])
+		for i := 0; i < run; i++ {
+			grid[y-2][x-2+i] = true
+		}
+	}
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			require.Equal(t, code.Dark(x, y), grid[y][x], "module %d,%d", x, y)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{"L", "m", "Q", "h"} {
+		level, err := ParseLevel(s)
+		require.NoError(t, err)
+		assert.Equal(t, strings.ToUpper(s), level.String())
+	}
+
+	_, err := ParseLevel("X")
+	assert.Error(t, err)
+}
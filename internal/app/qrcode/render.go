@@ -0,0 +1,79 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+var palette = color.Palette{color.White, color.Black}
+
+// Image draws the symbol on a size x size canvas with a quiet zone of
+// margin modules. Modules are whole pixels, so any space left over is
+// spread evenly around the quiet zone. A size too small for one pixel per
+// module is raised to fit.
+func (c *Code) Image(size, margin int) image.Image {
+	total := c.Size + 2*margin
+	scale := size / total
+	if scale < 1 {
+		scale, size = 1, total
+	}
+	offset := (size-total*scale)/2 + margin*scale
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				row := img.Pix[(offset+y*scale+py)*img.Stride:]
+				for px := 0; px < scale; px++ {
+					row[offset+x*scale+px] = 1
+				}
+			}
+		}
+	}
+
+	return img
+}
+
+func (c *Code) PNG(size, margin int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.Image(size, margin)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteSVG writes the symbol as a single path in module units, scaled to
+// size x size by the viewBox.
+func (c *Code) WriteSVG(w io.Writer, size, margin int) error {
+	total := c.Size + 2*margin
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, total, total)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, total, total)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] {
+				continue
+			}
+			run := 1
+			for x+run < c.Size && c.modules[y][x+run] {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv1h-%dz", x+margin, y+margin, run, run)
+			x += run
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	buf.WriteByte('\n')
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
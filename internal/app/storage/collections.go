@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrDuplicateSlug is returned when a collection takes a slug another
+// collection already has.
+var ErrDuplicateSlug = errors.New("slug is already taken")
+
+// Collection is a named, ordered list of a user's links served at
+// /c/{slug}. Clicks counts the links followed from the collection page;
+// LinkClicks splits them by link id.
+type Collection struct {
+	ID          int              `json:"id"`
+	User        string           `json:"user_id"`
+	Slug        string           `json:"slug"`
+	Title       string           `json:"title"`
+	Description string           `json:"description,omitempty"`
+	Links       []CollectionItem `json:"links"`
+	Clicks      int              `json:"clicks"`
+	LinkClicks  ClickCounts      `json:"link_clicks,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+// CollectionItem is a link in a collection. Title is the label shown on
+// the collection page.
+type CollectionItem struct {
+	ID    int    `json:"id"`
+	Title string `json:"title,omitempty"`
+}
+
+// CollectionRequest is the body of a collection create or update. Fields
+// left out of an update keep their value; links replace the whole list.
+type CollectionRequest struct {
+	Slug        *string           `json:"slug"`
+	Title       *string           `json:"title"`
+	Description *string           `json:"description"`
+	Links       *[]CollectionItem `json:"links"`
+}
+
+// CollectionInfo is a collection as its owner sees it.
+type CollectionInfo struct {
+	ID          int              `json:"id"`
+	Slug        string           `json:"slug"`
+	PageURL     string           `json:"page_url"`
+	Title       string           `json:"title"`
+	Description string           `json:"description,omitempty"`
+	Clicks      int              `json:"clicks"`
+	Links       []CollectionLink `json:"links"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+type CollectionLink struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title,omitempty"`
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	Status      string `json:"status"`
+	Clicks      int    `json:"clicks"`
+}
+
+// CollectionPage is the public view of a collection. Links only lists the
+// links that can be followed right now.
+type CollectionPage struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+	Links       []PageLink `json:"links"`
+}
+
+// PageLink URL goes through the collection, so the click is counted for
+// it before the short link is followed.
+type PageLink struct {
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	ShortURL string `json:"short_url"`
+}
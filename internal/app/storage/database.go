@@ -5,14 +5,44 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/tracing"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
 )
 
 type Database struct {
 	db *sql.DB
 }
 
-var ErrDeleted = errors.New("deleted")
+var (
+	ErrDeleted  = errors.New("deleted")
+	ErrNotFound = errors.New("not found")
+	// ErrExhausted is returned for links that reached their click limit.
+	ErrExhausted = errors.New("click limit reached")
+	// ErrExpired is returned for links past their expiry time.
+	ErrExpired = errors.New("link expired")
+	// ErrDuplicateURL is returned when a link is changed to a destination
+	// another link already has.
+	ErrDuplicateURL = errors.New("destination is already shortened")
+
+	likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+)
+
+// urlColumns lists the url columns in the order scanURL reads them.
+const urlColumns = "id, user_id, origin_url, deleted, deleted_at, created_at, redirect, clicks, password_hash, max_clicks, expires_at, rules, variants, variant_clicks, query, expiry_notified"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanURL(row rowScanner, createURL *CreateURL) error {
+	return row.Scan(&createURL.ID, &createURL.User, &createURL.URL, &createURL.Deleted, &createURL.DeletedAt, &createURL.CreatedAt, &createURL.Redirect, &createURL.Clicks, &createURL.PasswordHash, &createURL.MaxClicks, &createURL.ExpiresAt, &createURL.Rules, &createURL.Variants, &createURL.VariantClicks, &createURL.Query, &createURL.ExpiryNotified)
+}
 
 func CreateDatabase(db *sql.DB) (*Database, error) {
 	databaseStorage := &Database{
@@ -29,20 +59,152 @@ func CreateDatabase(db *sql.DB) (*Database, error) {
 
 func (s *Database) init() error {
 	_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS url ( id bigserial primary key, user_id varchar(36), origin_url varchar(255),deleted boolean default false, CONSTRAINT origin_url_unique UNIQUE (origin_url) )")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS deleted_at timestamptz")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS url_deleted_at_idx ON url (deleted_at) WHERE deleted")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now()")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS url_user_id_idx ON url (user_id, id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS redirect varchar(16) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS clicks bigint NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS password_hash varchar(100) NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS max_clicks integer NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS expires_at timestamptz")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS rules jsonb NOT NULL DEFAULT '[]'")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS variants jsonb NOT NULL DEFAULT '[]'")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS variant_clicks jsonb NOT NULL DEFAULT '{}'")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS query jsonb")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE TABLE IF NOT EXISTS url_history ( id bigserial primary key, url_id bigint not null, origin_url varchar(255) not null, replaced_at timestamptz not null default now() )")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS url_history_url_id_idx ON url_history (url_id, id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("ALTER TABLE url ADD COLUMN IF NOT EXISTS expiry_notified boolean NOT NULL DEFAULT false")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE TABLE IF NOT EXISTS webhook ( id bigserial primary key, user_id varchar(36) not null, url varchar(2048) not null, secret varchar(64) not null, events varchar(255) not null, click_threshold integer not null default 0, created_at timestamptz not null default now() )")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS webhook_user_id_idx ON webhook (user_id, id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE TABLE IF NOT EXISTS webhook_delivery ( id bigserial primary key, webhook_id bigint not null references webhook (id) ON DELETE CASCADE, user_id varchar(36) not null, event varchar(32) not null, payload text not null, status varchar(16) not null default 'pending', attempts integer not null default 0, next_attempt_at timestamptz not null default now(), last_status integer not null default 0, last_error text not null default '', created_at timestamptz not null default now(), delivered_at timestamptz )")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS webhook_delivery_due_idx ON webhook_delivery (next_attempt_at) WHERE status = 'pending'")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS webhook_delivery_user_id_idx ON webhook_delivery (user_id, id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS webhook_delivery_finished_idx ON webhook_delivery (created_at) WHERE status <> 'pending'")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE TABLE IF NOT EXISTS collection ( id bigserial primary key, user_id varchar(36) not null, slug varchar(64) not null, title varchar(200) not null, description varchar(1000) not null default '', clicks bigint not null default 0, link_clicks jsonb not null default '{}', created_at timestamptz not null default now(), updated_at timestamptz not null default now(), CONSTRAINT collection_slug_unique UNIQUE (slug) )")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS collection_user_id_idx ON collection (user_id, id)")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE TABLE IF NOT EXISTS collection_link ( collection_id bigint not null references collection (id) ON DELETE CASCADE, url_id bigint not null references url (id) ON DELETE CASCADE, position integer not null, title varchar(200) not null default '', PRIMARY KEY (collection_id, url_id) )")
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec("CREATE TABLE IF NOT EXISTS admin_audit ( id bigserial primary key, created_at timestamptz not null default now(), action varchar(32) not null, target varchar(255) not null, remote_addr varchar(64), request_id varchar(128) )")
 
 	return err
 }
 
 func (s *Database) Get(ctx context.Context, id int) (CreateURL, error) {
+	ctx, span := startDBSpan(ctx, "Database.Get")
+	defer span.End()
+
 	var createURL CreateURL
-	var deleted bool
-	row := s.db.QueryRowContext(ctx, "SELECT id, user_id, origin_url, deleted FROM url WHERE id = $1", id)
-	err := row.Scan(&createURL.ID, &createURL.User, &createURL.URL, &deleted)
+	err := scanURL(s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE id = $1", id), &createURL)
 	if err != nil {
+		span.RecordError(err)
 		return CreateURL{}, err
 	}
 
-	if deleted {
+	if createURL.Deleted {
 		return CreateURL{}, ErrDeleted
 	}
 
@@ -50,11 +212,14 @@ func (s *Database) Get(ctx context.Context, id int) (CreateURL, error) {
 }
 
 func (s *Database) GetOriginURL(ctx context.Context, originURL string) (CreateURL, error) {
+	ctx, span := startDBSpan(ctx, "Database.GetOriginURL")
+	defer span.End()
+
 	var createURL CreateURL
 
-	row := s.db.QueryRowContext(ctx, "SELECT id, user_id, origin_url FROM url WHERE origin_url = $1 AND deleted = false", originURL)
-	err := row.Scan(&createURL.ID, &createURL.User, &createURL.URL)
+	err := scanURL(s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE origin_url = $1 AND deleted = false", originURL), &createURL)
 	if err != nil {
+		span.RecordError(err)
 		return CreateURL{}, err
 	}
 
@@ -62,10 +227,14 @@ func (s *Database) GetOriginURL(ctx context.Context, originURL string) (CreateUR
 }
 
 func (s *Database) GetUser(ctx context.Context, userID string) ([]CreateURL, error) {
+	ctx, span := startDBSpan(ctx, "Database.GetUser")
+	defer span.End()
+
 	rows := make([]CreateURL, 0)
 
-	r, err := s.db.QueryContext(ctx, "SELECT id, user_id, origin_url FROM url WHERE user_id = $1 AND deleted = false", userID)
+	r, err := s.db.QueryContext(ctx, "SELECT "+urlColumns+" FROM url WHERE user_id = $1 AND deleted = false", userID)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 
@@ -73,8 +242,9 @@ func (s *Database) GetUser(ctx context.Context, userID string) ([]CreateURL, err
 
 	for r.Next() {
 		var createURL CreateURL
-		err := r.Scan(&createURL.ID, &createURL.User, &createURL.URL)
+		err := scanURL(r, &createURL)
 		if err != nil {
+			span.RecordError(err)
 			return nil, err
 		}
 
@@ -83,6 +253,7 @@ func (s *Database) GetUser(ctx context.Context, userID string) ([]CreateURL, err
 
 	err = r.Err()
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 
@@ -90,11 +261,15 @@ func (s *Database) GetUser(ctx context.Context, userID string) ([]CreateURL, err
 }
 
 func (s *Database) Set(ctx context.Context, createURL CreateURL) (int, error) {
+	ctx, span := startDBSpan(ctx, "Database.Set")
+	defer span.End()
+
 	var id int
 
-	sqlStatement := "INSERT INTO url (user_id, origin_url) VALUES ($1, $2) RETURNING id"
-	err := s.db.QueryRowContext(ctx, sqlStatement, createURL.User, createURL.URL).Scan(&id)
+	sqlStatement := "INSERT INTO url (user_id, origin_url, redirect, password_hash, max_clicks, rules, variants, query, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id"
+	err := s.db.QueryRowContext(ctx, sqlStatement, createURL.User, createURL.URL, createURL.Redirect, createURL.PasswordHash, createURL.MaxClicks, createURL.Rules, createURL.Variants, createURL.Query, createURL.ExpiresAt).Scan(&id)
 	if err != nil {
+		span.RecordError(err)
 		return id, err
 	}
 
@@ -102,18 +277,26 @@ func (s *Database) Set(ctx context.Context, createURL CreateURL) (int, error) {
 }
 
 func (s *Database) PutBatch(ctx context.Context, shortBatch []ShortenBatch) ([]ShortenBatch, error) {
-	tx, err := s.db.Begin()
+	ctx, span := startDBSpan(ctx, "Database.PutBatch")
+	defer span.End()
+	span.SetAttribute("db.batch_size", len(shortBatch))
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 	defer func() {
 		if err != nil {
-			tx.Rollback()
+			span.RecordError(err)
+			if rbErr := tx.Rollback(); rbErr != nil {
+				logger.FromContext(ctx).Error("rollback batch insert", "error", rbErr)
+			}
 			return
 		}
 	}()
 
-	sqlStatement := "INSERT INTO url (user_id, origin_url) VALUES ($1, $2) RETURNING id"
+	sqlStatement := "INSERT INTO url (user_id, origin_url, redirect, password_hash, max_clicks, rules, variants, query, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id"
 	stmt, err := tx.PrepareContext(ctx, sqlStatement)
 	if err != nil {
 		return nil, err
@@ -121,7 +304,7 @@ func (s *Database) PutBatch(ctx context.Context, shortBatch []ShortenBatch) ([]S
 	defer stmt.Close()
 
 	for id := range shortBatch {
-		err = stmt.QueryRowContext(ctx, shortBatch[id].User, shortBatch[id].URL).Scan(&shortBatch[id].ID)
+		err = stmt.QueryRowContext(ctx, shortBatch[id].User, shortBatch[id].URL, shortBatch[id].Redirect, shortBatch[id].PasswordHash, shortBatch[id].MaxClicks, shortBatch[id].Rules, shortBatch[id].Variants, shortBatch[id].Query, shortBatch[id].ExpiresAt).Scan(&shortBatch[id].ID)
 		if err != nil {
 			return nil, err
 		}
@@ -135,10 +318,24 @@ func (s *Database) PutBatch(ctx context.Context, shortBatch []ShortenBatch) ([]S
 }
 
 func (s *Database) Ping(ctx context.Context) error {
-	return s.db.PingContext(ctx)
+	ctx, span := startDBSpan(ctx, "Database.Ping")
+	defer span.End()
+
+	err := s.db.PingContext(ctx)
+	span.RecordError(err)
+
+	return err
 }
 
 func (s *Database) DeleteURLs(ctx context.Context, ids []int) error {
+	ctx, span := startDBSpan(ctx, "Database.DeleteURLs")
+	defer span.End()
+	span.SetAttribute("db.ids_count", len(ids))
+
+	return s.setDeleted(ctx, span, ids, true)
+}
+
+func (s *Database) setDeleted(ctx context.Context, span *tracing.Span, ids []int, deleted bool) error {
 	var strIds []string
 	for _, id := range ids {
 		strIds = append(strIds, fmt.Sprintf("%d", id))
@@ -148,8 +345,1047 @@ func (s *Database) DeleteURLs(ctx context.Context, ids []int) error {
 		return nil
 	}
 
-	stmt := fmt.Sprintf("UPDATE url set deleted = true WHERE id IN (%s)", strings.Join(strIds, ","))
-	_, err := s.db.Exec(stmt)
+	stmt := fmt.Sprintf("UPDATE url set deleted = $1, deleted_at = CASE WHEN $1 THEN now() END WHERE deleted <> $1 AND id IN (%s)", strings.Join(strIds, ","))
+	_, err := s.db.ExecContext(ctx, stmt, deleted)
+	span.RecordError(err)
+
+	return err
+}
+
+func (s *Database) CountURLs(ctx context.Context) (int, error) {
+	ctx, span := startDBSpan(ctx, "Database.CountURLs")
+	defer span.End()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM url WHERE deleted = false").Scan(&count)
+	span.RecordError(err)
+
+	return count, err
+}
+
+func (s *Database) CountUsers(ctx context.Context) (int, error) {
+	ctx, span := startDBSpan(ctx, "Database.CountUsers")
+	defer span.End()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT count(DISTINCT user_id) FROM url WHERE deleted = false").Scan(&count)
+	span.RecordError(err)
+
+	return count, err
+}
+
+func (s *Database) Lookup(ctx context.Context, id int) (CreateURL, error) {
+	ctx, span := startDBSpan(ctx, "Database.Lookup")
+	defer span.End()
+
+	var createURL CreateURL
+	err := scanURL(s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE id = $1", id), &createURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("id %d: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return CreateURL{}, err
+	}
+
+	return createURL, nil
+}
+
+func (s *Database) LookupOriginURL(ctx context.Context, originURL string) (CreateURL, error) {
+	ctx, span := startDBSpan(ctx, "Database.LookupOriginURL")
+	defer span.End()
+
+	var createURL CreateURL
+	err := scanURL(s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE origin_url = $1", originURL), &createURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = fmt.Errorf("originURL %s: %w", originURL, ErrNotFound)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return CreateURL{}, err
+	}
+
+	return createURL, nil
+}
+
+func (s *Database) LookupUser(ctx context.Context, userID string) ([]CreateURL, error) {
+	ctx, span := startDBSpan(ctx, "Database.LookupUser")
+	defer span.End()
+
+	rows := make([]CreateURL, 0)
+
+	r, err := s.db.QueryContext(ctx, "SELECT "+urlColumns+" FROM url WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	defer r.Close()
+
+	for r.Next() {
+		var createURL CreateURL
+		err := scanURL(r, &createURL)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		rows = append(rows, createURL)
+	}
+
+	err = r.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (s *Database) RestoreURLs(ctx context.Context, ids []int) error {
+	ctx, span := startDBSpan(ctx, "Database.RestoreURLs")
+	defer span.End()
+	span.SetAttribute("db.ids_count", len(ids))
+
+	return s.setDeleted(ctx, span, ids, false)
+}
+
+func (s *Database) AddAudit(ctx context.Context, record AuditRecord) error {
+	ctx, span := startDBSpan(ctx, "Database.AddAudit")
+	defer span.End()
+
+	sqlStatement := "INSERT INTO admin_audit (action, target, remote_addr, request_id) VALUES ($1, $2, $3, $4)"
+	_, err := s.db.ExecContext(ctx, sqlStatement, record.Action, record.Target, record.RemoteAddr, record.RequestID)
+	span.RecordError(err)
+
+	return err
+}
+
+func (s *Database) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	ctx, span := startDBSpan(ctx, "Database.PurgeDeleted")
+	defer span.End()
+
+	// The history of the purged links goes in the same statement.
+	var purged int
+	err := s.db.QueryRowContext(ctx, "WITH purged AS (DELETE FROM url WHERE deleted AND deleted_at < $1 RETURNING id), "+
+		"history AS (DELETE FROM url_history WHERE url_id IN (SELECT id FROM purged)) "+
+		"SELECT count(*) FROM purged", before).Scan(&purged)
+	span.RecordError(err)
+
+	return purged, err
+}
+
+func (s *Database) ListUserURLs(ctx context.Context, query ListQuery) ([]CreateURL, error) {
+	ctx, span := startDBSpan(ctx, "Database.ListUserURLs")
+	defer span.End()
+
+	conditions := []string{"user_id = $1"}
+	args := []interface{}{query.User}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	switch query.Status {
+	case StatusActive:
+		conditions = append(conditions, "deleted = false", "(expires_at IS NULL OR expires_at > now())", "(max_clicks = 0 OR clicks < max_clicks)")
+	case StatusExpired:
+		conditions = append(conditions, "deleted = false", "expires_at <= now()")
+	case StatusExhausted:
+		conditions = append(conditions, "deleted = false", "(expires_at IS NULL OR expires_at > now())", "max_clicks > 0", "clicks >= max_clicks")
+	case StatusDeleted:
+		conditions = append(conditions, "deleted = true")
+	}
+	if query.AfterID > 0 {
+		if query.Desc {
+			where("id < $%d", query.AfterID)
+		} else {
+			where("id > $%d", query.AfterID)
+		}
+	}
+	if !query.CreatedFrom.IsZero() {
+		where("created_at >= $%d", query.CreatedFrom)
+	}
+	if !query.CreatedTo.IsZero() {
+		where("created_at < $%d", query.CreatedTo)
+	}
+	if query.Contains != "" {
+		where(`origin_url ILIKE '%%' || $%d || '%%'`, likeEscaper.Replace(query.Contains))
+	}
+
+	order := "ASC"
+	if query.Desc {
+		order = "DESC"
+	}
+	stmt := fmt.Sprintf("SELECT %s FROM url WHERE %s ORDER BY id %s", urlColumns, strings.Join(conditions, " AND "), order)
+	if query.Limit > 0 {
+		args = append(args, query.Limit)
+		stmt += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	r, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	defer r.Close()
+
+	rows := make([]CreateURL, 0)
+	for r.Next() {
+		var createURL CreateURL
+		err := scanURL(r, &createURL)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		rows = append(rows, createURL)
+	}
+
+	err = r.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// AddClick counts a click on the link and, when variant is set, on that
+// variant of a split link. It returns the clicks the link has now.
+func (s *Database) AddClick(ctx context.Context, id int, variant string) (int, error) {
+	ctx, span := startDBSpan(ctx, "Database.AddClick")
+	defer span.End()
+
+	// The limit is checked in the same statement, so concurrent clicks on
+	// a one-time link cannot both get through. The statement also tells a
+	// spent link from one deleted or purged since it was looked up.
+	var clicks sql.NullInt64
+	var found bool
+	err := s.db.QueryRowContext(ctx, "WITH link AS (SELECT id FROM url WHERE id = $1 AND deleted = false), "+
+		"updated AS (UPDATE url SET clicks = clicks + 1, "+
+		"variant_clicks = CASE WHEN $2 = '' THEN variant_clicks ELSE jsonb_set(variant_clicks, ARRAY[$2], to_jsonb(COALESCE((variant_clicks->>$2)::bigint, 0) + 1)) END "+
+		"WHERE id = $1 AND deleted = false AND (max_clicks = 0 OR clicks < max_clicks) RETURNING clicks) "+
+		"SELECT (SELECT clicks FROM updated), EXISTS (SELECT 1 FROM link)", id, variant).Scan(&clicks, &found)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+	if !found {
+		return 0, ErrNotFound
+	}
+	if !clicks.Valid {
+		return 0, ErrExhausted
+	}
+
+	return int(clicks.Int64), nil
+}
+
+// UpdateURL changes the destination, redirect kind, expiry, rules,
+// variants and query options of a link.
+// A replaced destination is kept in url_history.
+func (s *Database) UpdateURL(ctx context.Context, createURL CreateURL) error {
+	ctx, span := startDBSpan(ctx, "Database.UpdateURL")
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			if rbErr := tx.Rollback(); rbErr != nil {
+				logger.FromContext(ctx).Error("rollback url update", "error", rbErr)
+			}
+		}
+	}()
+
+	var current string
+	err = tx.QueryRowContext(ctx, "SELECT origin_url FROM url WHERE id = $1 FOR UPDATE", createURL.ID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if current != createURL.URL {
+		_, err = tx.ExecContext(ctx, "INSERT INTO url_history (url_id, origin_url) VALUES ($1, $2)", createURL.ID, current)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE url SET origin_url = $2, redirect = $3, expiry_notified = expiry_notified AND expires_at IS NOT DISTINCT FROM $4, expires_at = $4, rules = $5, variants = $6, query = $7 WHERE id = $1", createURL.ID, createURL.URL, createURL.Redirect, createURL.ExpiresAt, createURL.Rules, createURL.Variants, createURL.Query)
+	var pge *pgconn.PgError
+	if errors.As(err, &pge) && pge.Code == pgerrcode.UniqueViolation {
+		err = ErrDuplicateURL
+	}
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
 
 	return err
 }
+
+// URLHistory returns the previous destinations of a link, oldest first.
+func (s *Database) URLHistory(ctx context.Context, id int) ([]URLVersion, error) {
+	ctx, span := startDBSpan(ctx, "Database.URLHistory")
+	defer span.End()
+
+	r, err := s.db.QueryContext(ctx, "SELECT origin_url, replaced_at FROM url_history WHERE url_id = $1 ORDER BY id", id)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	defer r.Close()
+
+	versions := make([]URLVersion, 0)
+	for r.Next() {
+		version := URLVersion{Version: len(versions) + 1}
+		err := r.Scan(&version.OriginalURL, &version.ReplacedAt)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		versions = append(versions, version)
+	}
+
+	err = r.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// Records returns up to limit rows with an id above afterID, deleted ones
+// included, in id order, with their url_history. It is meant for copying
+// the table elsewhere.
+func (s *Database) Records(ctx context.Context, afterID, limit int) ([]CreateURL, error) {
+	ctx, span := startDBSpan(ctx, "Database.Records")
+	defer span.End()
+
+	r, err := s.db.QueryContext(ctx, "SELECT "+urlColumns+" FROM url WHERE id > $1 ORDER BY id LIMIT $2", afterID, limit)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	defer r.Close()
+
+	rows := make([]CreateURL, 0, limit)
+	for r.Next() {
+		var createURL CreateURL
+		err := scanURL(r, &createURL)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		rows = append(rows, createURL)
+	}
+
+	err = r.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return rows, nil
+	}
+
+	err = s.recordsHistory(ctx, afterID, rows)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// recordsHistory fills History of rows, a page returned by Records.
+func (s *Database) recordsHistory(ctx context.Context, afterID int, rows []CreateURL) error {
+	r, err := s.db.QueryContext(ctx, "SELECT url_id, origin_url, replaced_at FROM url_history WHERE url_id > $1 AND url_id <= $2 ORDER BY url_id, id", afterID, rows[len(rows)-1].ID)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	index := make(map[int]int, len(rows))
+	for i, row := range rows {
+		index[row.ID] = i
+	}
+
+	for r.Next() {
+		var id int
+		var version URLVersion
+		err := r.Scan(&id, &version.OriginalURL, &version.ReplacedAt)
+		if err != nil {
+			return err
+		}
+
+		i, ok := index[id]
+		if !ok {
+			continue
+		}
+		version.Version = len(rows[i].History) + 1
+		rows[i].History = append(rows[i].History, version)
+	}
+
+	return r.Err()
+}
+
+// ImportRecords writes records with their ids and history as given. Rows
+// that already exist are overwritten, so importing the same records twice
+// is harmless.
+func (s *Database) ImportRecords(ctx context.Context, records []CreateURL) error {
+	ctx, span := startDBSpan(ctx, "Database.ImportRecords")
+	defer span.End()
+	span.SetAttribute("db.batch_size", len(records))
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			if rbErr := tx.Rollback(); rbErr != nil {
+				logger.FromContext(ctx).Error("rollback records import", "error", rbErr)
+			}
+		}
+	}()
+
+	sqlStatement := "INSERT INTO url (id, user_id, origin_url, deleted, deleted_at, created_at, redirect, clicks, password_hash, max_clicks, expires_at, rules, variants, variant_clicks, query, expiry_notified) VALUES ($1, $2, $3, $4, $5, COALESCE($6, now()), $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) " +
+		"ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, origin_url = excluded.origin_url, deleted = excluded.deleted, deleted_at = excluded.deleted_at, created_at = excluded.created_at, redirect = excluded.redirect, clicks = excluded.clicks, password_hash = excluded.password_hash, max_clicks = excluded.max_clicks, expires_at = excluded.expires_at, rules = excluded.rules, variants = excluded.variants, variant_clicks = excluded.variant_clicks, query = excluded.query, expiry_notified = excluded.expiry_notified"
+	stmt, err := tx.PrepareContext(ctx, sqlStatement)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, record := range records {
+		createdAt := sql.NullTime{Time: record.CreatedAt, Valid: !record.CreatedAt.IsZero()}
+		_, err = stmt.ExecContext(ctx, record.ID, record.User, record.URL, record.Deleted, record.DeletedAt, createdAt, record.Redirect, record.Clicks, record.PasswordHash, record.MaxClicks, record.ExpiresAt, record.Rules, record.Variants, record.VariantClicks, record.Query, record.ExpiryNotified)
+		if err != nil {
+			return fmt.Errorf("record %d: %w", record.ID, err)
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM url_history WHERE url_id = $1", record.ID)
+		if err != nil {
+			return fmt.Errorf("record %d: %w", record.ID, err)
+		}
+		for _, version := range record.History {
+			_, err = tx.ExecContext(ctx, "INSERT INTO url_history (url_id, origin_url, replaced_at) VALUES ($1, $2, $3)", record.ID, version.OriginalURL, version.ReplacedAt)
+			if err != nil {
+				return fmt.Errorf("record %d: %w", record.ID, err)
+			}
+		}
+	}
+
+	err = tx.Commit()
+
+	return err
+}
+
+func (s *Database) CountRecords(ctx context.Context) (int, int, error) {
+	ctx, span := startDBSpan(ctx, "Database.CountRecords")
+	defer span.End()
+
+	var total, deleted int
+	err := s.db.QueryRowContext(ctx, "SELECT count(*), count(*) FILTER (WHERE deleted) FROM url").Scan(&total, &deleted)
+	span.RecordError(err)
+
+	return total, deleted, err
+}
+
+// ResetSequence moves the id sequence past the highest stored id, so rows
+// inserted with explicit ids do not collide with later inserts.
+func (s *Database) ResetSequence(ctx context.Context) error {
+	ctx, span := startDBSpan(ctx, "Database.ResetSequence")
+	defer span.End()
+
+	_, err := s.db.ExecContext(ctx, "SELECT setval(pg_get_serial_sequence('url', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM url")
+	span.RecordError(err)
+
+	return err
+}
+
+// deliveryColumns lists the webhook_delivery columns in the order
+// scanDelivery reads them.
+const deliveryColumns = "id, webhook_id, user_id, event, payload, status, attempts, next_attempt_at, last_status, last_error, created_at, delivered_at"
+
+func scanDelivery(row rowScanner, delivery *Delivery, extra ...interface{}) error {
+	var payload string
+	dest := []interface{}{&delivery.ID, &delivery.WebhookID, &delivery.User, &delivery.Event, &payload, &delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastStatus, &delivery.LastError, &delivery.CreatedAt, &delivery.DeliveredAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	delivery.Payload = []byte(payload)
+
+	return nil
+}
+
+func (s *Database) AddWebhook(ctx context.Context, webhook Webhook) (int, error) {
+	ctx, span := startDBSpan(ctx, "Database.AddWebhook")
+	defer span.End()
+
+	var id int
+	sqlStatement := "INSERT INTO webhook (user_id, url, secret, events, click_threshold) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	err := s.db.QueryRowContext(ctx, sqlStatement, webhook.User, webhook.URL, webhook.Secret, strings.Join(webhook.Events, ","), webhook.ClickThreshold).Scan(&id)
+	span.RecordError(err)
+
+	return id, err
+}
+
+func (s *Database) UserWebhooks(ctx context.Context, userID string) ([]Webhook, error) {
+	ctx, span := startDBSpan(ctx, "Database.UserWebhooks")
+	defer span.End()
+
+	r, err := s.db.QueryContext(ctx, "SELECT id, user_id, url, secret, events, click_threshold, created_at FROM webhook WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	defer r.Close()
+
+	webhooks := make([]Webhook, 0)
+	for r.Next() {
+		var (
+			webhook Webhook
+			events  string
+		)
+		err := r.Scan(&webhook.ID, &webhook.User, &webhook.URL, &webhook.Secret, &events, &webhook.ClickThreshold, &webhook.CreatedAt)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		webhook.Events = strings.Split(events, ",")
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	err = r.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook removes one of the user's webhooks together with its
+// deliveries.
+func (s *Database) DeleteWebhook(ctx context.Context, userID string, id int) error {
+	ctx, span := startDBSpan(ctx, "Database.DeleteWebhook")
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM webhook WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// EnqueueEvent queues a delivery for every webhook that wants the event
+// and returns how many were queued.
+func (s *Database) EnqueueEvent(ctx context.Context, event WebhookEvent) (int, error) {
+	ctx, span := startDBSpan(ctx, "Database.EnqueueEvent")
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, "INSERT INTO webhook_delivery (webhook_id, user_id, event, payload) "+
+		"SELECT id, user_id, $2, $4 FROM webhook WHERE user_id = $1 AND $2 = ANY(string_to_array(events, ',')) AND ($2 <> $5 OR click_threshold = $3)",
+		event.User, event.Type, event.Clicks, string(event.Payload), EventClickThreshold)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	queued, err := result.RowsAffected()
+	span.RecordError(err)
+
+	return int(queued), err
+}
+
+// ClaimDeliveries returns up to limit pending deliveries that are due and
+// moves their next attempt lease into the future, so other instances skip
+// them while they are being sent.
+func (s *Database) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	ctx, span := startDBSpan(ctx, "Database.ClaimDeliveries")
+	defer span.End()
+
+	stmt := "WITH due AS (SELECT id FROM webhook_delivery WHERE status = $1 AND next_attempt_at <= $2 ORDER BY next_attempt_at, id LIMIT $4 FOR UPDATE SKIP LOCKED), " +
+		"claimed AS (UPDATE webhook_delivery d SET next_attempt_at = $3 FROM due WHERE d.id = due.id RETURNING d.*) " +
+		"SELECT claimed.id, claimed.webhook_id, claimed.user_id, claimed.event, claimed.payload, claimed.status, claimed.attempts, claimed.next_attempt_at, claimed.last_status, claimed.last_error, claimed.created_at, claimed.delivered_at, webhook.url, webhook.secret " +
+		"FROM claimed JOIN webhook ON webhook.id = claimed.webhook_id ORDER BY claimed.id"
+	r, err := s.db.QueryContext(ctx, stmt, DeliveryPending, now, now.Add(lease), limit)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	defer r.Close()
+
+	deliveries := make([]Delivery, 0, limit)
+	for r.Next() {
+		var delivery Delivery
+		err := scanDelivery(r, &delivery, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	err = r.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// UpdateDelivery stores the outcome of a delivery attempt.
+func (s *Database) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	ctx, span := startDBSpan(ctx, "Database.UpdateDelivery")
+	defer span.End()
+
+	sqlStatement := "UPDATE webhook_delivery SET status = $2, attempts = $3, next_attempt_at = $4, last_status = $5, last_error = $6, delivered_at = $7 WHERE id = $1"
+	_, err := s.db.ExecContext(ctx, sqlStatement, delivery.ID, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastStatus, delivery.LastError, delivery.DeliveredAt)
+	span.RecordError(err)
+
+	return err
+}
+
+func (s *Database) ListDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error) {
+	ctx, span := startDBSpan(ctx, "Database.ListDeliveries")
+	defer span.End()
+
+	conditions := []string{"user_id = $1"}
+	args := []interface{}{query.User}
+	if query.WebhookID > 0 {
+		args = append(args, query.WebhookID)
+		conditions = append(conditions, fmt.Sprintf("webhook_id = $%d", len(args)))
+	}
+	if query.Status != "" {
+		args = append(args, query.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	stmt := fmt.Sprintf("SELECT %s FROM webhook_delivery WHERE %s ORDER BY id DESC", deliveryColumns, strings.Join(conditions, " AND "))
+	if query.Limit > 0 {
+		args = append(args, query.Limit)
+		stmt += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	r, err := s.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	defer r.Close()
+
+	deliveries := make([]Delivery, 0)
+	for r.Next() {
+		var delivery Delivery
+		err := scanDelivery(r, &delivery)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	err = r.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RetryDelivery puts one of the user's dead deliveries back in the queue
+// with a fresh set of attempts.
+func (s *Database) RetryDelivery(ctx context.Context, userID string, id int) error {
+	ctx, span := startDBSpan(ctx, "Database.RetryDelivery")
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, "UPDATE webhook_delivery SET status = $3, attempts = 0, next_attempt_at = now() WHERE id = $1 AND user_id = $2 AND status = $4", id, userID, DeliveryPending, DeliveryDead)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// PruneDeliveries removes delivered and dead deliveries created before
+// the given time. Pending ones are kept however old they are.
+func (s *Database) PruneDeliveries(ctx context.Context, before time.Time) (int, error) {
+	ctx, span := startDBSpan(ctx, "Database.PruneDeliveries")
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM webhook_delivery WHERE status <> $1 AND created_at < $2", DeliveryPending, before)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	return int(pruned), nil
+}
+
+// MarkExpired flags links that expired by now and were not reported yet,
+// and returns them.
+func (s *Database) MarkExpired(ctx context.Context, now time.Time) ([]CreateURL, error) {
+	ctx, span := startDBSpan(ctx, "Database.MarkExpired")
+	defer span.End()
+
+	r, err := s.db.QueryContext(ctx, "UPDATE url SET expiry_notified = true WHERE expires_at <= $1 AND NOT expiry_notified AND NOT deleted RETURNING "+urlColumns, now)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	defer r.Close()
+
+	rows := make([]CreateURL, 0)
+	for r.Next() {
+		var createURL CreateURL
+		err := scanURL(r, &createURL)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		rows = append(rows, createURL)
+	}
+
+	err = r.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pge *pgconn.PgError
+	return errors.As(err, &pge) && pge.Code == pgerrcode.UniqueViolation
+}
+
+// collectionColumns lists the collection columns in the order
+// scanCollection reads them.
+const collectionColumns = "id, user_id, slug, title, description, clicks, link_clicks, created_at, updated_at"
+
+func scanCollection(row rowScanner, collection *Collection) error {
+	return row.Scan(&collection.ID, &collection.User, &collection.Slug, &collection.Title, &collection.Description, &collection.Clicks, &collection.LinkClicks, &collection.CreatedAt, &collection.UpdatedAt)
+}
+
+func insertCollectionLinks(ctx context.Context, tx *sql.Tx, id int, links []CollectionItem) error {
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO collection_link (collection_id, url_id, position, title) VALUES ($1, $2, $3, $4)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for position, link := range links {
+		if _, err := stmt.ExecContext(ctx, id, link.ID, position, link.Title); err != nil {
+			return fmt.Errorf("link %d: %w", link.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Database) AddCollection(ctx context.Context, collection Collection) (int, error) {
+	ctx, span := startDBSpan(ctx, "Database.AddCollection")
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			if rbErr := tx.Rollback(); rbErr != nil {
+				logger.FromContext(ctx).Error("rollback collection insert", "error", rbErr)
+			}
+		}
+	}()
+
+	var id int
+	err = tx.QueryRowContext(ctx, "INSERT INTO collection (user_id, slug, title, description) VALUES ($1, $2, $3, $4) RETURNING id",
+		collection.User, collection.Slug, collection.Title, collection.Description).Scan(&id)
+	if isUniqueViolation(err) {
+		err = ErrDuplicateSlug
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err = insertCollectionLinks(ctx, tx, id, collection.Links); err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+
+	return id, err
+}
+
+// UpdateCollection changes the slug, title and description of a
+// collection and replaces its links.
+func (s *Database) UpdateCollection(ctx context.Context, collection Collection) error {
+	ctx, span := startDBSpan(ctx, "Database.UpdateCollection")
+	defer span.End()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			if rbErr := tx.Rollback(); rbErr != nil {
+				logger.FromContext(ctx).Error("rollback collection update", "error", rbErr)
+			}
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, "UPDATE collection SET slug = $2, title = $3, description = $4, updated_at = now() WHERE id = $1",
+		collection.ID, collection.Slug, collection.Title, collection.Description)
+	if isUniqueViolation(err) {
+		err = ErrDuplicateSlug
+	}
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		err = ErrNotFound
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM collection_link WHERE collection_id = $1", collection.ID); err != nil {
+		return err
+	}
+	if err = insertCollectionLinks(ctx, tx, collection.ID, collection.Links); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+
+	return err
+}
+
+func (s *Database) DeleteCollection(ctx context.Context, userID string, id int) error {
+	ctx, span := startDBSpan(ctx, "Database.DeleteCollection")
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM collection WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Database) UserCollections(ctx context.Context, userID string) ([]Collection, error) {
+	ctx, span := startDBSpan(ctx, "Database.UserCollections")
+	defer span.End()
+
+	r, err := s.db.QueryContext(ctx, "SELECT "+collectionColumns+" FROM collection WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	defer r.Close()
+
+	collections := make([]Collection, 0)
+	positions := make(map[int]int)
+	for r.Next() {
+		var collection Collection
+		err := scanCollection(r, &collection)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+		collection.Links = make([]CollectionItem, 0)
+
+		positions[collection.ID] = len(collections)
+		collections = append(collections, collection)
+	}
+
+	err = r.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	links, err := s.db.QueryContext(ctx, "SELECT l.collection_id, l.url_id, l.title FROM collection_link l JOIN collection c ON c.id = l.collection_id WHERE c.user_id = $1 ORDER BY l.collection_id, l.position", userID)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	defer links.Close()
+
+	for links.Next() {
+		var (
+			id   int
+			link CollectionItem
+		)
+		err := links.Scan(&id, &link.ID, &link.Title)
+		if err != nil {
+			span.RecordError(err)
+			return nil, err
+		}
+
+		if i, ok := positions[id]; ok {
+			collections[i].Links = append(collections[i].Links, link)
+		}
+	}
+
+	err = links.Err()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+func (s *Database) LookupCollection(ctx context.Context, id int) (Collection, error) {
+	ctx, span := startDBSpan(ctx, "Database.LookupCollection")
+	defer span.End()
+
+	collection, err := s.collection(ctx, "id = $1", id)
+	span.RecordError(err)
+
+	return collection, err
+}
+
+func (s *Database) GetCollection(ctx context.Context, slug string) (Collection, error) {
+	ctx, span := startDBSpan(ctx, "Database.GetCollection")
+	defer span.End()
+
+	collection, err := s.collection(ctx, "slug = $1", slug)
+	span.RecordError(err)
+
+	return collection, err
+}
+
+func (s *Database) collection(ctx context.Context, where string, arg interface{}) (Collection, error) {
+	var collection Collection
+	err := scanCollection(s.db.QueryRowContext(ctx, "SELECT "+collectionColumns+" FROM collection WHERE "+where, arg), &collection)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Collection{}, ErrNotFound
+	}
+	if err != nil {
+		return Collection{}, err
+	}
+
+	r, err := s.db.QueryContext(ctx, "SELECT url_id, title FROM collection_link WHERE collection_id = $1 ORDER BY position", collection.ID)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	defer r.Close()
+
+	collection.Links = make([]CollectionItem, 0)
+	for r.Next() {
+		var link CollectionItem
+		if err := r.Scan(&link.ID, &link.Title); err != nil {
+			return Collection{}, err
+		}
+
+		collection.Links = append(collection.Links, link)
+	}
+
+	return collection, r.Err()
+}
+
+// AddCollectionClick counts a link followed from the collection page.
+func (s *Database) AddCollectionClick(ctx context.Context, id, linkID int) error {
+	ctx, span := startDBSpan(ctx, "Database.AddCollectionClick")
+	defer span.End()
+
+	result, err := s.db.ExecContext(ctx, "UPDATE collection SET clicks = clicks + 1, "+
+		"link_clicks = jsonb_set(link_clicks, ARRAY[$2], to_jsonb(COALESCE((link_clicks->>$2)::bigint, 0) + 1)) WHERE id = $1", id, strconv.Itoa(linkID))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
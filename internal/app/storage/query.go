@@ -0,0 +1,40 @@
+package storage
+
+import "database/sql/driver"
+
+// How a link copies the query string of the short url into the
+// destination.
+const (
+	PassthroughNone = "none"
+	PassthroughUTM  = "utm"
+	PassthroughAll  = "all"
+)
+
+func ValidPassthrough(mode string) bool {
+	switch mode {
+	case PassthroughNone, PassthroughUTM, PassthroughAll:
+		return true
+	}
+
+	return false
+}
+
+// QueryOptions control the query of the destination a link redirects to.
+// Template parameters are added when the destination lacks them; incoming
+// parameters are copied as Passthrough allows, except the Fixed ones and
+// those already in the destination.
+type QueryOptions struct {
+	Passthrough string            `json:"passthrough,omitempty"`
+	Template    map[string]string `json:"template,omitempty"`
+	Fixed       []string          `json:"fixed,omitempty"`
+}
+
+func (q QueryOptions) Value() (driver.Value, error) {
+	return jsonValue(q)
+}
+
+func (q *QueryOptions) Scan(src interface{}) error {
+	*q = QueryOptions{}
+
+	return scanJSON(src, q)
+}
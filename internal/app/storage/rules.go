@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Platforms a routing rule can match on.
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformWindows = "windows"
+	PlatformMacOS   = "macos"
+	PlatformLinux   = "linux"
+)
+
+// Rule sends matching visitors to URL instead of the link destination.
+// Every condition that is set has to match.
+type Rule struct {
+	Platform string `json:"platform,omitempty"`
+	Language string `json:"language,omitempty"`
+	Country  string `json:"country,omitempty"`
+	URL      string `json:"url"`
+}
+
+// Rules are tried in order; the first match wins.
+type Rules []Rule
+
+func (r Rules) Value() (driver.Value, error) {
+	if len(r) == 0 {
+		return "[]", nil
+	}
+
+	return jsonValue(r)
+}
+
+func (r *Rules) Scan(src interface{}) error {
+	var rules Rules
+	if err := scanJSON(src, &rules); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		rules = nil
+	}
+	*r = rules
+
+	return nil
+}
+
+// jsonValue and scanJSON store a value in a jsonb column.
+func jsonValue(v interface{}) (driver.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func scanJSON(src interface{}, dst interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(src, dst)
+	case string:
+		return json.Unmarshal([]byte(src), dst)
+	default:
+		return fmt.Errorf("cannot scan %T into %T", src, dst)
+	}
+}
@@ -7,18 +7,82 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
 )
 
 type Models struct {
-	Counter int
-	Model   map[int]CreateURL
-	File    *os.File
-	ticker  *time.Ticker
-	done    chan bool
+	Counter     int
+	Model       map[int]CreateURL
+	File        *os.File
+	Audit       []AuditRecord
+	Webhooks    []Webhook
+	Deliveries  []Delivery
+	Collections []Collection
+	auditFile   *os.File
+	webhookFile *os.File
+	// collectionFile holds Collections, rewritten whole like webhookFile.
+	collectionFile *os.File
+	// counterFile keeps Counter and the next ids of the other tables, so
+	// the ids of removed records are not handed out again.
+	counterFile    *os.File
+	nextWebhookID  int
+	nextDeliveryID int
+	// nextCollectionID keeps the id of a deleted collection from being
+	// given to a new one behind the same public link.
+	nextCollectionID int
+	nextAuditID      int
+	mu               sync.RWMutex
+	// fileMu serializes rewrites of the files; mu is only read-locked
+	// while they run.
+	fileMu sync.Mutex
+	ticker *time.Ticker
+	done   chan bool
+}
+
+// idCounters is the content of the counter file.
+type idCounters struct {
+	URL        int
+	Webhook    int `json:",omitempty"`
+	Delivery   int `json:",omitempty"`
+	Collection int `json:",omitempty"`
+	Audit      int `json:",omitempty"`
+}
+
+func (c *idCounters) UnmarshalJSON(data []byte) error {
+	// Older files hold only the url counter.
+	if err := json.Unmarshal(data, &c.URL); err == nil {
+		return nil
+	}
+
+	type plain idCounters
+	return json.Unmarshal(data, (*plain)(c))
 }
 
-func NewModels(filename string, syncTime int) (*Models, error) {
+// nextID hands out the id after both the stored counter and the last id
+// in use, and moves the counter past it.
+func nextID(counter *int, lastID int) int {
+	id := *counter
+	if id <= lastID {
+		id = lastID + 1
+	}
+	*counter = id + 1
+
+	return id
+}
+
+// webhookState is the content of the webhooks file. The file is rewritten
+// whole on every sync, so queued deliveries survive a restart.
+type webhookState struct {
+	Webhooks   []Webhook
+	Deliveries []Delivery
+}
+
+func NewModels(filename string, syncInterval time.Duration) (*Models, error) {
 	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0777)
 
 	if err != nil {
@@ -30,14 +94,72 @@ func NewModels(filename string, syncTime int) (*Models, error) {
 		return nil, err
 	}
 
-	ticker := time.NewTicker(time.Duration(syncTime) * time.Minute)
+	auditFile, err := os.OpenFile(filename+".audit", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := readAudit(auditFile)
+	if err != nil {
+		return nil, err
+	}
+
+	webhookFile, err := os.OpenFile(filename+".webhooks", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	var state webhookState
+	if err := readState(webhookFile, &state); err != nil {
+		return nil, err
+	}
+
+	collectionFile, err := os.OpenFile(filename+".collections", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []Collection
+	if err := readState(collectionFile, &collections); err != nil {
+		return nil, err
+	}
+
+	counterFile, err := os.OpenFile(filename+".counter", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := lastID + 1
+	var stored idCounters
+	if err := readState(counterFile, &stored); err != nil {
+		return nil, err
+	}
+	if stored.URL > counter {
+		counter = stored.URL
+	}
+
+	ticker := time.NewTicker(syncInterval)
 	done := make(chan bool)
 	simpleStorage := &Models{
-		Counter: lastID + 1,
-		Model:   model,
-		File:    file,
-		ticker:  ticker,
-		done:    done,
+		Counter:     counter,
+		Model:       model,
+		File:        file,
+		Webhooks:    state.Webhooks,
+		Deliveries:  state.Deliveries,
+		Audit:       audit,
+		Collections: collections,
+		auditFile:   auditFile,
+		webhookFile: webhookFile,
+		ticker:      ticker,
+		done:        done,
+
+		collectionFile: collectionFile,
+		counterFile:    counterFile,
+		nextWebhookID:  stored.Webhook,
+		nextDeliveryID: stored.Delivery,
+
+		nextCollectionID: stored.Collection,
+		nextAuditID:      stored.Audit,
 	}
 
 	go simpleStorage.synchronize()
@@ -76,6 +198,7 @@ func (md *Models) synchronize() {
 		case <-md.ticker.C:
 			err := md.updateDataFile()
 			if err != nil {
+				logger.Default().Error("synchronize storage file", "file", md.File.Name(), "error", err)
 				return
 			}
 		}
@@ -83,40 +206,76 @@ func (md *Models) synchronize() {
 }
 
 func (md *Models) Get(ctx context.Context, id int) (CreateURL, error) {
+	_, span := startFileSpan(ctx, "Models.Get")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
 	if createURL, ok := md.Model[id]; ok {
+		if createURL.Deleted {
+			return CreateURL{}, ErrDeleted
+		}
 		return createURL, nil
 	}
 
-	return CreateURL{}, fmt.Errorf("id %d have not found", id)
+	err := fmt.Errorf("id %d have not found", id)
+	span.RecordError(err)
+
+	return CreateURL{}, err
 }
 
 func (md *Models) GetOriginURL(ctx context.Context, originURL string) (CreateURL, error) {
+	_, span := startFileSpan(ctx, "Models.GetOriginURL")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
 	for _, createURL := range md.Model {
-		if createURL.URL == originURL {
+		if createURL.URL == originURL && !createURL.Deleted {
 			return createURL, nil
 		}
 	}
 
-	return CreateURL{}, fmt.Errorf("originURL %s have not found", originURL)
+	err := fmt.Errorf("originURL %s have not found", originURL)
+	span.RecordError(err)
+
+	return CreateURL{}, err
 }
 
 func (md *Models) GetUser(ctx context.Context, userID string) ([]CreateURL, error) {
+	_, span := startFileSpan(ctx, "Models.GetUser")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
 	model := make([]CreateURL, 0)
 
 	for _, value := range md.Model {
-		if value.User == userID {
+		if value.User == userID && !value.Deleted {
 			model = append(model, value)
 		}
 	}
 
 	if len(model) == 0 {
-		return nil, fmt.Errorf("model with user_id %s have not found", userID)
+		err := fmt.Errorf("model with user_id %s have not found", userID)
+		span.RecordError(err)
+		return nil, err
 	}
 
 	return model, nil
 }
 
 func (md *Models) Set(ctx context.Context, createURL CreateURL) (int, error) {
+	_, span := startFileSpan(ctx, "Models.Set")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	createURL.CreatedAt = time.Now().UTC()
 	createURL.ID = md.Counter
 	md.Counter++
 
@@ -134,10 +293,40 @@ func (md *Models) Close() error {
 		return err
 	}
 
+	if md.auditFile != nil {
+		if err := md.auditFile.Close(); err != nil {
+			return err
+		}
+	}
+
+	if md.webhookFile != nil {
+		if err := md.webhookFile.Close(); err != nil {
+			return err
+		}
+	}
+
+	if md.collectionFile != nil {
+		if err := md.collectionFile.Close(); err != nil {
+			return err
+		}
+	}
+
+	if md.counterFile != nil {
+		if err := md.counterFile.Close(); err != nil {
+			return err
+		}
+	}
+
 	return md.File.Close()
 }
 
 func (md *Models) updateDataFile() error {
+	md.fileMu.Lock()
+	defer md.fileMu.Unlock()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
 	err := md.File.Truncate(0)
 	if err != nil {
 		return err
@@ -156,7 +345,70 @@ func (md *Models) updateDataFile() error {
 		}
 	}
 
-	return nil
+	if err := writeState(md.webhookFile, webhookState{Webhooks: md.Webhooks, Deliveries: md.Deliveries}); err != nil {
+		return err
+	}
+
+	if err := writeState(md.collectionFile, md.Collections); err != nil {
+		return err
+	}
+
+	return writeState(md.counterFile, idCounters{
+		URL:        md.Counter,
+		Webhook:    md.nextWebhookID,
+		Delivery:   md.nextDeliveryID,
+		Collection: md.nextCollectionID,
+		Audit:      md.nextAuditID,
+	})
+}
+
+// readAudit loads the audit log written by earlier runs, so record ids
+// keep counting from the last one even if the counter file lags behind.
+func readAudit(file *os.File) ([]AuditRecord, error) {
+	var audit []AuditRecord
+	decoder := json.NewDecoder(file)
+	for {
+		var record AuditRecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return audit, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read audit log: %w", err)
+		}
+		audit = append(audit, record)
+	}
+}
+
+func readState(file *os.File, state interface{}) error {
+	data, err := io.ReadAll(file)
+	if err != nil || len(data) == 0 {
+		return err
+	}
+
+	return json.Unmarshal(data, state)
+}
+
+func writeState(file *os.File, state interface{}) error {
+	if file == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+
+	return err
 }
 
 func (md *Models) writeToFile(createURL CreateURL) error {
@@ -173,13 +425,786 @@ func (md *Models) writeToFile(createURL CreateURL) error {
 }
 
 func (md *Models) PutBatch(ctx context.Context, shortBatch []ShortenBatch) ([]ShortenBatch, error) {
-	return nil, fmt.Errorf("method has not implemented")
+	_, span := startFileSpan(ctx, "Models.PutBatch")
+	defer span.End()
+	span.SetAttribute("db.batch_size", len(shortBatch))
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	now := time.Now().UTC()
+	for i := range shortBatch {
+		shortBatch[i].ID = md.Counter
+		md.Counter++
+
+		md.Model[shortBatch[i].ID] = CreateURL{
+			ID:           shortBatch[i].ID,
+			User:         shortBatch[i].User,
+			URL:          shortBatch[i].URL,
+			CreatedAt:    now,
+			Redirect:     shortBatch[i].Redirect,
+			PasswordHash: shortBatch[i].PasswordHash,
+			MaxClicks:    shortBatch[i].MaxClicks,
+			Rules:        shortBatch[i].Rules,
+			Variants:     shortBatch[i].Variants,
+			Query:        shortBatch[i].Query,
+			ExpiresAt:    shortBatch[i].ExpiresAt,
+		}
+	}
+
+	return shortBatch, nil
 }
 
 func (md *Models) Ping(ctx context.Context) error {
-	return fmt.Errorf("method has not implemented")
+	_, span := startFileSpan(ctx, "Models.Ping")
+	defer span.End()
+
+	if md.File == nil {
+		return fmt.Errorf("storage file is not opened")
+	}
+
+	_, err := md.File.Stat()
+	return err
+}
+
+func (md *Models) DeleteURLs(ctx context.Context, ids []int) error {
+	_, span := startFileSpan(ctx, "Models.DeleteURLs")
+	defer span.End()
+
+	md.setDeleted(ids, true)
+
+	return nil
+}
+
+func (md *Models) setDeleted(ids []int, deleted bool) {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	now := time.Now().UTC()
+	for _, id := range ids {
+		createURL, ok := md.Model[id]
+		if !ok || createURL.Deleted == deleted {
+			continue
+		}
+
+		createURL.Deleted = deleted
+		createURL.DeletedAt = nil
+		if deleted {
+			createURL.DeletedAt = &now
+		}
+		md.Model[id] = createURL
+	}
+}
+
+func (md *Models) CountURLs(ctx context.Context) (int, error) {
+	_, span := startFileSpan(ctx, "Models.CountURLs")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	var count int
+	for _, createURL := range md.Model {
+		if !createURL.Deleted {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (md *Models) CountUsers(ctx context.Context) (int, error) {
+	_, span := startFileSpan(ctx, "Models.CountUsers")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	users := make(map[string]struct{})
+	for _, createURL := range md.Model {
+		if createURL.Deleted {
+			continue
+		}
+		users[createURL.User] = struct{}{}
+	}
+
+	return len(users), nil
+}
+
+func (md *Models) Lookup(ctx context.Context, id int) (CreateURL, error) {
+	_, span := startFileSpan(ctx, "Models.Lookup")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	if createURL, ok := md.Model[id]; ok {
+		return createURL, nil
+	}
+
+	err := fmt.Errorf("id %d: %w", id, ErrNotFound)
+	span.RecordError(err)
+
+	return CreateURL{}, err
+}
+
+func (md *Models) LookupOriginURL(ctx context.Context, originURL string) (CreateURL, error) {
+	_, span := startFileSpan(ctx, "Models.LookupOriginURL")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	for _, createURL := range md.Model {
+		if createURL.URL == originURL {
+			return createURL, nil
+		}
+	}
+
+	err := fmt.Errorf("originURL %s: %w", originURL, ErrNotFound)
+	span.RecordError(err)
+
+	return CreateURL{}, err
 }
 
-func (md *Models) DeleteURLs(ctx context.Context, id []int) error {
-	return fmt.Errorf("method has not implemented")
+func (md *Models) LookupUser(ctx context.Context, userID string) ([]CreateURL, error) {
+	_, span := startFileSpan(ctx, "Models.LookupUser")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	model := make([]CreateURL, 0)
+	for _, createURL := range md.Model {
+		if createURL.User == userID {
+			model = append(model, createURL)
+		}
+	}
+	sort.Slice(model, func(i, j int) bool { return model[i].ID < model[j].ID })
+
+	return model, nil
+}
+
+func (md *Models) RestoreURLs(ctx context.Context, ids []int) error {
+	_, span := startFileSpan(ctx, "Models.RestoreURLs")
+	defer span.End()
+
+	md.setDeleted(ids, false)
+
+	return nil
+}
+
+func (md *Models) AddAudit(ctx context.Context, record AuditRecord) error {
+	_, span := startFileSpan(ctx, "Models.AddAudit")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	lastID := 0
+	if n := len(md.Audit); n > 0 {
+		lastID = md.Audit[n-1].ID
+	}
+	record.ID = nextID(&md.nextAuditID, lastID)
+	md.Audit = append(md.Audit, record)
+	if md.auditFile == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = md.auditFile.Write(append(data, '\n'))
+	span.RecordError(err)
+
+	return err
+}
+
+func (md *Models) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	_, span := startFileSpan(ctx, "Models.PurgeDeleted")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	var purged int
+	for id, createURL := range md.Model {
+		if createURL.Deleted && createURL.DeletedAt != nil && createURL.DeletedAt.Before(before) {
+			delete(md.Model, id)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (md *Models) ListUserURLs(ctx context.Context, query ListQuery) ([]CreateURL, error) {
+	_, span := startFileSpan(ctx, "Models.ListUserURLs")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	model := make([]CreateURL, 0)
+	for _, createURL := range md.Model {
+		if query.Match(createURL) {
+			model = append(model, createURL)
+		}
+	}
+
+	sort.Slice(model, func(i, j int) bool {
+		if query.Desc {
+			return model[i].ID > model[j].ID
+		}
+		return model[i].ID < model[j].ID
+	})
+	if query.Limit > 0 && len(model) > query.Limit {
+		model = model[:query.Limit]
+	}
+
+	return model, nil
+}
+
+func (md *Models) AddClick(ctx context.Context, id int, variant string) (int, error) {
+	_, span := startFileSpan(ctx, "Models.AddClick")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	createURL, ok := md.Model[id]
+	if !ok || createURL.Deleted {
+		return 0, ErrNotFound
+	}
+	if createURL.Exhausted() {
+		return 0, ErrExhausted
+	}
+
+	createURL.Clicks++
+	if variant != "" {
+		createURL.VariantClicks = createURL.VariantClicks.inc(variant)
+	}
+	md.Model[id] = createURL
+
+	return createURL.Clicks, nil
+}
+
+func (md *Models) UpdateURL(ctx context.Context, createURL CreateURL) error {
+	_, span := startFileSpan(ctx, "Models.UpdateURL")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	current, ok := md.Model[createURL.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	// The url table keeps destinations unique, deleted links included.
+	for id, other := range md.Model {
+		if id != createURL.ID && other.URL == createURL.URL {
+			return ErrDuplicateURL
+		}
+	}
+
+	if current.URL != createURL.URL {
+		current.History = append(current.History, URLVersion{
+			Version:     len(current.History) + 1,
+			OriginalURL: current.URL,
+			ReplacedAt:  time.Now().UTC(),
+		})
+	}
+	current.URL = createURL.URL
+	current.Redirect = createURL.Redirect
+	if !sameTime(current.ExpiresAt, createURL.ExpiresAt) {
+		current.ExpiryNotified = false
+	}
+	current.ExpiresAt = createURL.ExpiresAt
+	current.Rules = createURL.Rules
+	current.Variants = createURL.Variants
+	current.Query = createURL.Query
+	md.Model[createURL.ID] = current
+
+	return nil
+}
+
+func (md *Models) URLHistory(ctx context.Context, id int) ([]URLVersion, error) {
+	_, span := startFileSpan(ctx, "Models.URLHistory")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	versions := make([]URLVersion, 0, len(md.Model[id].History))
+
+	return append(versions, md.Model[id].History...), nil
+}
+
+func (md *Models) Records(ctx context.Context, afterID, limit int) ([]CreateURL, error) {
+	_, span := startFileSpan(ctx, "Models.Records")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	ids := make([]int, 0, len(md.Model))
+	for id := range md.Model {
+		if id > afterID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	records := make([]CreateURL, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, md.Model[id])
+	}
+
+	return records, nil
+}
+
+// ImportRecords stores records with their ids as given and writes the file
+// right away, so an interrupted import keeps everything imported so far.
+func (md *Models) ImportRecords(ctx context.Context, records []CreateURL) error {
+	_, span := startFileSpan(ctx, "Models.ImportRecords")
+	defer span.End()
+
+	md.mu.Lock()
+	for _, record := range records {
+		md.Model[record.ID] = record
+		if record.ID >= md.Counter {
+			md.Counter = record.ID + 1
+		}
+	}
+	md.mu.Unlock()
+
+	if md.File == nil {
+		return nil
+	}
+
+	err := md.updateDataFile()
+	span.RecordError(err)
+
+	return err
+}
+
+func (md *Models) CountRecords(ctx context.Context) (int, int, error) {
+	_, span := startFileSpan(ctx, "Models.CountRecords")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	var deleted int
+	for _, createURL := range md.Model {
+		if createURL.Deleted {
+			deleted++
+		}
+	}
+
+	return len(md.Model), deleted, nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Equal(*b)
+}
+
+func (md *Models) AddWebhook(ctx context.Context, webhook Webhook) (int, error) {
+	_, span := startFileSpan(ctx, "Models.AddWebhook")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	lastID := 0
+	if n := len(md.Webhooks); n > 0 {
+		lastID = md.Webhooks[n-1].ID
+	}
+	webhook.ID = nextID(&md.nextWebhookID, lastID)
+	webhook.CreatedAt = time.Now().UTC()
+	md.Webhooks = append(md.Webhooks, webhook)
+
+	return webhook.ID, nil
+}
+
+func (md *Models) UserWebhooks(ctx context.Context, userID string) ([]Webhook, error) {
+	_, span := startFileSpan(ctx, "Models.UserWebhooks")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	webhooks := make([]Webhook, 0)
+	for _, webhook := range md.Webhooks {
+		if webhook.User == userID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+
+	return webhooks, nil
+}
+
+func (md *Models) DeleteWebhook(ctx context.Context, userID string, id int) error {
+	_, span := startFileSpan(ctx, "Models.DeleteWebhook")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	for i, webhook := range md.Webhooks {
+		if webhook.ID != id || webhook.User != userID {
+			continue
+		}
+
+		md.Webhooks = append(md.Webhooks[:i:i], md.Webhooks[i+1:]...)
+		deliveries := make([]Delivery, 0, len(md.Deliveries))
+		for _, delivery := range md.Deliveries {
+			if delivery.WebhookID != id {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		md.Deliveries = deliveries
+
+		return nil
+	}
+
+	return ErrNotFound
+}
+
+func (md *Models) EnqueueEvent(ctx context.Context, event WebhookEvent) (int, error) {
+	_, span := startFileSpan(ctx, "Models.EnqueueEvent")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	now := time.Now().UTC()
+	var queued int
+	for _, webhook := range md.Webhooks {
+		if !event.Matches(webhook) {
+			continue
+		}
+
+		lastID := 0
+		if n := len(md.Deliveries); n > 0 {
+			lastID = md.Deliveries[n-1].ID
+		}
+		md.Deliveries = append(md.Deliveries, Delivery{
+			ID:            nextID(&md.nextDeliveryID, lastID),
+			WebhookID:     webhook.ID,
+			User:          webhook.User,
+			Event:         event.Type,
+			Payload:       event.Payload,
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		queued++
+	}
+
+	return queued, nil
+}
+
+func (md *Models) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	_, span := startFileSpan(ctx, "Models.ClaimDeliveries")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	webhooks := make(map[int]Webhook, len(md.Webhooks))
+	for _, webhook := range md.Webhooks {
+		webhooks[webhook.ID] = webhook
+	}
+
+	deliveries := make([]Delivery, 0)
+	for i := range md.Deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		delivery := &md.Deliveries[i]
+		if delivery.Status != DeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+
+		delivery.NextAttemptAt = now.Add(lease)
+		claimed := *delivery
+		claimed.URL = webhooks[delivery.WebhookID].URL
+		claimed.Secret = webhooks[delivery.WebhookID].Secret
+		deliveries = append(deliveries, claimed)
+	}
+
+	return deliveries, nil
+}
+
+func (md *Models) UpdateDelivery(ctx context.Context, delivery Delivery) error {
+	_, span := startFileSpan(ctx, "Models.UpdateDelivery")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	for i := range md.Deliveries {
+		if md.Deliveries[i].ID != delivery.ID {
+			continue
+		}
+
+		current := &md.Deliveries[i]
+		current.Status = delivery.Status
+		current.Attempts = delivery.Attempts
+		current.NextAttemptAt = delivery.NextAttemptAt
+		current.LastStatus = delivery.LastStatus
+		current.LastError = delivery.LastError
+		current.DeliveredAt = delivery.DeliveredAt
+
+		return nil
+	}
+
+	// The webhook was deleted while the delivery was being sent.
+	return nil
+}
+
+func (md *Models) ListDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error) {
+	_, span := startFileSpan(ctx, "Models.ListDeliveries")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	deliveries := make([]Delivery, 0)
+	for i := len(md.Deliveries) - 1; i >= 0; i-- {
+		if query.Limit > 0 && len(deliveries) == query.Limit {
+			break
+		}
+		if query.Match(md.Deliveries[i]) {
+			deliveries = append(deliveries, md.Deliveries[i])
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (md *Models) RetryDelivery(ctx context.Context, userID string, id int) error {
+	_, span := startFileSpan(ctx, "Models.RetryDelivery")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	for i := range md.Deliveries {
+		delivery := &md.Deliveries[i]
+		if delivery.ID != id || delivery.User != userID || delivery.Status != DeliveryDead {
+			continue
+		}
+
+		delivery.Status = DeliveryPending
+		delivery.Attempts = 0
+		delivery.NextAttemptAt = time.Now().UTC()
+
+		return nil
+	}
+
+	return ErrNotFound
+}
+
+func (md *Models) PruneDeliveries(ctx context.Context, before time.Time) (int, error) {
+	_, span := startFileSpan(ctx, "Models.PruneDeliveries")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	deliveries := make([]Delivery, 0, len(md.Deliveries))
+	for _, delivery := range md.Deliveries {
+		if delivery.Status == DeliveryPending || !delivery.CreatedAt.Before(before) {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	pruned := len(md.Deliveries) - len(deliveries)
+	md.Deliveries = deliveries
+
+	return pruned, nil
+}
+
+func (md *Models) MarkExpired(ctx context.Context, now time.Time) ([]CreateURL, error) {
+	_, span := startFileSpan(ctx, "Models.MarkExpired")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	expired := make([]CreateURL, 0)
+	for id, createURL := range md.Model {
+		if createURL.Deleted || createURL.ExpiryNotified || createURL.ExpiresAt == nil || createURL.ExpiresAt.After(now) {
+			continue
+		}
+
+		createURL.ExpiryNotified = true
+		md.Model[id] = createURL
+		expired = append(expired, createURL)
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].ID < expired[j].ID })
+
+	return expired, nil
+}
+
+func (md *Models) slugTaken(slug string, id int) bool {
+	for _, collection := range md.Collections {
+		if collection.Slug == slug && collection.ID != id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (md *Models) AddCollection(ctx context.Context, collection Collection) (int, error) {
+	_, span := startFileSpan(ctx, "Models.AddCollection")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	if md.slugTaken(collection.Slug, 0) {
+		return 0, ErrDuplicateSlug
+	}
+
+	lastID := 0
+	if n := len(md.Collections); n > 0 {
+		lastID = md.Collections[n-1].ID
+	}
+	collection.ID = nextID(&md.nextCollectionID, lastID)
+	collection.Clicks = 0
+	collection.LinkClicks = nil
+	collection.CreatedAt = time.Now().UTC()
+	collection.UpdatedAt = collection.CreatedAt
+	md.Collections = append(md.Collections, collection)
+
+	return collection.ID, nil
+}
+
+func (md *Models) UpdateCollection(ctx context.Context, collection Collection) error {
+	_, span := startFileSpan(ctx, "Models.UpdateCollection")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	for i := range md.Collections {
+		stored := &md.Collections[i]
+		if stored.ID != collection.ID {
+			continue
+		}
+		if md.slugTaken(collection.Slug, collection.ID) {
+			return ErrDuplicateSlug
+		}
+
+		stored.Slug = collection.Slug
+		stored.Title = collection.Title
+		stored.Description = collection.Description
+		stored.Links = append([]CollectionItem(nil), collection.Links...)
+		stored.UpdatedAt = time.Now().UTC()
+
+		return nil
+	}
+
+	return ErrNotFound
+}
+
+func (md *Models) DeleteCollection(ctx context.Context, userID string, id int) error {
+	_, span := startFileSpan(ctx, "Models.DeleteCollection")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	for i, collection := range md.Collections {
+		if collection.ID == id && collection.User == userID {
+			md.Collections = append(md.Collections[:i:i], md.Collections[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (md *Models) UserCollections(ctx context.Context, userID string) ([]Collection, error) {
+	_, span := startFileSpan(ctx, "Models.UserCollections")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	collections := make([]Collection, 0)
+	for _, collection := range md.Collections {
+		if collection.User == userID {
+			collections = append(collections, collection)
+		}
+	}
+
+	return collections, nil
+}
+
+func (md *Models) LookupCollection(ctx context.Context, id int) (Collection, error) {
+	_, span := startFileSpan(ctx, "Models.LookupCollection")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	for _, collection := range md.Collections {
+		if collection.ID == id {
+			return collection, nil
+		}
+	}
+
+	return Collection{}, ErrNotFound
+}
+
+func (md *Models) GetCollection(ctx context.Context, slug string) (Collection, error) {
+	_, span := startFileSpan(ctx, "Models.GetCollection")
+	defer span.End()
+
+	md.mu.RLock()
+	defer md.mu.RUnlock()
+
+	for _, collection := range md.Collections {
+		if collection.Slug == slug {
+			return collection, nil
+		}
+	}
+
+	return Collection{}, ErrNotFound
+}
+
+func (md *Models) AddCollectionClick(ctx context.Context, id, linkID int) error {
+	_, span := startFileSpan(ctx, "Models.AddCollectionClick")
+	defer span.End()
+
+	md.mu.Lock()
+	defer md.mu.Unlock()
+
+	for i := range md.Collections {
+		collection := &md.Collections[i]
+		if collection.ID != id {
+			continue
+		}
+
+		collection.LinkClicks = collection.LinkClicks.inc(strconv.Itoa(linkID))
+		collection.Clicks++
+
+		return nil
+	}
+
+	return ErrNotFound
 }
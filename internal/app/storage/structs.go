@@ -1,7 +1,19 @@
 package storage
 
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
 type Request struct {
-	URL string `json:"url"`
+	URL       string        `json:"url"`
+	Redirect  string        `json:"redirect,omitempty"`
+	Password  string        `json:"password,omitempty"`
+	MaxClicks int           `json:"max_clicks,omitempty"`
+	Rules     Rules         `json:"rules,omitempty"`
+	Variants  Variants      `json:"variants,omitempty"`
+	Query     *QueryOptions `json:"query,omitempty"`
 }
 
 type Response struct {
@@ -9,13 +21,49 @@ type Response struct {
 }
 
 type ShortURLs struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string        `json:"short_url"`
+	OriginalURL string        `json:"original_url"`
+	CreatedAt   time.Time     `json:"created_at"`
+	Status      string        `json:"status"`
+	Redirect    string        `json:"redirect,omitempty"`
+	Protected   bool          `json:"protected,omitempty"`
+	Clicks      int           `json:"clicks,omitempty"`
+	MaxClicks   int           `json:"max_clicks,omitempty"`
+	ExpiresAt   *time.Time    `json:"expires_at,omitempty"`
+	Rules       Rules         `json:"rules,omitempty"`
+	Variants    Variants      `json:"variants,omitempty"`
+	Query       *QueryOptions `json:"query,omitempty"`
+}
+
+// URLPatch is the body of a link update. Fields left out keep their value;
+// an explicit null expires_at removes the expiry. Weights change the
+// weights of existing variants by name.
+type URLPatch struct {
+	OriginalURL *string         `json:"original_url"`
+	Redirect    *string         `json:"redirect"`
+	ExpiresAt   json.RawMessage `json:"expires_at"`
+	Rules       *Rules          `json:"rules"`
+	Variants    *Variants       `json:"variants"`
+	Weights     map[string]int  `json:"weights"`
+	Query       *QueryOptions   `json:"query"`
+}
+
+// URLVersion is a destination a link had before it was changed.
+type URLVersion struct {
+	Version     int       `json:"version"`
+	OriginalURL string    `json:"original_url"`
+	ReplacedAt  time.Time `json:"replaced_at"`
 }
 
 type BatchRequest struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginURL     string `json:"original_url"`
+	CorrelationID string        `json:"correlation_id"`
+	OriginURL     string        `json:"original_url"`
+	Redirect      string        `json:"redirect,omitempty"`
+	Password      string        `json:"password,omitempty"`
+	MaxClicks     int           `json:"max_clicks,omitempty"`
+	Rules         Rules         `json:"rules,omitempty"`
+	Variants      Variants      `json:"variants,omitempty"`
+	Query         *QueryOptions `json:"query,omitempty"`
 }
 
 type BatchResponse struct {
@@ -23,10 +71,174 @@ type BatchResponse struct {
 	ShortURL      string `json:"short_url"`
 }
 
+type ImportRow struct {
+	Line        int        `json:"-"`
+	Error       string     `json:"-"`
+	OriginalURL string     `json:"original_url"`
+	Alias       string     `json:"alias"`
+	ExpiresAt   string     `json:"expires_at"`
+	Expiry      *time.Time `json:"-"`
+}
+
+type ImportRowResult struct {
+	Row      int      `json:"row"`
+	ShortURL string   `json:"short_url,omitempty"`
+	Existed  bool     `json:"existed,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type ImportResult struct {
+	Imported int               `json:"imported"`
+	Existing int               `json:"existing"`
+	Failed   int               `json:"failed"`
+	Rows     []ImportRowResult `json:"rows"`
+}
+
+type Preview struct {
+	ShortURL    string    `json:"short_url"`
+	OriginalURL string    `json:"original_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Clicks      int       `json:"clicks"`
+	Protected   bool      `json:"protected,omitempty"`
+}
+
+type Stats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
 type CreateURL struct {
-	ID   int
-	User string
-	URL  string
+	ID           int
+	User         string
+	URL          string
+	Deleted      bool       `json:",omitempty"`
+	DeletedAt    *time.Time `json:",omitempty"`
+	CreatedAt    time.Time
+	Redirect     string     `json:",omitempty"`
+	Clicks       int        `json:",omitempty"`
+	PasswordHash string     `json:",omitempty"`
+	MaxClicks    int        `json:",omitempty"`
+	ExpiresAt    *time.Time `json:",omitempty"`
+	Rules        Rules      `json:",omitempty"`
+	Variants     Variants   `json:",omitempty"`
+	// VariantClicks counts the clicks each variant has served.
+	VariantClicks ClickCounts   `json:",omitempty"`
+	Query         *QueryOptions `json:",omitempty"`
+	// ExpiryNotified is set once link.expired has been queued for the link.
+	ExpiryNotified bool `json:",omitempty"`
+	// History is kept inline by Models; Database keeps it in url_history and
+	// only fills it in Records.
+	History []URLVersion `json:",omitempty"`
+}
+
+// Redirect kinds a link can be created with. A link without one follows
+// the server default.
+const (
+	RedirectMovedPermanently = "301"
+	RedirectFound            = "302"
+	RedirectTemporary        = "307"
+	RedirectPermanent        = "308"
+	RedirectInterstitial     = "interstitial"
+)
+
+func ValidRedirect(kind string) bool {
+	switch kind {
+	case RedirectMovedPermanently, RedirectFound, RedirectTemporary, RedirectPermanent, RedirectInterstitial:
+		return true
+	}
+
+	return false
+}
+
+const (
+	StatusActive    = "active"
+	StatusDeleted   = "deleted"
+	StatusExhausted = "exhausted"
+	StatusExpired   = "expired"
+	StatusAll       = "all"
+)
+
+// ListQuery selects one page of a user's links. Pages are keyed by id:
+// AfterID is the last id of the previous page, or 0 for the first one.
+type ListQuery struct {
+	User        string
+	Limit       int
+	AfterID     int
+	Desc        bool
+	Status      string
+	Contains    string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+func (q ListQuery) Match(createURL CreateURL) bool {
+	switch {
+	case createURL.User != q.User:
+		return false
+	case q.Status != "" && q.Status != StatusAll && q.Status != createURL.Status():
+		return false
+	case q.AfterID > 0 && !q.Desc && createURL.ID <= q.AfterID, q.AfterID > 0 && q.Desc && createURL.ID >= q.AfterID:
+		return false
+	case !q.CreatedFrom.IsZero() && createURL.CreatedAt.Before(q.CreatedFrom):
+		return false
+	case !q.CreatedTo.IsZero() && !createURL.CreatedAt.Before(q.CreatedTo):
+		return false
+	case q.Contains != "" && !strings.Contains(strings.ToLower(createURL.URL), strings.ToLower(q.Contains)):
+		return false
+	}
+
+	return true
+}
+
+func (c CreateURL) Status() string {
+	if c.Deleted {
+		return StatusDeleted
+	}
+	if c.Expired() {
+		return StatusExpired
+	}
+	if c.Exhausted() {
+		return StatusExhausted
+	}
+
+	return StatusActive
+}
+
+// Exhausted reports whether the link has used up its clicks.
+func (c CreateURL) Exhausted() bool {
+	return c.MaxClicks > 0 && c.Clicks >= c.MaxClicks
+}
+
+// Expired reports whether the link is past its expiry time.
+func (c CreateURL) Expired() bool {
+	return c.ExpiresAt != nil && !time.Now().Before(*c.ExpiresAt)
+}
+
+type URLInfo struct {
+	ID          int           `json:"id"`
+	ShortURL    string        `json:"short_url"`
+	OriginalURL string        `json:"original_url"`
+	UserID      string        `json:"user_id"`
+	Deleted     bool          `json:"deleted"`
+	DeletedAt   *time.Time    `json:"deleted_at,omitempty"`
+	Redirect    string        `json:"redirect,omitempty"`
+	Protected   bool          `json:"protected,omitempty"`
+	Clicks      int           `json:"clicks,omitempty"`
+	MaxClicks   int           `json:"max_clicks,omitempty"`
+	ExpiresAt   *time.Time    `json:"expires_at,omitempty"`
+	Rules       Rules         `json:"rules,omitempty"`
+	Variants    Variants      `json:"variants,omitempty"`
+	Query       *QueryOptions `json:"query,omitempty"`
+}
+
+type AuditRecord struct {
+	ID         int       `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Action     string    `json:"action"`
+	Target     string    `json:"target"`
+	RemoteAddr string    `json:"remote_addr"`
+	RequestID  string    `json:"request_id"`
 }
 
 type ShortenBatch struct {
@@ -34,4 +246,11 @@ type ShortenBatch struct {
 	User          string
 	URL           string
 	CorrelationID string
+	Redirect      string
+	PasswordHash  string
+	MaxClicks     int
+	Rules         Rules
+	Variants      Variants
+	Query         *QueryOptions
+	ExpiresAt     *time.Time
 }
@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/Fedorova199/red-cat/internal/app/tracing"
+)
+
+func startDBSpan(ctx context.Context, name string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Default().Start(ctx, "storage."+name, tracing.KindClient)
+	span.SetAttribute("db.system", "postgresql")
+
+	return ctx, span
+}
+
+func startFileSpan(ctx context.Context, name string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Default().Start(ctx, "storage."+name, tracing.KindInternal)
+	span.SetAttribute("db.system", "file")
+
+	return ctx, span
+}
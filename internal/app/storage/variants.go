@@ -0,0 +1,87 @@
+package storage
+
+import "database/sql/driver"
+
+// Variant is one destination of a split link. Visitors are spread over the
+// variants in proportion to their weights; a weight of 0 pauses a variant.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+	// Clicks is only filled in when a link is shown to its owner.
+	Clicks int `json:"clicks,omitempty"`
+}
+
+type Variants []Variant
+
+func (v Variants) Value() (driver.Value, error) {
+	if len(v) == 0 {
+		return "[]", nil
+	}
+
+	return jsonValue(v)
+}
+
+func (v *Variants) Scan(src interface{}) error {
+	var variants Variants
+	if err := scanJSON(src, &variants); err != nil {
+		return err
+	}
+	if len(variants) == 0 {
+		variants = nil
+	}
+	*v = variants
+
+	return nil
+}
+
+// ClickCounts holds the clicks of a split link by variant name.
+type ClickCounts map[string]int
+
+func (c ClickCounts) Value() (driver.Value, error) {
+	if len(c) == 0 {
+		return "{}", nil
+	}
+
+	return jsonValue(c)
+}
+
+func (c *ClickCounts) Scan(src interface{}) error {
+	var counts ClickCounts
+	if err := scanJSON(src, &counts); err != nil {
+		return err
+	}
+	if len(counts) == 0 {
+		counts = nil
+	}
+	*c = counts
+
+	return nil
+}
+
+// inc returns a copy of c with one more click for name. Models hands out
+// records by value, so a map callers may still hold is never written to.
+func (c ClickCounts) inc(name string) ClickCounts {
+	counts := make(ClickCounts, len(c)+1)
+	for key, clicks := range c {
+		counts[key] = clicks
+	}
+	counts[name]++
+
+	return counts
+}
+
+// VariantStats returns the variants of the link with their click counts.
+func (c CreateURL) VariantStats() Variants {
+	if len(c.Variants) == 0 {
+		return nil
+	}
+
+	stats := make(Variants, 0, len(c.Variants))
+	for _, variant := range c.Variants {
+		variant.Clicks = c.VariantClicks[variant.Name]
+		stats = append(stats, variant)
+	}
+
+	return stats
+}
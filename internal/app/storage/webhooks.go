@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Link lifecycle events a webhook can subscribe to.
+const (
+	EventCreated        = "link.created"
+	EventDeleted        = "link.deleted"
+	EventExpired        = "link.expired"
+	EventClickThreshold = "link.click_threshold"
+)
+
+func ValidEvent(event string) bool {
+	switch event {
+	case EventCreated, EventDeleted, EventExpired, EventClickThreshold:
+		return true
+	}
+
+	return false
+}
+
+// Delivery states. Pending deliveries are retried until they are
+// delivered or run out of attempts and become dead.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is a user's subscription to link events. ClickThreshold is the
+// click count that triggers link.click_threshold.
+type Webhook struct {
+	ID             int       `json:"id"`
+	User           string    `json:"user_id"`
+	URL            string    `json:"url"`
+	Secret         string    `json:"secret,omitempty"`
+	Events         []string  `json:"events"`
+	ClickThreshold int       `json:"click_threshold,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (w Webhook) Subscribed(event string) bool {
+	for _, subscribed := range w.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookEvent is queued for every webhook of User that subscribed to
+// Type. Clicks is the click count of click_threshold events.
+type WebhookEvent struct {
+	User    string
+	Type    string
+	Clicks  int
+	Payload []byte
+}
+
+// Matches reports whether the webhook wants the event.
+func (e WebhookEvent) Matches(webhook Webhook) bool {
+	if webhook.User != e.User || !webhook.Subscribed(e.Type) {
+		return false
+	}
+
+	return e.Type != EventClickThreshold || webhook.ClickThreshold == e.Clicks
+}
+
+// Delivery is one event on its way to one webhook. URL and Secret come
+// from the webhook when the delivery is claimed.
+type Delivery struct {
+	ID            int             `json:"id"`
+	WebhookID     int             `json:"webhook_id"`
+	User          string          `json:"user_id"`
+	Event         string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastStatus    int             `json:"last_status,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+	URL           string          `json:"-"`
+	Secret        string          `json:"-"`
+}
+
+// DeliveryQuery selects a user's deliveries, newest first. WebhookID and
+// Status are optional filters.
+type DeliveryQuery struct {
+	User      string
+	WebhookID int
+	Status    string
+	Limit     int
+}
+
+func (q DeliveryQuery) Match(delivery Delivery) bool {
+	switch {
+	case delivery.User != q.User:
+		return false
+	case q.WebhookID > 0 && delivery.WebhookID != q.WebhookID:
+		return false
+	case q.Status != "" && delivery.Status != q.Status:
+		return false
+	}
+
+	return true
+}
+
+type WebhookRequest struct {
+	URL            string   `json:"url"`
+	Events         []string `json:"events"`
+	ClickThreshold int      `json:"click_threshold,omitempty"`
+}
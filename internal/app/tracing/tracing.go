@@ -0,0 +1,389 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+type SpanData struct {
+	Name         string
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Kind         SpanKind
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Error        string
+}
+
+type Span struct {
+	mu     sync.Mutex
+	data   SpanData
+	tracer *Tracer
+	ended  bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.data.SpanContext
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ended {
+		return
+	}
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]interface{})
+	}
+	s.data.Attributes[key] = value
+}
+
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ended {
+		s.data.Error = err.Error()
+	}
+}
+
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+const (
+	defaultQueueSize     = 2048
+	defaultBatchSize     = 256
+	defaultFlushInterval = 5 * time.Second
+)
+
+type Tracer struct {
+	exporter Exporter
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	t := &Tracer{
+		exporter: exporter,
+		queue:    make(chan SpanData, defaultQueueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if exporter != nil {
+		go t.run()
+	}
+
+	return t
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:  name,
+			Kind:  kind,
+			Start: time.Now(),
+		},
+	}
+
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		span.data.SpanContext.TraceID = parent.TraceID
+		span.data.SpanContext.Sampled = parent.Sampled
+		span.data.ParentSpanID = parent.SpanID
+	} else {
+		span.data.SpanContext.TraceID = newTraceID()
+		span.data.SpanContext.Sampled = true
+	}
+	span.data.SpanContext.SpanID = newSpanID()
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	if t == nil || t.exporter == nil {
+		return
+	}
+
+	select {
+	case t.queue <- data:
+	default:
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(defaultFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, defaultBatchSize)
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		t.exporter.Export(context.Background(), batch)
+		batch = make([]SpanData, 0, defaultBatchSize)
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= defaultBatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flush:
+			batch = t.drain(batch)
+			export()
+			close(flushed)
+		case <-t.done:
+			batch = t.drain(batch)
+			export()
+			return
+		}
+	}
+}
+
+func (t *Tracer) drain(batch []SpanData) []SpanData {
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+		default:
+			return batch
+		}
+	}
+}
+
+func (t *Tracer) ForceFlush(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+
+	if err := t.ForceFlush(ctx); err != nil {
+		return err
+	}
+	t.stopOnce.Do(func() {
+		close(t.done)
+	})
+
+	return t.exporter.Shutdown(ctx)
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultTracer = NewTracer(nil)
+)
+
+func Default() *Tracer {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+
+	return defaultTracer
+}
+
+func SetDefault(t *Tracer) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultTracer = t
+}
+
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return Default().Start(ctx, name, KindInternal)
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext(), true
+	}
+
+	if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok && sc.IsValid() {
+		return sc, true
+	}
+
+	return SpanContext{}, false
+}
+
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+const traceparentVersion = "00"
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent header")
+
+func ParseTraceparent(header string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	if parts[0] == traceparentVersion && len(parts) != 4 {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	var sc SpanContext
+	if err := decodeHex(parts[1], sc.TraceID[:]); err != nil {
+		return SpanContext{}, err
+	}
+	if err := decodeHex(parts[2], sc.SpanID[:]); err != nil {
+		return SpanContext{}, err
+	}
+
+	var flags [1]byte
+	if err := decodeHex(parts[3], flags[:]); err != nil {
+		return SpanContext{}, err
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	return sc, nil
+}
+
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("%s-%s-%s-%s", traceparentVersion, sc.TraceID, sc.SpanID, flags)
+}
+
+func decodeHex(s string, dst []byte) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return ErrInvalidTraceparent
+	}
+
+	if _, err := hex.Decode(dst, []byte(s)); err != nil {
+		return ErrInvalidTraceparent
+	}
+
+	return nil
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
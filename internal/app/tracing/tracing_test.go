@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+		sampled bool
+	}{
+		{
+			name:    "sampled #1",
+			header:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			sampled: true,
+		},
+		{
+			name:   "not sampled #2",
+			header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		},
+		{
+			name:    "zero trace id #3",
+			header:  "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "upper case #4",
+			header:  "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "garbage #5",
+			header:  "garbage",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.header)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.sampled, sc.Sampled)
+			assert.Equal(t, tt.header, FormatTraceparent(sc))
+		})
+	}
+}
+
+func TestTracer_ChildSpans(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(NewStdoutExporter(&buf))
+
+	parent, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+
+	ctx := ContextWithRemoteSpanContext(context.Background(), parent)
+	ctx, server := tracer.Start(ctx, "GET /{id}", KindServer)
+	_, child := tracer.Start(ctx, "storage.Database.Get", KindClient)
+	child.RecordError(errors.New("no rows"))
+	child.End()
+	server.End()
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	dec := json.NewDecoder(&buf)
+	var spans []stdoutSpan
+	for dec.More() {
+		var s stdoutSpan
+		require.NoError(t, dec.Decode(&s))
+		spans = append(spans, s)
+	}
+
+	require.Len(t, spans, 2)
+	assert.Equal(t, "storage.Database.Get", spans[0].Name)
+	assert.Equal(t, "no rows", spans[0].Error)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, "00f067aa0ba902b7", spans[1].ParentSpanID)
+	assert.Equal(t, parent.TraceID.String(), spans[0].TraceID)
+	assert.Equal(t, parent.TraceID.String(), spans[1].TraceID)
+}
+
+func TestOTLPExporter_Export(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var req otlpRequest
+		require.NoError(t, json.Unmarshal(b, &req))
+		requests <- req
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(NewOTLPExporter(collector.URL, "shortener", map[string]string{"Authorization": "secret"}))
+	ctx, span := tracer.Start(context.Background(), "GET /{id}", KindServer)
+	span.SetAttribute("http.status_code", 307)
+	span.SetAttribute("http.route", "/{id}")
+	_, child := tracer.Start(ctx, "storage.Database.Get", KindClient)
+	child.End()
+	span.End()
+
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	req := <-requests
+	require.Len(t, req.ResourceSpans, 1)
+	assert.Equal(t, "service.name", req.ResourceSpans[0].Resource.Attributes[0].Key)
+	assert.Equal(t, "shortener", *req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "storage.Database.Get", spans[0].Name)
+	assert.Equal(t, KindClient, spans[0].Kind)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, spans[1].TraceID, spans[0].TraceID)
+	assert.Len(t, spans[1].TraceID, 32)
+	assert.Equal(t, "http.route", spans[1].Attributes[0].Key)
+	assert.Equal(t, "307", *spans[1].Attributes[1].Value.IntValue)
+}
+
+func TestOTLPExporter_CollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/v1/traces", "shortener", nil)
+	err := exporter.Export(context.Background(), []SpanData{{Name: "span"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,64 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+var privateNets = parseNets(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"fc00::/7",
+)
+
+func parseNets(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+
+	return nets
+}
+
+// PublicIP reports whether a delivery may be sent to ip: loopback,
+// private, link-local, multicast and unspecified addresses are refused.
+func PublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// control runs on the resolved address of every connection the
+// dispatcher makes, so a host that resolves to a public address when the
+// webhook is created and to an internal one later is still refused.
+func (d *Dispatcher) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); !d.allowed(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/interfaces"
+)
+
+// Headers sent with every delivery. The signature covers the timestamp
+// header, a dot and the body, so receivers can reject replays.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	batchSize     = 10
+	firstRetry    = 30 * time.Second
+	maxRetry      = 6 * time.Hour
+	maxErrorBytes = 512
+	maxReadBytes  = 64 << 10
+	pruneInterval = time.Minute
+)
+
+// Dispatcher sends queued deliveries, retrying failed ones with
+// exponential backoff until they run out of attempts. It also queues
+// link.expired for links whose expiry has passed, and drops finished
+// deliveries once they are older than the retention.
+type Dispatcher struct {
+	storage     interfaces.Storage
+	notifier    *Notifier
+	client      *http.Client
+	interval    time.Duration
+	maxAttempts int
+	retention   time.Duration
+	pruned      time.Time
+	now         func() time.Time
+	allowed     func(net.IP) bool
+}
+
+func NewDispatcher(storage interfaces.Storage, notifier *Notifier, interval, timeout time.Duration, maxAttempts int, retention time.Duration) *Dispatcher {
+	d := &Dispatcher{
+		storage:     storage,
+		notifier:    notifier,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+		retention:   retention,
+		now:         time.Now,
+		allowed:     PublicIP,
+	}
+
+	dialer := &net.Dialer{Timeout: timeout, Control: d.control}
+	d.client = &http.Client{
+		Timeout: timeout,
+		// No proxy: the address check has to see the receiver itself.
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// A redirect is an answer like any other; following it could
+		// send the payload somewhere the owner never configured.
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return d
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.Dispatch(ctx)
+		}
+	}
+}
+
+// Dispatch queues expiry events and sends every delivery that is due.
+func (d *Dispatcher) Dispatch(ctx context.Context) {
+	now := d.now()
+	expired, err := d.storage.MarkExpired(ctx, now)
+	if err != nil {
+		logger.Default().Error("find expired urls", "error", err)
+	}
+	d.notifier.Notify(ctx, storage.EventExpired, expired...)
+
+	if now.Sub(d.pruned) >= pruneInterval {
+		d.pruned = now
+		if pruned, err := d.storage.PruneDeliveries(ctx, now.Add(-d.retention)); err != nil {
+			logger.Default().Error("prune webhook deliveries", "error", err)
+		} else if pruned > 0 {
+			logger.Default().Debug("webhook deliveries pruned", "count", pruned)
+		}
+	}
+
+	// Claimed deliveries are leased for long enough to send the whole
+	// batch, so another instance does not send them too.
+	lease := d.client.Timeout * (batchSize + 1)
+	for ctx.Err() == nil {
+		deliveries, err := d.storage.ClaimDeliveries(ctx, d.now(), lease, batchSize)
+		if err != nil {
+			logger.Default().Error("claim webhook deliveries", "error", err)
+			return
+		}
+
+		for _, delivery := range deliveries {
+			d.deliver(ctx, delivery)
+		}
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery storage.Delivery) {
+	code, err := d.send(ctx, delivery)
+	if ctx.Err() != nil {
+		// Shutting down; the lease runs out and the attempt is repeated.
+		return
+	}
+
+	now := d.now().UTC()
+	delivery.Attempts++
+	delivery.LastStatus = code
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = storage.DeliveryDelivered
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = storage.DeliveryDead
+	default:
+		delivery.NextAttemptAt = now.Add(Backoff(delivery.Attempts))
+	}
+	if err != nil {
+		delivery.LastError = err.Error()
+		if len(delivery.LastError) > maxErrorBytes {
+			delivery.LastError = delivery.LastError[:maxErrorBytes]
+		}
+	}
+
+	log := logger.Default().With("delivery_id", delivery.ID, "webhook_id", delivery.WebhookID, "event", delivery.Event)
+	if err := d.storage.UpdateDelivery(ctx, delivery); err != nil {
+		log.Error("store webhook delivery", "error", err)
+		return
+	}
+
+	switch delivery.Status {
+	case storage.DeliveryDelivered:
+		log.Debug("webhook delivered", "attempts", delivery.Attempts)
+	case storage.DeliveryDead:
+		log.Warn("webhook delivery dead", "attempts", delivery.Attempts, "error", delivery.LastError)
+	default:
+		log.Debug("webhook delivery failed", "attempts", delivery.Attempts, "next_attempt_at", delivery.NextAttemptAt, "error", delivery.LastError)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery storage.Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "red-cat-webhooks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(delivery.ID))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxReadBytes))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header of a delivery: the hex HMAC-SHA256 of
+// the timestamp, a dot and the body, keyed with the webhook secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the wait after the given number of failed attempts: 30s
+// doubling each time, up to 6h.
+func Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		return firstRetry
+	}
+
+	wait := firstRetry
+	for i := 1; i < attempts && wait < maxRetry; i++ {
+		wait *= 2
+	}
+	if wait > maxRetry {
+		wait = maxRetry
+	}
+
+	return wait
+}
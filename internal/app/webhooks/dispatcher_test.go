@@ -0,0 +1,295 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+// ServeHTTP answers with the queued codes in turn and with 200 once they
+// run out.
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+
+	code := http.StatusOK
+	if len(rc.codes) > 0 {
+		code, rc.codes = rc.codes[0], rc.codes[1:]
+	}
+	w.WriteHeader(code)
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return len(rc.requests)
+}
+
+const week = 7 * 24 * time.Hour
+
+func newDispatcher(models *storage.Models, maxAttempts int, now *time.Time) (*Dispatcher, *Notifier) {
+	notifier := NewNotifier(models, func(id int) string { return "http://short.ru/" + strconv.Itoa(id) })
+	dispatcher := NewDispatcher(models, notifier, time.Hour, time.Second, maxAttempts, week)
+	dispatcher.now = func() time.Time { return *now }
+	// The receivers in these tests listen on loopback.
+	dispatcher.allowed = func(net.IP) bool { return true }
+
+	return dispatcher, notifier
+}
+
+func TestDispatcher_Retry(t *testing.T) {
+	rc := &receiver{codes: []int{http.StatusInternalServerError}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	models := &storage.Models{
+		Model: map[int]storage.CreateURL{},
+		Webhooks: []storage.Webhook{
+			{ID: 1, User: "user", URL: server.URL, Secret: "secret", Events: []string{storage.EventCreated}},
+			{ID: 2, User: "other", URL: server.URL, Secret: "other", Events: []string{storage.EventCreated}},
+		},
+	}
+	now := time.Now()
+	dispatcher, notifier := newDispatcher(models, 3, &now)
+	ctx := context.Background()
+
+	notifier.Notify(ctx, storage.EventCreated, storage.CreateURL{ID: 7, User: "user", URL: "https://example.com"})
+	require.Len(t, models.Deliveries, 1)
+
+	now = now.Add(time.Second)
+	dispatcher.Dispatch(ctx)
+	require.Equal(t, 1, rc.count())
+	assert.Equal(t, storage.DeliveryPending, models.Deliveries[0].Status)
+	assert.Equal(t, 1, models.Deliveries[0].Attempts)
+	assert.Equal(t, http.StatusInternalServerError, models.Deliveries[0].LastStatus)
+	assert.Equal(t, "unexpected status 500", models.Deliveries[0].LastError)
+	assert.Equal(t, now.Add(Backoff(1)).UTC(), models.Deliveries[0].NextAttemptAt)
+
+	dispatcher.Dispatch(ctx)
+	assert.Equal(t, 1, rc.count(), "retried before the backoff")
+
+	now = now.Add(Backoff(1))
+	dispatcher.Dispatch(ctx)
+	require.Equal(t, 2, rc.count())
+	assert.Equal(t, storage.DeliveryDelivered, models.Deliveries[0].Status)
+	assert.Equal(t, 2, models.Deliveries[0].Attempts)
+	assert.Empty(t, models.Deliveries[0].LastError)
+	assert.NotNil(t, models.Deliveries[0].DeliveredAt)
+
+	r, body := rc.requests[1], rc.bodies[1]
+	assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	assert.Equal(t, storage.EventCreated, r.Header.Get(HeaderEvent))
+	assert.Equal(t, "1", r.Header.Get(HeaderDelivery))
+	assert.Equal(t, strconv.FormatInt(now.Unix(), 10), r.Header.Get(HeaderTimestamp))
+	assert.Equal(t, Sign("secret", r.Header.Get(HeaderTimestamp), body), r.Header.Get(HeaderSignature))
+
+	var payload Payload
+	require.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, storage.EventCreated, payload.Event)
+	assert.Equal(t, Link{ID: 7, ShortURL: "http://short.ru/7", OriginalURL: "https://example.com"}, payload.Link)
+}
+
+func TestDispatcher_DeadLetter(t *testing.T) {
+	rc := &receiver{codes: []int{http.StatusServiceUnavailable, http.StatusFound, http.StatusBadRequest, http.StatusBadRequest}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	models := &storage.Models{
+		Model:    map[int]storage.CreateURL{},
+		Webhooks: []storage.Webhook{{ID: 1, User: "user", URL: server.URL, Secret: "secret", Events: []string{storage.EventDeleted}}},
+	}
+	now := time.Now()
+	dispatcher, notifier := newDispatcher(models, 3, &now)
+	ctx := context.Background()
+
+	notifier.Notify(ctx, storage.EventDeleted, storage.CreateURL{ID: 1, User: "user", URL: "https://example.com"})
+	for i := 1; i <= 5; i++ {
+		now = now.Add(maxRetry)
+		dispatcher.Dispatch(ctx)
+	}
+
+	assert.Equal(t, 3, rc.count())
+	assert.Equal(t, storage.DeliveryDead, models.Deliveries[0].Status)
+	assert.Equal(t, 3, models.Deliveries[0].Attempts)
+	assert.Equal(t, http.StatusBadRequest, models.Deliveries[0].LastStatus)
+
+	require.NoError(t, models.RetryDelivery(ctx, "user", 1))
+	assert.Error(t, models.RetryDelivery(ctx, "user", 1))
+	dispatcher.Dispatch(ctx)
+	assert.Equal(t, 4, rc.count())
+	assert.Equal(t, storage.DeliveryPending, models.Deliveries[0].Status)
+	assert.Equal(t, 1, models.Deliveries[0].Attempts)
+
+	now = now.Add(maxRetry)
+	dispatcher.Dispatch(ctx)
+	assert.Equal(t, 5, rc.count())
+	assert.Equal(t, storage.DeliveryDelivered, models.Deliveries[0].Status)
+}
+
+func TestDispatcher_Expired(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	models := &storage.Models{
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "https://example.com/1", ExpiresAt: &past},
+			2: {ID: 2, User: "user", URL: "https://example.com/2", ExpiresAt: &future},
+			3: {ID: 3, User: "user", URL: "https://example.com/3", ExpiresAt: &past, Deleted: true},
+			4: {ID: 4, User: "user", URL: "https://example.com/4"},
+		},
+		Webhooks: []storage.Webhook{{ID: 1, User: "user", URL: server.URL, Secret: "secret", Events: []string{storage.EventExpired}}},
+	}
+	// Deliveries are queued at the wall clock time during Dispatch.
+	now := time.Now().Add(time.Second)
+	dispatcher, _ := newDispatcher(models, 3, &now)
+
+	dispatcher.Dispatch(context.Background())
+	require.Equal(t, 1, rc.count())
+	assert.Equal(t, storage.EventExpired, rc.requests[0].Header.Get(HeaderEvent))
+	assert.True(t, models.Model[1].ExpiryNotified)
+
+	var payload Payload
+	require.NoError(t, json.Unmarshal(rc.bodies[0], &payload))
+	assert.Equal(t, 1, payload.Link.ID)
+
+	dispatcher.Dispatch(context.Background())
+	assert.Equal(t, 1, rc.count(), "expiry reported twice")
+
+	// A new expiry is reported again once it passes.
+	require.NoError(t, models.UpdateURL(context.Background(), storage.CreateURL{ID: 1, URL: "https://example.com/1", ExpiresAt: &future}))
+	assert.False(t, models.Model[1].ExpiryNotified)
+	now = future.Add(-time.Second)
+	dispatcher.Dispatch(context.Background())
+	assert.Equal(t, 1, rc.count(), "reported before it passed")
+	now = future
+	dispatcher.Dispatch(context.Background())
+	assert.Equal(t, 3, rc.count(), "links 1 and 2 expire together")
+	assert.True(t, models.Model[2].ExpiryNotified)
+	assert.False(t, models.Model[3].ExpiryNotified)
+}
+
+func TestDispatcher_Prune(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-week - time.Hour)
+	models := &storage.Models{
+		Model: map[int]storage.CreateURL{},
+		Deliveries: []storage.Delivery{
+			{ID: 1, Status: storage.DeliveryDelivered, CreatedAt: old},
+			{ID: 2, Status: storage.DeliveryDead, CreatedAt: old},
+			{ID: 3, Status: storage.DeliveryPending, CreatedAt: old, NextAttemptAt: now.Add(time.Hour)},
+			{ID: 4, Status: storage.DeliveryDelivered, CreatedAt: now.Add(-time.Hour)},
+		},
+	}
+	dispatcher, _ := newDispatcher(models, 3, &now)
+
+	dispatcher.Dispatch(context.Background())
+	ids := make([]int, 0, len(models.Deliveries))
+	for _, delivery := range models.Deliveries {
+		ids = append(ids, delivery.ID)
+	}
+	assert.Equal(t, []int{3, 4}, ids)
+
+	// Pruning runs once a minute, not on every tick.
+	models.Deliveries = append(models.Deliveries, storage.Delivery{ID: 5, Status: storage.DeliveryDead, CreatedAt: old})
+	now = now.Add(time.Second)
+	dispatcher.Dispatch(context.Background())
+	assert.Len(t, models.Deliveries, 3)
+	now = now.Add(pruneInterval)
+	dispatcher.Dispatch(context.Background())
+	assert.Len(t, models.Deliveries, 2)
+}
+
+func TestDispatcher_PrivateAddress(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	models := &storage.Models{
+		Model:    map[int]storage.CreateURL{},
+		Webhooks: []storage.Webhook{{ID: 1, User: "user", URL: server.URL, Secret: "secret", Events: []string{storage.EventCreated}}},
+	}
+	notifier := NewNotifier(models, func(id int) string { return "http://short.ru/" + strconv.Itoa(id) })
+	dispatcher := NewDispatcher(models, notifier, time.Hour, time.Second, 3, week)
+	ctx := context.Background()
+
+	notifier.Notify(ctx, storage.EventCreated, storage.CreateURL{ID: 1, User: "user", URL: "https://example.com"})
+	dispatcher.Dispatch(ctx)
+
+	assert.Equal(t, 0, rc.count(), "loopback receiver was called")
+	require.Len(t, models.Deliveries, 1)
+	assert.Equal(t, storage.DeliveryPending, models.Deliveries[0].Status)
+	assert.Equal(t, 1, models.Deliveries[0].Attempts)
+	assert.Contains(t, models.Deliveries[0].LastError, ErrPrivateAddress.Error())
+}
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		{name: "public v4 #1", ip: "93.184.216.34", want: true},
+		{name: "public v6 #2", ip: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{name: "loopback #3", ip: "127.0.0.1"},
+		{name: "loopback v6 #4", ip: "::1"},
+		{name: "private 10 #5", ip: "10.1.2.3"},
+		{name: "private 172 #6", ip: "172.20.0.1"},
+		{name: "private 192 #7", ip: "192.168.1.1"},
+		{name: "metadata #8", ip: "169.254.169.254"},
+		{name: "unspecified #9", ip: "0.0.0.0"},
+		{name: "unique local v6 #10", ip: "fd00::1"},
+		{name: "link local v6 #11", ip: "fe80::1"},
+		{name: "mapped loopback #12", ip: "::ffff:127.0.0.1"},
+		{name: "shared space #13", ip: "100.64.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, PublicIP(net.ParseIP(tt.ip)))
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{name: "first retry #1", attempts: 1, want: 30 * time.Second},
+		{name: "doubles #2", attempts: 2, want: time.Minute},
+		{name: "doubles again #3", attempts: 4, want: 4 * time.Minute},
+		{name: "capped #4", attempts: 12, want: 6 * time.Hour},
+		{name: "no overflow #5", attempts: 1000, want: 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Backoff(tt.attempts))
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/interfaces"
+)
+
+// Payload is the body of every webhook request.
+type Payload struct {
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Link       Link      `json:"link"`
+	Threshold  int       `json:"threshold,omitempty"`
+}
+
+type Link struct {
+	ID          int        `json:"id"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	Clicks      int        `json:"clicks"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+const (
+	// thresholdTTL bounds how long the click thresholds of an owner are
+	// trusted, for webhooks changed through another instance.
+	thresholdTTL    = time.Minute
+	maxCachedOwners = 10000
+)
+
+type thresholds struct {
+	clicks   map[int]bool
+	loadedAt time.Time
+}
+
+// Notifier queues link events for the webhooks subscribed to them. A nil
+// Notifier drops every event.
+type Notifier struct {
+	storage  interfaces.Storage
+	shortURL func(id int) string
+
+	mu         sync.Mutex
+	owners     map[string]thresholds
+	generation int
+}
+
+func NewNotifier(storage interfaces.Storage, shortURL func(id int) string) *Notifier {
+	return &Notifier{
+		storage:  storage,
+		shortURL: shortURL,
+		owners:   make(map[string]thresholds),
+	}
+}
+
+// Notify queues event for each of the links. Failures are logged and
+// never fail the operation the event is about.
+func (n *Notifier) Notify(ctx context.Context, event string, links ...storage.CreateURL) {
+	if n == nil {
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, link := range links {
+		n.enqueue(ctx, storage.WebhookEvent{User: link.User, Type: event}, Payload{
+			Event:      event,
+			OccurredAt: now,
+			Link:       n.link(link),
+		})
+	}
+}
+
+// Clicked queues link.click_threshold for the webhooks whose threshold
+// the link has just reached with its clicks-th click.
+func (n *Notifier) Clicked(ctx context.Context, link storage.CreateURL, clicks int) {
+	if n == nil || clicks <= 0 || !n.watched(ctx, link.User, clicks) {
+		return
+	}
+
+	link.Clicks = clicks
+	n.enqueue(ctx, storage.WebhookEvent{User: link.User, Type: storage.EventClickThreshold, Clicks: clicks}, Payload{
+		Event:      storage.EventClickThreshold,
+		OccurredAt: time.Now().UTC(),
+		Link:       n.link(link),
+		Threshold:  clicks,
+	})
+}
+
+// watched reports whether a webhook of user waits for the clicks-th click.
+// It runs on every redirect, so the thresholds are cached per owner.
+func (n *Notifier) watched(ctx context.Context, user string, clicks int) bool {
+	n.mu.Lock()
+	owner, ok := n.owners[user]
+	generation := n.generation
+	n.mu.Unlock()
+
+	if !ok || time.Since(owner.loadedAt) > thresholdTTL {
+		webhooks, err := n.storage.UserWebhooks(ctx, user)
+		if err != nil {
+			logger.FromContext(ctx).Error("load webhooks", "user_id", user, "error", err)
+			return true
+		}
+
+		owner = thresholds{clicks: make(map[int]bool), loadedAt: time.Now()}
+		for _, webhook := range webhooks {
+			if webhook.Subscribed(storage.EventClickThreshold) && webhook.ClickThreshold > 0 {
+				owner.clicks[webhook.ClickThreshold] = true
+			}
+		}
+
+		n.mu.Lock()
+		// Thresholds loaded while the webhooks changed may be stale.
+		if n.generation == generation {
+			if len(n.owners) >= maxCachedOwners {
+				n.owners = make(map[string]thresholds)
+			}
+			n.owners[user] = owner
+		}
+		n.mu.Unlock()
+	}
+
+	return owner.clicks[clicks]
+}
+
+// forget drops the cached thresholds of user after its webhooks changed.
+func (n *Notifier) forget(user string) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.owners, user)
+	n.generation++
+}
+
+func (n *Notifier) link(createURL storage.CreateURL) Link {
+	return Link{
+		ID:          createURL.ID,
+		ShortURL:    n.shortURL(createURL.ID),
+		OriginalURL: createURL.URL,
+		Clicks:      createURL.Clicks,
+		ExpiresAt:   createURL.ExpiresAt,
+	}
+}
+
+func (n *Notifier) enqueue(ctx context.Context, event storage.WebhookEvent, payload Payload) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logger.FromContext(ctx).Error("encode webhook payload", "event", event.Type, "id", payload.Link.ID, "error", err)
+		return
+	}
+	event.Payload = data
+
+	if _, err := n.storage.EnqueueEvent(ctx, event); err != nil {
+		logger.FromContext(ctx).Error("queue webhook event", "event", event.Type, "id", payload.Link.ID, "error", err)
+	}
+}
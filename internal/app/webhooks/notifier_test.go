@@ -0,0 +1,62 @@
+package webhooks
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingStorage struct {
+	*storage.Models
+	lookups  int
+	enqueued int
+}
+
+func (s *countingStorage) UserWebhooks(ctx context.Context, userID string) ([]storage.Webhook, error) {
+	s.lookups++
+	return s.Models.UserWebhooks(ctx, userID)
+}
+
+func (s *countingStorage) EnqueueEvent(ctx context.Context, event storage.WebhookEvent) (int, error) {
+	s.enqueued++
+	return s.Models.EnqueueEvent(ctx, event)
+}
+
+func TestNotifier_Clicked(t *testing.T) {
+	ctx := context.Background()
+	counting := &countingStorage{Models: &storage.Models{
+		Model: map[int]storage.CreateURL{},
+		Webhooks: []storage.Webhook{
+			{ID: 1, User: "user", URL: "https://hooks.example.com", Events: []string{storage.EventClickThreshold}, ClickThreshold: 2},
+			{ID: 2, User: "user", URL: "https://hooks.example.com", Events: []string{storage.EventCreated}},
+		},
+	}}
+	notifier := NewNotifier(counting, func(id int) string { return "http://short.ru/" + strconv.Itoa(id) })
+	s := NewStorage(counting, notifier)
+
+	owned := storage.CreateURL{ID: 1, User: "user", URL: "https://example.com"}
+	other := storage.CreateURL{ID: 2, User: "other", URL: "https://example.org"}
+
+	for clicks := 1; clicks <= 3; clicks++ {
+		notifier.Clicked(ctx, owned, clicks)
+		notifier.Clicked(ctx, other, clicks)
+	}
+	assert.Equal(t, 1, counting.enqueued, "only the threshold click is queued")
+	assert.Equal(t, 2, counting.lookups, "thresholds are not cached")
+	require.Len(t, counting.Deliveries, 1)
+	assert.Equal(t, 1, counting.Deliveries[0].WebhookID)
+
+	_, err := s.AddWebhook(ctx, storage.Webhook{User: "other", URL: "https://hooks.example.org", Events: []string{storage.EventClickThreshold}, ClickThreshold: 3})
+	require.NoError(t, err)
+	notifier.Clicked(ctx, other, 3)
+	assert.Equal(t, 2, counting.enqueued, "new webhook not seen")
+
+	require.NoError(t, s.DeleteWebhook(ctx, "user", 1))
+	notifier.Clicked(ctx, owned, 2)
+	assert.Equal(t, 2, counting.enqueued, "deleted webhook still seen")
+	assert.Equal(t, 4, counting.lookups)
+}
@@ -0,0 +1,83 @@
+package webhooks
+
+import (
+	"context"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/Fedorova199/red-cat/internal/interfaces"
+)
+
+// Storage queues link.created and link.deleted for the links created and
+// deleted through it, whichever API asked for it. Webhooks changed through
+// it reach the click thresholds of the notifier at once.
+type Storage struct {
+	interfaces.Storage
+	notifier *Notifier
+}
+
+func NewStorage(s interfaces.Storage, notifier *Notifier) *Storage {
+	return &Storage{
+		Storage:  s,
+		notifier: notifier,
+	}
+}
+
+func (s *Storage) Set(ctx context.Context, createURL storage.CreateURL) (int, error) {
+	id, err := s.Storage.Set(ctx, createURL)
+	if err != nil {
+		return id, err
+	}
+
+	createURL.ID = id
+	s.notifier.Notify(ctx, storage.EventCreated, createURL)
+
+	return id, nil
+}
+
+func (s *Storage) PutBatch(ctx context.Context, shortBatch []storage.ShortenBatch) ([]storage.ShortenBatch, error) {
+	shortBatch, err := s.Storage.PutBatch(ctx, shortBatch)
+	if err != nil {
+		return shortBatch, err
+	}
+
+	links := make([]storage.CreateURL, 0, len(shortBatch))
+	for _, item := range shortBatch {
+		links = append(links, storage.CreateURL{ID: item.ID, User: item.User, URL: item.URL, ExpiresAt: item.ExpiresAt})
+	}
+	s.notifier.Notify(ctx, storage.EventCreated, links...)
+
+	return shortBatch, nil
+}
+
+// DeleteURLs reports only the links that were not deleted before.
+func (s *Storage) DeleteURLs(ctx context.Context, ids []int) error {
+	links := make([]storage.CreateURL, 0, len(ids))
+	for _, id := range ids {
+		// Ids that cannot be looked up are left to DeleteURLs itself.
+		createURL, err := s.Storage.Lookup(ctx, id)
+		if err == nil && !createURL.Deleted {
+			links = append(links, createURL)
+		}
+	}
+
+	if err := s.Storage.DeleteURLs(ctx, ids); err != nil {
+		return err
+	}
+	s.notifier.Notify(ctx, storage.EventDeleted, links...)
+
+	return nil
+}
+
+func (s *Storage) AddWebhook(ctx context.Context, webhook storage.Webhook) (int, error) {
+	id, err := s.Storage.AddWebhook(ctx, webhook)
+	s.notifier.forget(webhook.User)
+
+	return id, err
+}
+
+func (s *Storage) DeleteWebhook(ctx context.Context, userID string, id int) error {
+	err := s.Storage.DeleteWebhook(ctx, userID, id)
+	s.notifier.forget(userID)
+
+	return err
+}
@@ -0,0 +1,87 @@
+package workers
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/interfaces"
+)
+
+var ErrQueueFull = errors.New("delete queue is full")
+
+type DeleteTask struct {
+	User      string
+	IDs       []int
+	RequestID string
+}
+
+type Deleter struct {
+	storage interfaces.Storage
+	tasks   chan DeleteTask
+	running int32
+}
+
+func NewDeleter(storage interfaces.Storage, queueSize int) *Deleter {
+	return &Deleter{
+		storage: storage,
+		tasks:   make(chan DeleteTask, queueSize),
+	}
+}
+
+func (d *Deleter) Push(task DeleteTask) error {
+	select {
+	case d.tasks <- task:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (d *Deleter) Len() int {
+	return len(d.tasks)
+}
+
+func (d *Deleter) Running() bool {
+	return atomic.LoadInt32(&d.running) == 1
+}
+
+func (d *Deleter) Run(ctx context.Context) {
+	atomic.StoreInt32(&d.running, 1)
+	defer atomic.StoreInt32(&d.running, 0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.drain()
+			return
+		case task := <-d.tasks:
+			d.delete(task)
+		}
+	}
+}
+
+func (d *Deleter) drain() {
+	for {
+		select {
+		case task := <-d.tasks:
+			d.delete(task)
+		default:
+			return
+		}
+	}
+}
+
+func (d *Deleter) delete(task DeleteTask) {
+	log := logger.Default().With("request_id", task.RequestID, "user_id", task.User)
+	ctx := logger.WithContext(context.Background(), log)
+	ctx = logger.WithRequestID(ctx, task.RequestID)
+
+	if err := d.storage.DeleteURLs(ctx, task.IDs); err != nil {
+		log.Error("delete urls", "ids", task.IDs, "error", err)
+		return
+	}
+
+	log.Debug("urls deleted", "ids", task.IDs)
+}
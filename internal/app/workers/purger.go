@@ -0,0 +1,51 @@
+package workers
+
+import (
+	"context"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/logger"
+	"github.com/Fedorova199/red-cat/internal/interfaces"
+)
+
+// Purger hard-deletes links that stayed soft-deleted for longer than the
+// restore window.
+type Purger struct {
+	storage  interfaces.Storage
+	window   time.Duration
+	interval time.Duration
+}
+
+func NewPurger(storage interfaces.Storage, window, interval time.Duration) *Purger {
+	return &Purger{
+		storage:  storage,
+		window:   window,
+		interval: interval,
+	}
+}
+
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Purge(ctx)
+		}
+	}
+}
+
+func (p *Purger) Purge(ctx context.Context) {
+	purged, err := p.storage.PurgeDeleted(ctx, time.Now().Add(-p.window))
+	if err != nil {
+		logger.Default().Error("purge deleted urls", "error", err)
+		return
+	}
+
+	if purged > 0 {
+		logger.Default().Info("deleted urls purged", "count", purged)
+	}
+}
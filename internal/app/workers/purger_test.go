@@ -0,0 +1,29 @@
+package workers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Fedorova199/red-cat/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurger_Purge(t *testing.T) {
+	recently := time.Now().Add(-time.Minute)
+	longAgo := time.Now().Add(-2 * time.Hour)
+	models := &storage.Models{
+		Counter: 4,
+		Model: map[int]storage.CreateURL{
+			1: {ID: 1, User: "user", URL: "test1.ru", Deleted: true, DeletedAt: &longAgo},
+			2: {ID: 2, User: "user", URL: "test2.ru", Deleted: true, DeletedAt: &recently},
+			3: {ID: 3, User: "user", URL: "test3.ru"},
+		},
+	}
+
+	NewPurger(models, time.Hour, time.Hour).Purge(context.Background())
+
+	assert.NotContains(t, models.Model, 1)
+	assert.Contains(t, models.Model, 2)
+	assert.Contains(t, models.Model, 3)
+}
@@ -3,6 +3,7 @@ package interfaces
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/Fedorova199/red-cat/internal/app/storage"
 )
@@ -15,6 +16,35 @@ type Storage interface {
 	PutBatch(ctx context.Context, shortBatch []storage.ShortenBatch) ([]storage.ShortenBatch, error)
 	Ping(ctx context.Context) error
 	DeleteURLs(ctx context.Context, ids []int) error
+	CountURLs(ctx context.Context) (int, error)
+	CountUsers(ctx context.Context) (int, error)
+	Lookup(ctx context.Context, id int) (storage.CreateURL, error)
+	LookupOriginURL(ctx context.Context, originURL string) (storage.CreateURL, error)
+	LookupUser(ctx context.Context, userID string) ([]storage.CreateURL, error)
+	RestoreURLs(ctx context.Context, ids []int) error
+	AddAudit(ctx context.Context, record storage.AuditRecord) error
+	PurgeDeleted(ctx context.Context, before time.Time) (int, error)
+	ListUserURLs(ctx context.Context, query storage.ListQuery) ([]storage.CreateURL, error)
+	AddClick(ctx context.Context, id int, variant string) (int, error)
+	UpdateURL(ctx context.Context, createURL storage.CreateURL) error
+	URLHistory(ctx context.Context, id int) ([]storage.URLVersion, error)
+	MarkExpired(ctx context.Context, now time.Time) ([]storage.CreateURL, error)
+	AddWebhook(ctx context.Context, webhook storage.Webhook) (int, error)
+	UserWebhooks(ctx context.Context, userID string) ([]storage.Webhook, error)
+	DeleteWebhook(ctx context.Context, userID string, id int) error
+	EnqueueEvent(ctx context.Context, event storage.WebhookEvent) (int, error)
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]storage.Delivery, error)
+	UpdateDelivery(ctx context.Context, delivery storage.Delivery) error
+	ListDeliveries(ctx context.Context, query storage.DeliveryQuery) ([]storage.Delivery, error)
+	RetryDelivery(ctx context.Context, userID string, id int) error
+	PruneDeliveries(ctx context.Context, before time.Time) (int, error)
+	AddCollection(ctx context.Context, collection storage.Collection) (int, error)
+	UpdateCollection(ctx context.Context, collection storage.Collection) error
+	DeleteCollection(ctx context.Context, userID string, id int) error
+	UserCollections(ctx context.Context, userID string) ([]storage.Collection, error)
+	LookupCollection(ctx context.Context, id int) (storage.Collection, error)
+	GetCollection(ctx context.Context, slug string) (storage.Collection, error)
+	AddCollectionClick(ctx context.Context, id, linkID int) error
 }
 
 type Middleware interface {